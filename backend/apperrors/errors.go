@@ -0,0 +1,172 @@
+// Package apperrors defines the errors handlers and middleware report with
+// c.Error. The Errors middleware renders them as RFC 7807 problem details;
+// any other error is reported as an internal error without exposing its
+// message.
+package apperrors
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kind classifies an error and selects its HTTP status
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindUnprocessable
+	KindRateLimited
+	KindUpstream
+	KindTimeout
+	KindCanceled
+)
+
+// StatusClientClosedRequest is reported when the client disconnected before
+// the response was ready. It is not a standard status; the client never
+// receives it, but logs and metrics do.
+const StatusClientClosedRequest = 499
+
+// Error is an error with a stable, machine-readable code and a message that
+// is safe to show to the caller. Err holds the underlying cause, which is
+// logged but never sent.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+// FieldError describes why one field of the request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status reported for the error
+func (e *Error) Status() int {
+	switch e.Kind {
+	case KindValidation:
+		return http.StatusBadRequest
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindUnprocessable:
+		return http.StatusUnprocessableEntity
+	case KindRateLimited:
+		return http.StatusTooManyRequests
+	case KindUpstream:
+		return http.StatusServiceUnavailable
+	case KindTimeout:
+		return http.StatusGatewayTimeout
+	case KindCanceled:
+		return StatusClientClosedRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Validation reports a request that is malformed or has invalid fields
+func Validation(code, message string, fields ...FieldError) *Error {
+	return &Error{Kind: KindValidation, Code: code, Message: message, Fields: fields}
+}
+
+// Unauthorized reports missing or invalid credentials
+func Unauthorized(code, message string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Message: message}
+}
+
+// Forbidden reports a caller that may not perform the request
+func Forbidden(code, message string) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Message: message}
+}
+
+// NotFound reports a resource that does not exist or is hidden from the caller
+func NotFound(code, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message}
+}
+
+// Conflict reports a request that conflicts with the resource's current state
+func Conflict(code, message string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Message: message}
+}
+
+// Unprocessable reports a well-formed request that cannot be processed
+func Unprocessable(code, message string) *Error {
+	return &Error{Kind: KindUnprocessable, Code: code, Message: message}
+}
+
+// RateLimited reports a caller that has exceeded its rate limit
+func RateLimited(message string) *Error {
+	return &Error{Kind: KindRateLimited, Code: "rate_limited", Message: message}
+}
+
+// Upstream reports a failure of a dependency such as Cloud Storage or an
+// identity provider
+func Upstream(code, message string, err error) *Error {
+	return &Error{Kind: KindUpstream, Code: code, Message: message, Err: err}
+}
+
+// Internal reports an unexpected failure; message describes the failed
+// operation and err its cause
+func Internal(message string, err error) *Error {
+	return &Error{Kind: KindInternal, Code: "internal_error", Message: message, Err: err}
+}
+
+// Lookup reports a failed lookup of a single resource: a missing document as
+// not found, anything else as an internal error wrapping err
+func Lookup(err error, resource string) *Error {
+	if status.Code(err) == codes.NotFound {
+		return NotFound("not_found", resource+" not found")
+	}
+	return Internal("Failed to load "+strings.ToLower(resource), err)
+}
+
+// From returns err as an *Error. Internal and upstream errors caused by a
+// timeout, an unavailable dependency or a client that disconnected are
+// reported as such, so they are not mistaken for bugs.
+func From(err error) *Error {
+	var appErr *Error
+	if !errors.As(err, &appErr) {
+		appErr = Internal("An unexpected error occurred", err)
+	}
+	if appErr.Kind != KindInternal && appErr.Kind != KindUpstream {
+		return appErr
+	}
+
+	switch {
+	case errors.Is(appErr.Err, context.Canceled) || status.Code(appErr.Err) == codes.Canceled:
+		return &Error{Kind: KindCanceled, Code: "request_canceled", Message: "The request was canceled", Err: appErr}
+	case errors.Is(appErr.Err, context.DeadlineExceeded) || status.Code(appErr.Err) == codes.DeadlineExceeded:
+		return &Error{Kind: KindTimeout, Code: "timeout", Message: "The request timed out", Err: appErr}
+	case status.Code(appErr.Err) == codes.Unavailable:
+		return &Error{Kind: KindUpstream, Code: "unavailable", Message: "A required service is unavailable", Err: appErr}
+	}
+	return appErr
+}
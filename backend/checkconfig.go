@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/logging"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkConfig loads and validates the configuration without starting the
+// server, so a deploy fails before it creates a revision that would not
+// start. When envFile is set, the variables of that Cloud Run env-vars file
+// (env.yaml) are applied first, as Cloud Run would apply them.
+func checkConfig(envFile string) error {
+	if envFile != "" {
+		if err := applyEnvVarsFile(envFile); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	logging.Setup(cfg.Logging)
+
+	slog.Info("Configuration is valid", "config", cfg.Redacted())
+	return nil
+}
+
+// applyEnvVarsFile sets the environment variables listed in a YAML file of
+// NAME: value pairs, the format of gcloud run deploy --env-vars-file
+func applyEnvVarsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	var vars map[string]string
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for name, value := range vars {
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("setting %s from %s: %w", name, path, err)
+		}
+	}
+
+	return nil
+}
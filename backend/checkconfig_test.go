@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	const envFile = `ENVIRONMENT: production
+GOOGLE_CLOUD_PROJECT: rice-monitor
+STORAGE_BUCKET: rice-monitor-images
+CORS_ALLOWED_ORIGINS: https://rice-monitor.example.com
+METRICS_ENABLED: true
+`
+
+	tests := []struct {
+		name     string
+		clientID string
+		wantErr  string
+	}{
+		{name: "valid", clientID: "client-id.apps.googleusercontent.com"},
+		{name: "example client ID", clientID: "your-google-oauth-client-id.apps.googleusercontent.com", wantErr: "GOOGLE_CLIENT_ID is still the example value"},
+		{name: "missing client ID", wantErr: "GOOGLE_CLIENT_ID is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the secrets come from the environment, as in Cloud Build
+			for _, kv := range os.Environ() {
+				key, _, _ := strings.Cut(kv, "=")
+				t.Setenv(key, "")
+			}
+			t.Setenv("GOOGLE_CLIENT_ID", "")
+			t.Setenv("JWT_KEY_ENCRYPTION_KEY", "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+			t.Setenv("METRICS_TOKEN", "metrics-token")
+
+			path := filepath.Join(t.TempDir(), "env.yaml")
+			content := envFile
+			if tt.clientID != "" {
+				content += "GOOGLE_CLIENT_ID: " + tt.clientID + "\n"
+			}
+			if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := checkConfig(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
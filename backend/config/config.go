@@ -0,0 +1,351 @@
+// Package config loads the server configuration. Values start from built-in
+// defaults, are overridden by an optional YAML file named by CONFIG_FILE, and
+// then by environment variables, including those from a local .env file.
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environments the server can run in
+const (
+	EnvironmentDevelopment = "development"
+	EnvironmentStaging     = "staging"
+	EnvironmentProduction  = "production"
+)
+
+// Config is the complete server configuration
+type Config struct {
+	Environment     string                `yaml:"environment"`
+	Server          ServerConfig          `yaml:"server"`
+	Logging         LoggingConfig         `yaml:"logging"`
+	Metrics         MetricsConfig         `yaml:"metrics"`
+	Tracing         TracingConfig         `yaml:"tracing"`
+	Google          GoogleConfig          `yaml:"google"`
+	Datastore       DatastoreConfig       `yaml:"datastore"`
+	JWT             JWTConfig             `yaml:"jwt"`
+	Identity        IdentityConfig        `yaml:"identity"`
+	SMTP            SMTPConfig            `yaml:"smtp"`
+	Onboarding      OnboardingConfig      `yaml:"onboarding"`
+	Idempotency     IdempotencyConfig     `yaml:"idempotency"`
+	Purge           PurgeConfig           `yaml:"purge"`
+	RateLimits      RateLimitConfig       `yaml:"rate_limits"`
+	CORS            CORSConfig            `yaml:"cors"`
+	SecurityHeaders SecurityHeadersConfig `yaml:"security_headers"`
+}
+
+// ServerConfig configures the HTTP server. ShutdownTimeout bounds how long
+// in-flight requests may drain after SIGTERM; Cloud Run kills the instance 10
+// seconds after sending it. ReadinessTimeout bounds each dependency probe of
+// /readyz. TrustedProxies lists the IPs or CIDR ranges of the proxies in
+// front of the server, whose X-Forwarded-For header is believed; see
+// middleware.TrustProxies.
+type ServerConfig struct {
+	Port              string        `yaml:"port"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	ReadinessTimeout  time.Duration `yaml:"readiness_timeout"`
+	TrustedProxies    []string      `yaml:"trusted_proxies"`
+}
+
+// LoggingConfig selects the log level (debug, info, warn or error) and
+// format (json or text)
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// MetricsConfig controls the Prometheus /metrics endpoint. When Token is set,
+// scrapers must send it as a bearer token; it is required outside
+// development while the endpoint is enabled.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported: "none",
+// "stdout", or "otlp" to send them over OTLP/HTTP to OTLPEndpoint.
+// SampleRatio is the fraction of new traces recorded; a trace started by the
+// caller follows the caller's sampling decision.
+type TracingConfig struct {
+	Exporter     string  `yaml:"exporter"`
+	OTLPEndpoint string  `yaml:"otlp_endpoint"`
+	SampleRatio  float64 `yaml:"sample_ratio"`
+}
+
+type GoogleConfig struct {
+	ProjectID     string `yaml:"project_id"`
+	StorageBucket string `yaml:"storage_bucket"`
+	ClientID      string `yaml:"client_id"`
+}
+
+// DatastoreConfig bounds the Firestore and Cloud Storage work done for one
+// request. ScanTimeout applies to exports, analytics and cascading deletes,
+// which read whole collections, and UploadTimeout to image uploads, which
+// stream the client's request body.
+type DatastoreConfig struct {
+	OperationTimeout time.Duration `yaml:"operation_timeout"`
+	ScanTimeout      time.Duration `yaml:"scan_timeout"`
+	UploadTimeout    time.Duration `yaml:"upload_timeout"`
+}
+
+// JWTConfig selects how tokens are signed. RS256 and EdDSA use rotating key
+// pairs, whose private keys are encrypted in Firestore with KeyEncryptionKey,
+// a base64 AES-256 key required outside development; HS256 uses Secret and is
+// meant for local development.
+type JWTConfig struct {
+	Algorithm        string        `yaml:"algorithm"`
+	KeyRotation      time.Duration `yaml:"key_rotation"`
+	KeyEncryptionKey string        `yaml:"key_encryption_key"`
+	Secret           string        `yaml:"secret"`
+}
+
+type IdentityConfig struct {
+	OIDCProviders []OIDCProviderConfig `yaml:"oidc_providers"`
+	MagicLinkURL  string               `yaml:"magic_link_url"`
+}
+
+// OIDCProviderConfig configures an additional OpenID Connect provider.
+// LinkByEmail lets a first login claim the existing account with the same
+// verified email; otherwise the identity must be linked from that account.
+type OIDCProviderConfig struct {
+	Name        string `yaml:"name"`
+	Issuer      string `yaml:"issuer"`
+	ClientID    string `yaml:"client_id"`
+	JWKSURL     string `yaml:"jwks_url"`
+	LinkByEmail bool   `yaml:"link_by_email"`
+}
+
+// SMTPConfig configures outgoing email; it is disabled when Host is empty
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+type OnboardingConfig struct {
+	AllowedEmailDomains []string      `yaml:"allowed_email_domains"`
+	InvitationTTL       time.Duration `yaml:"invitation_ttl"`
+	AppURL              string        `yaml:"app_url"`
+}
+
+// IdempotencyConfig holds how long responses are kept for replay and how
+// long a request may hold its key before a retry can take it over
+type IdempotencyConfig struct {
+	TTL         time.Duration `yaml:"ttl"`
+	LockTimeout time.Duration `yaml:"lock_timeout"`
+}
+
+type PurgeConfig struct {
+	RetentionDays int           `yaml:"retention_days"`
+	Interval      time.Duration `yaml:"interval"`
+}
+
+// RateLimitConfig holds the limit of each route group
+type RateLimitConfig struct {
+	Auth      RateLimit `yaml:"auth"`
+	API       RateLimit `yaml:"api"`
+	Expensive RateLimit `yaml:"expensive"`
+}
+
+// CORSConfig controls which browser origins may call the API. Origins are
+// exact ("https://app.example.com"), wildcard subdomains
+// ("https://*.example.com") or "*" for any origin without credentials.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// SecurityHeadersConfig controls the security headers set on every response
+type SecurityHeadersConfig struct {
+	ContentSecurityPolicy string        `yaml:"content_security_policy"`
+	FrameOptions          string        `yaml:"frame_options"`
+	ReferrerPolicy        string        `yaml:"referrer_policy"`
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age"` // zero disables HSTS
+}
+
+// RateLimit allows Requests per Period on average, with bursts of up to
+// Requests. It is written as "<requests>/<period>", such as "300/1m".
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseRateLimit parses a limit written as "<requests>/<period>"
+func ParseRateLimit(value string) (RateLimit, error) {
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q must look like 100/1m", value)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q has an invalid request count", value)
+	}
+
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q has an invalid period", value)
+	}
+
+	return RateLimit{Requests: n, Period: d}, nil
+}
+
+func (rl RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", rl.Requests, rl.Period)
+}
+
+func (rl *RateLimit) UnmarshalYAML(value *yaml.Node) error {
+	limit, err := ParseRateLimit(value.Value)
+	if err != nil {
+		return err
+	}
+	*rl = limit
+	return nil
+}
+
+func (rl RateLimit) MarshalYAML() (interface{}, error) {
+	return rl.String(), nil
+}
+
+// defaultContentSecurityPolicy allows the bundled Swagger UI, which uses
+// inline scripts and styles, and nothing from other origins
+const defaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'"
+
+// defaultJWTSecret is the placeholder secret older deployments fell back to
+const defaultJWTSecret = "your-secret-key"
+
+// Default returns the configuration used when nothing overrides it
+func Default() *Config {
+	return &Config{
+		Environment: EnvironmentProduction,
+		Server: ServerConfig{
+			Port:              "8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute,
+			WriteTimeout:      2 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   8 * time.Second,
+			ReadinessTimeout:  3 * time.Second,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Tracing: TracingConfig{
+			Exporter:     "none",
+			OTLPEndpoint: "http://localhost:4318",
+			SampleRatio:  1,
+		},
+		Datastore: DatastoreConfig{
+			OperationTimeout: 10 * time.Second,
+			ScanTimeout:      time.Minute,
+			UploadTimeout:    2 * time.Minute,
+		},
+		JWT: JWTConfig{
+			Algorithm:   "RS256",
+			KeyRotation: 30 * 24 * time.Hour,
+		},
+		Identity: IdentityConfig{
+			MagicLinkURL: "http://localhost:3000/login/email",
+		},
+		SMTP: SMTPConfig{
+			Port: "587",
+			From: "no-reply@rice-monitor.local",
+		},
+		Onboarding: OnboardingConfig{
+			InvitationTTL: 7 * 24 * time.Hour,
+			AppURL:        "http://localhost:3000",
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:         24 * time.Hour,
+			LockTimeout: 3 * time.Minute,
+		},
+		Purge: PurgeConfig{
+			RetentionDays: 30,
+			Interval:      24 * time.Hour,
+		},
+		RateLimits: RateLimitConfig{
+			Auth:      RateLimit{Requests: 60, Period: time.Minute},
+			API:       RateLimit{Requests: 600, Period: time.Minute},
+			Expensive: RateLimit{Requests: 30, Period: time.Minute},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"http://localhost:3000"},
+			AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{
+				"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization",
+				"Accept", "Origin", "Cache-Control", "X-Requested-With",
+			},
+			AllowCredentials: true,
+			MaxAge:           12 * time.Hour,
+		},
+		SecurityHeaders: SecurityHeadersConfig{
+			ContentSecurityPolicy: defaultContentSecurityPolicy,
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "no-referrer",
+			HSTSMaxAge:            365 * 24 * time.Hour,
+		},
+	}
+}
+
+// EncryptionKey decodes KeyEncryptionKey, returning nil when it is not set
+func (jc JWTConfig) EncryptionKey() ([]byte, error) {
+	if jc.KeyEncryptionKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(jc.KeyEncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key is %d bytes, not 32", len(key))
+	}
+	return key, nil
+}
+
+// IsDevelopment reports whether the server runs in the development environment
+func (c *Config) IsDevelopment() bool {
+	return c.Environment == EnvironmentDevelopment
+}
+
+// Redacted renders the configuration as YAML with secrets masked, for
+// logging at startup
+func (c *Config) Redacted() string {
+	redacted := *c
+	redacted.JWT.Secret = mask(c.JWT.Secret)
+	redacted.JWT.KeyEncryptionKey = mask(c.JWT.KeyEncryptionKey)
+	redacted.SMTP.Password = mask(c.SMTP.Password)
+	redacted.Metrics.Token = mask(c.Metrics.Token)
+
+	out, err := yaml.Marshal(&redacted)
+	if err != nil {
+		return fmt.Sprintf("failed to render configuration: %v", err)
+	}
+	return string(out)
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
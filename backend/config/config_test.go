@@ -0,0 +1,215 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// productionEnv is the smallest environment a production server starts with
+var productionEnv = map[string]string{
+	"ENVIRONMENT":          "production",
+	"GOOGLE_CLOUD_PROJECT": "rice-monitor",
+	"STORAGE_BUCKET":       "rice-monitor-images",
+	"GOOGLE_CLIENT_ID":     "client-id.apps.googleusercontent.com",
+	// 32 zero bytes
+	"JWT_KEY_ENCRYPTION_KEY": "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+	"METRICS_TOKEN":          "metrics-token",
+	"CORS_ALLOWED_ORIGINS":   "https://rice-monitor.example.com",
+}
+
+// setEnv replaces the environment Load sees for the rest of the test
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(configFile, []byte(`
+environment: production
+google:
+  project_id: from-file
+  storage_bucket: images-from-file
+  client_id: client-from-file
+rate_limits:
+  api: 100/1m
+cors:
+  allowed_origins: ["https://app.example.com"]
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setEnv(t, map[string]string{
+		"CONFIG_FILE":              configFile,
+		"GOOGLE_CLOUD_PROJECT":     "from-env",
+		"RATE_LIMIT_AUTH":          "10/1s",
+		"ALLOWED_EMAIL_DOMAINS":    "irri.org, ,example.com",
+		"OIDC_PROVIDERS":           "Azure",
+		"OIDC_AZURE_ISSUER":        "https://login.example.com",
+		"OIDC_AZURE_CLIENT_ID":     "azure-client",
+		"OIDC_AZURE_LINK_BY_EMAIL": "true",
+		"JWT_KEY_ENCRYPTION_KEY":   productionEnv["JWT_KEY_ENCRYPTION_KEY"],
+		"METRICS_TOKEN":            productionEnv["METRICS_TOKEN"],
+	})
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "environment variable over file", got: cfg.Google.ProjectID, want: "from-env"},
+		{name: "file over default", got: cfg.Google.StorageBucket, want: "images-from-file"},
+		{name: "default", got: cfg.Server.Port, want: "8080"},
+		{name: "rate limit from file", got: cfg.RateLimits.API, want: RateLimit{Requests: 100, Period: time.Minute}},
+		{name: "rate limit from environment", got: cfg.RateLimits.Auth, want: RateLimit{Requests: 10, Period: time.Second}},
+		{name: "list", got: strings.Join(cfg.Onboarding.AllowedEmailDomains, ","), want: "irri.org,example.com"},
+		{name: "CORS origins", got: strings.Join(cfg.CORS.AllowedOrigins, ","), want: "https://app.example.com"},
+		{name: "OIDC provider", got: cfg.Identity.OIDCProviders[0], want: OIDCProviderConfig{Name: "azure", Issuer: "https://login.example.com", ClientID: "azure-client", LinkByEmail: true}},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configFile, []byte("environment: development\ncros:\n  max_age: 1h\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setEnv(t, map[string]string{"CONFIG_FILE": configFile})
+
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "cros") {
+		t.Errorf("got error %v, want one naming the unknown key", err)
+	}
+}
+
+func TestLoadDevelopmentDefaults(t *testing.T) {
+	setEnv(t, map[string]string{"ENVIRONMENT": "development"})
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Google.ProjectID != "rice-monitor-dev" || cfg.Google.StorageBucket != "rice-monitor-images-dev" {
+		t.Errorf("got project %q, bucket %q; want the development defaults", cfg.Google.ProjectID, cfg.Google.StorageBucket)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "production", env: map[string]string{}},
+		{name: "unknown environment", env: map[string]string{"ENVIRONMENT": "prod"}, wantErr: "ENVIRONMENT must be"},
+		{name: "missing project", env: map[string]string{"GOOGLE_CLOUD_PROJECT": ""}, wantErr: "GOOGLE_CLOUD_PROJECT is required"},
+		{name: "invalid port", env: map[string]string{"PORT": "http"}, wantErr: `PORT "http"`},
+		{name: "unparsable duration", env: map[string]string{"PURGE_INTERVAL": "daily"}, wantErr: "PURGE_INTERVAL"},
+		{name: "trusted proxies", env: map[string]string{"TRUSTED_PROXIES": "169.254.0.0/16, 10.0.0.1"}},
+		{name: "invalid trusted proxy", env: map[string]string{"TRUSTED_PROXIES": "cloud-run"}, wantErr: `TRUSTED_PROXIES entry "cloud-run"`},
+		{name: "no shutdown grace period", env: map[string]string{"SHUTDOWN_TIMEOUT": "0s"}, wantErr: "SHUTDOWN_TIMEOUT must be positive"},
+		{name: "unparsable rate limit", env: map[string]string{"RATE_LIMIT_API": "lots"}, wantErr: "RATE_LIMIT_API"},
+		{name: "missing key encryption key", env: map[string]string{"JWT_KEY_ENCRYPTION_KEY": ""}, wantErr: "JWT_KEY_ENCRYPTION_KEY is required"},
+		{name: "short key encryption key", env: map[string]string{"JWT_KEY_ENCRYPTION_KEY": "c2hvcnQ="}, wantErr: "JWT_KEY_ENCRYPTION_KEY must be 32 bytes"},
+		{name: "HS256 needs no key encryption key", env: map[string]string{"JWT_ALGORITHM": "HS256", "JWT_SECRET": "a-production-secret-of-32-characters", "JWT_KEY_ENCRYPTION_KEY": ""}},
+		{name: "metrics without token", env: map[string]string{"METRICS_TOKEN": ""}, wantErr: "METRICS_TOKEN is required"},
+		{name: "metrics disabled without token", env: map[string]string{"METRICS_ENABLED": "false", "METRICS_TOKEN": ""}},
+		{name: "unknown algorithm", env: map[string]string{"JWT_ALGORITHM": "none"}, wantErr: "JWT_ALGORITHM must be"},
+		{name: "default HS256 secret", env: map[string]string{"JWT_ALGORITHM": "HS256", "JWT_SECRET": "your-secret-key"}, wantErr: "JWT_SECRET"},
+		{name: "short HS256 secret", env: map[string]string{"JWT_ALGORITHM": "HS256", "JWT_SECRET": "short"}, wantErr: "at least 32 characters"},
+		{name: "example client ID", env: map[string]string{"GOOGLE_CLIENT_ID": "your-google-oauth-client-id.apps.googleusercontent.com"}, wantErr: "GOOGLE_CLIENT_ID is still the example value"},
+		{name: "local CORS origin", env: map[string]string{"CORS_ALLOWED_ORIGINS": "http://localhost:3000"}, wantErr: "must be the frontend's origin"},
+		{name: "example CORS origin", env: map[string]string{"CORS_ALLOWED_ORIGINS": "https://your-frontend.example.com"}, wantErr: "must be the frontend's origin"},
+		{name: "CORS origin without scheme", env: map[string]string{"CORS_ALLOWED_ORIGINS": "app.example.com"}, wantErr: "CORS origin"},
+		{name: "OIDC provider without issuer", env: map[string]string{"OIDC_PROVIDERS": "azure"}, wantErr: `"azure" needs an issuer`},
+		{name: "SMTP with invalid port", env: map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "smtp"}, wantErr: "SMTP_PORT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{}
+			for key, value := range productionEnv {
+				env[key] = value
+			}
+			for key, value := range tt.env {
+				env[key] = value
+			}
+			setEnv(t, env)
+
+			_, err := Load()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.JWT.Secret = "jwt-secret-value"
+	cfg.SMTP.Password = "smtp-password-value"
+	cfg.RateLimits.API = RateLimit{Requests: 100, Period: time.Minute}
+
+	redacted := cfg.Redacted()
+	for _, secret := range []string{"jwt-secret-value", "smtp-password-value"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("redacted configuration contains %q", secret)
+		}
+	}
+	if !strings.Contains(redacted, "api: 100/1m0s") {
+		t.Errorf("redacted configuration does not show the API rate limit:\n%s", redacted)
+	}
+	if cfg.JWT.Secret != "jwt-secret-value" {
+		t.Error("Redacted modified the configuration")
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    RateLimit
+		wantErr bool
+	}{
+		{value: "300/1m", want: RateLimit{Requests: 300, Period: time.Minute}},
+		{value: " 20 / 1h ", want: RateLimit{Requests: 20, Period: time.Hour}},
+		{value: "300", wantErr: true},
+		{value: "0/1m", wantErr: true},
+		{value: "10/soon", wantErr: true},
+		{value: "10/-1m", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseRateLimit(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %+v, %v; want %+v, error: %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
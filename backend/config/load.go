@@ -0,0 +1,377 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the configuration and validates it. A .env file in the working
+// directory is loaded into the environment first, without overriding
+// variables that are already set.
+func Load() (*Config, error) {
+	if _, err := os.Stat(".env"); err == nil {
+		if err := godotenv.Load(".env"); err != nil {
+			return nil, fmt.Errorf("loading .env: %w", err)
+		}
+	}
+
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Local development works without a Google Cloud project of its own
+	if cfg.IsDevelopment() {
+		if cfg.Google.ProjectID == "" {
+			cfg.Google.ProjectID = "rice-monitor-dev"
+		}
+		if cfg.Google.StorageBucket == "" {
+			cfg.Google.StorageBucket = "rice-monitor-images-dev"
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile overlays a YAML file; unknown keys are rejected so typos surface
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadEnv overlays the environment variables that are set
+func (c *Config) loadEnv() error {
+	env := &envReader{}
+
+	env.string(&c.Environment, "ENVIRONMENT")
+	env.string(&c.Server.Port, "PORT")
+	env.duration(&c.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT")
+	env.duration(&c.Server.ReadTimeout, "SERVER_READ_TIMEOUT")
+	env.duration(&c.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT")
+	env.duration(&c.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT")
+	env.duration(&c.Server.ShutdownTimeout, "SHUTDOWN_TIMEOUT")
+	env.duration(&c.Server.ReadinessTimeout, "READINESS_TIMEOUT")
+	env.list(&c.Server.TrustedProxies, "TRUSTED_PROXIES")
+
+	env.string(&c.Logging.Level, "LOG_LEVEL")
+	env.string(&c.Logging.Format, "LOG_FORMAT")
+
+	env.bool(&c.Metrics.Enabled, "METRICS_ENABLED")
+	env.string(&c.Metrics.Token, "METRICS_TOKEN")
+
+	env.string(&c.Tracing.Exporter, "TRACING_EXPORTER")
+	env.string(&c.Tracing.OTLPEndpoint, "TRACING_OTLP_ENDPOINT")
+	env.float(&c.Tracing.SampleRatio, "TRACING_SAMPLE_RATIO")
+
+	env.string(&c.Google.ProjectID, "GOOGLE_CLOUD_PROJECT")
+	env.string(&c.Google.StorageBucket, "STORAGE_BUCKET")
+	env.string(&c.Google.ClientID, "GOOGLE_CLIENT_ID")
+
+	env.duration(&c.Datastore.OperationTimeout, "DATASTORE_OPERATION_TIMEOUT")
+	env.duration(&c.Datastore.ScanTimeout, "DATASTORE_SCAN_TIMEOUT")
+	env.duration(&c.Datastore.UploadTimeout, "DATASTORE_UPLOAD_TIMEOUT")
+
+	env.string(&c.JWT.Algorithm, "JWT_ALGORITHM")
+	env.duration(&c.JWT.KeyRotation, "JWT_KEY_ROTATION")
+	env.string(&c.JWT.KeyEncryptionKey, "JWT_KEY_ENCRYPTION_KEY")
+	env.string(&c.JWT.Secret, "JWT_SECRET")
+
+	// OIDC_PROVIDERS replaces the providers from the config file; each name
+	// is configured by OIDC_<NAME>_ISSUER, _CLIENT_ID, _JWKS_URL and
+	// _LINK_BY_EMAIL
+	var oidcNames []string
+	env.list(&oidcNames, "OIDC_PROVIDERS")
+	if oidcNames != nil {
+		c.Identity.OIDCProviders = []OIDCProviderConfig{}
+		for _, name := range oidcNames {
+			prefix := "OIDC_" + strings.ToUpper(name) + "_"
+			provider := OIDCProviderConfig{
+				Name:     strings.ToLower(name),
+				Issuer:   os.Getenv(prefix + "ISSUER"),
+				ClientID: os.Getenv(prefix + "CLIENT_ID"),
+				JWKSURL:  os.Getenv(prefix + "JWKS_URL"),
+			}
+			env.bool(&provider.LinkByEmail, prefix+"LINK_BY_EMAIL")
+			c.Identity.OIDCProviders = append(c.Identity.OIDCProviders, provider)
+		}
+	}
+	env.string(&c.Identity.MagicLinkURL, "MAGIC_LINK_URL")
+
+	env.string(&c.SMTP.Host, "SMTP_HOST")
+	env.string(&c.SMTP.Port, "SMTP_PORT")
+	env.string(&c.SMTP.Username, "SMTP_USERNAME")
+	env.string(&c.SMTP.Password, "SMTP_PASSWORD")
+	env.string(&c.SMTP.From, "SMTP_FROM")
+
+	env.list(&c.Onboarding.AllowedEmailDomains, "ALLOWED_EMAIL_DOMAINS")
+	env.duration(&c.Onboarding.InvitationTTL, "INVITATION_TTL")
+	env.string(&c.Onboarding.AppURL, "APP_URL")
+
+	env.duration(&c.Idempotency.TTL, "IDEMPOTENCY_TTL")
+	env.duration(&c.Idempotency.LockTimeout, "IDEMPOTENCY_LOCK_TIMEOUT")
+
+	env.int(&c.Purge.RetentionDays, "SOFT_DELETE_RETENTION_DAYS")
+	env.duration(&c.Purge.Interval, "PURGE_INTERVAL")
+
+	env.rateLimit(&c.RateLimits.Auth, "RATE_LIMIT_AUTH")
+	env.rateLimit(&c.RateLimits.API, "RATE_LIMIT_API")
+	env.rateLimit(&c.RateLimits.Expensive, "RATE_LIMIT_EXPENSIVE")
+
+	env.list(&c.CORS.AllowedOrigins, "CORS_ALLOWED_ORIGINS")
+	env.list(&c.CORS.AllowedMethods, "CORS_ALLOWED_METHODS")
+	env.list(&c.CORS.AllowedHeaders, "CORS_ALLOWED_HEADERS")
+	env.bool(&c.CORS.AllowCredentials, "CORS_ALLOW_CREDENTIALS")
+	env.duration(&c.CORS.MaxAge, "CORS_MAX_AGE")
+
+	env.string(&c.SecurityHeaders.ContentSecurityPolicy, "CONTENT_SECURITY_POLICY")
+	env.string(&c.SecurityHeaders.FrameOptions, "FRAME_OPTIONS")
+	env.string(&c.SecurityHeaders.ReferrerPolicy, "REFERRER_POLICY")
+	env.duration(&c.SecurityHeaders.HSTSMaxAge, "HSTS_MAX_AGE")
+
+	return errors.Join(env.errs...)
+}
+
+// Validate reports every invalid or missing value at once. Outside
+// development the Google Cloud resources and client ID must be set explicitly.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	switch c.Environment {
+	case EnvironmentDevelopment, EnvironmentStaging, EnvironmentProduction:
+	default:
+		errs = append(errs, fmt.Errorf("ENVIRONMENT must be development, staging or production, not %q", c.Environment))
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "PORT %q is not a valid port", c.Server.Port)
+	check(c.Server.ReadHeaderTimeout > 0, "SERVER_READ_HEADER_TIMEOUT must be positive")
+	check(c.Server.ReadTimeout > 0, "SERVER_READ_TIMEOUT must be positive")
+	check(c.Server.WriteTimeout > 0, "SERVER_WRITE_TIMEOUT must be positive")
+	check(c.Server.IdleTimeout > 0, "SERVER_IDLE_TIMEOUT must be positive")
+	check(c.Server.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	check(c.Server.ReadinessTimeout > 0, "READINESS_TIMEOUT must be positive")
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		check(err == nil || net.ParseIP(proxy) != nil, "TRUSTED_PROXIES entry %q must be an IP address or CIDR range", proxy)
+	}
+
+	switch strings.ToLower(c.Logging.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, not %q", c.Logging.Level))
+	}
+	check(c.Logging.Format == "json" || c.Logging.Format == "text", "LOG_FORMAT must be json or text, not %q", c.Logging.Format)
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		u, err := url.Parse(c.Tracing.OTLPEndpoint)
+		check(err == nil && u.Scheme != "" && u.Host != "", "TRACING_OTLP_ENDPOINT %q must look like http://localhost:4318", c.Tracing.OTLPEndpoint)
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER must be none, stdout or otlp, not %q", c.Tracing.Exporter))
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+
+	if !c.IsDevelopment() {
+		check(c.Google.ProjectID != "", "GOOGLE_CLOUD_PROJECT is required")
+		check(c.Google.StorageBucket != "", "STORAGE_BUCKET is required")
+		check(c.Google.ClientID != "", "GOOGLE_CLIENT_ID is required")
+		check(!isExampleValue(c.Google.ClientID), "GOOGLE_CLIENT_ID is still the example value")
+	}
+
+	// The endpoint is public on Cloud Run, so it needs a token in production
+	check(!c.Metrics.Enabled || c.Metrics.Token != "" || c.IsDevelopment(), "METRICS_TOKEN is required outside development unless METRICS_ENABLED=false")
+
+	check(c.Datastore.OperationTimeout > 0, "DATASTORE_OPERATION_TIMEOUT must be positive")
+	check(c.Datastore.ScanTimeout > 0, "DATASTORE_SCAN_TIMEOUT must be positive")
+	check(c.Datastore.UploadTimeout > 0, "DATASTORE_UPLOAD_TIMEOUT must be positive")
+
+	switch c.JWT.Algorithm {
+	case "RS256", "EdDSA":
+		check(c.JWT.KeyRotation > 0, "JWT_KEY_ROTATION must be positive")
+		if c.JWT.KeyEncryptionKey != "" {
+			_, err := c.JWT.EncryptionKey()
+			check(err == nil, "JWT_KEY_ENCRYPTION_KEY must be 32 bytes encoded as base64")
+		} else {
+			check(c.IsDevelopment(), "JWT_KEY_ENCRYPTION_KEY is required outside development")
+		}
+	case "HS256":
+		if !c.IsDevelopment() {
+			check(c.JWT.Secret != "" && c.JWT.Secret != defaultJWTSecret, "JWT_SECRET must be set to a non-default value outside development")
+			check(len(c.JWT.Secret) >= 32, "JWT_SECRET must be at least 32 characters")
+		}
+	default:
+		errs = append(errs, fmt.Errorf("JWT_ALGORITHM must be RS256, EdDSA or HS256, not %q", c.JWT.Algorithm))
+	}
+
+	seen := map[string]bool{"google": true, "email": true}
+	for _, provider := range c.Identity.OIDCProviders {
+		check(provider.Name != "", "OIDC providers need a name")
+		check(!seen[provider.Name], "identity provider %q is configured twice", provider.Name)
+		check(provider.Issuer != "" && provider.ClientID != "", "OIDC provider %q needs an issuer and client ID", provider.Name)
+		seen[provider.Name] = true
+	}
+
+	if c.SMTP.Host != "" {
+		_, err := strconv.Atoi(c.SMTP.Port)
+		check(err == nil, "SMTP_PORT %q is not a valid port", c.SMTP.Port)
+		check(c.SMTP.From != "", "SMTP_FROM is required when SMTP_HOST is set")
+	}
+
+	check(c.Onboarding.InvitationTTL > 0, "INVITATION_TTL must be positive")
+	check(c.Idempotency.TTL > 0, "IDEMPOTENCY_TTL must be positive")
+	check(c.Idempotency.LockTimeout > 0, "IDEMPOTENCY_LOCK_TIMEOUT must be positive")
+	check(c.Purge.RetentionDays > 0, "SOFT_DELETE_RETENTION_DAYS must be positive")
+	check(c.Purge.Interval > 0, "PURGE_INTERVAL must be positive")
+
+	check(c.RateLimits.Auth.Requests > 0 && c.RateLimits.Auth.Period > 0, "RATE_LIMIT_AUTH must be like 60/1m")
+	check(c.RateLimits.API.Requests > 0 && c.RateLimits.API.Period > 0, "RATE_LIMIT_API must be like 600/1m")
+	check(c.RateLimits.Expensive.Requests > 0 && c.RateLimits.Expensive.Period > 0, "RATE_LIMIT_EXPENSIVE must be like 30/1m")
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		check(err == nil && u.Scheme != "" && u.Host != "", "CORS origin %q must look like https://example.com", origin)
+		if err == nil && !c.IsDevelopment() {
+			check(u.Hostname() != "localhost" && !isExampleValue(u.Host), "CORS origin %q must be the frontend's origin outside development", origin)
+		}
+	}
+	check(c.CORS.MaxAge >= 0, "CORS_MAX_AGE must not be negative")
+	check(c.SecurityHeaders.HSTSMaxAge >= 0, "HSTS_MAX_AGE must not be negative")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// envReader overlays set environment variables and collects parse errors
+type envReader struct {
+	errs []error
+}
+
+func (er *envReader) lookup(key string) (string, bool) {
+	value, ok := os.LookupEnv(key)
+	return value, ok && value != ""
+}
+
+func (er *envReader) string(dst *string, key string) {
+	if value, ok := er.lookup(key); ok {
+		*dst = value
+	}
+}
+
+// list splits a comma separated value, dropping empty entries
+func (er *envReader) list(dst *[]string, key string) {
+	value, ok := er.lookup(key)
+	if !ok {
+		return
+	}
+
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	*dst = values
+}
+
+func (er *envReader) int(dst *int, key string) {
+	if value, ok := er.lookup(key); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			er.errs = append(er.errs, fmt.Errorf("%s %q is not a number", key, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (er *envReader) float(dst *float64, key string) {
+	if value, ok := er.lookup(key); ok {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			er.errs = append(er.errs, fmt.Errorf("%s %q is not a number", key, value))
+			return
+		}
+		*dst = f
+	}
+}
+
+func (er *envReader) bool(dst *bool, key string) {
+	if value, ok := er.lookup(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			er.errs = append(er.errs, fmt.Errorf("%s %q is not true or false", key, value))
+			return
+		}
+		*dst = b
+	}
+}
+
+func (er *envReader) duration(dst *time.Duration, key string) {
+	if value, ok := er.lookup(key); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			er.errs = append(er.errs, fmt.Errorf("%s %q is not a duration like 24h", key, value))
+			return
+		}
+		*dst = d
+	}
+}
+
+func (er *envReader) rateLimit(dst *RateLimit, key string) {
+	if value, ok := er.lookup(key); ok {
+		limit, err := ParseRateLimit(value)
+		if err != nil {
+			er.errs = append(er.errs, fmt.Errorf("%s: %w", key, err))
+			return
+		}
+		*dst = limit
+	}
+}
+
+// isExampleValue reports whether a setting was copied unchanged from
+// env.yaml or .env.example, whose placeholders start with "your-"
+func isExampleValue(value string) bool {
+	return strings.HasPrefix(value, "your-")
+}
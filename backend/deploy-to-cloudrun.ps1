@@ -46,7 +46,25 @@ if ((Test-Path $ENV_FILE) -and !(Test-Path $ENV_VARS_FILE)) {
     Write-Host "✅ Created env.yaml from .env"
 }
 
-# ==== STEP 5: Deploy to Cloud Run ====
+# ==== STEP 5: Check the configuration ====
+# The new image validates env.yaml and the mounted secrets the way the server
+# does at startup, so a configuration it would refuse fails here instead
+if (Test-Path $ENV_VARS_FILE) {
+    Write-Host "🔎 Checking the configuration in $ENV_VARS_FILE..."
+    $env:JWT_KEY_ENCRYPTION_KEY = gcloud secrets versions access latest --secret=jwt-key-encryption-key --project=$PROJECT_ID
+    $env:METRICS_TOKEN = gcloud secrets versions access latest --secret=metrics-token --project=$PROJECT_ID
+    docker run --rm -e JWT_KEY_ENCRYPTION_KEY -e METRICS_TOKEN `
+      -v "${PWD}/${ENV_VARS_FILE}:/app/env.yaml:ro" `
+      --entrypoint /app/main $FULL_IMAGE_PATH -check-config env.yaml
+    $checkExitCode = $LASTEXITCODE
+    Remove-Item Env:JWT_KEY_ENCRYPTION_KEY, Env:METRICS_TOKEN
+    if ($checkExitCode -ne 0) {
+        Write-Host "❌ Fix the configuration in $ENV_VARS_FILE before deploying. Exiting."
+        exit 1
+    }
+}
+
+# ==== STEP 6: Deploy to Cloud Run ====
 Write-Host "🌐 Deploying to Cloud Run..."
 if (Test-Path $ENV_VARS_FILE) {
     Write-Host "🌐 Deploying with environment variables from $ENV_VARS_FILE..."
@@ -59,6 +77,7 @@ if (Test-Path $ENV_VARS_FILE) {
       --port=$PORT `
       --allow-unauthenticated `
       --env-vars-file=$ENV_VARS_FILE `
+      --set-secrets=JWT_KEY_ENCRYPTION_KEY=jwt-key-encryption-key:latest,METRICS_TOKEN=metrics-token:latest `
       --timeout=500s
 } else {
     Write-Host "⚠️ env.yaml not found. Deploying without environment variables..."
@@ -70,6 +89,7 @@ if (Test-Path $ENV_VARS_FILE) {
       --project=$PROJECT_ID `
       --port=$PORT `
       --allow-unauthenticated `
+      --set-secrets=JWT_KEY_ENCRYPTION_KEY=jwt-key-encryption-key:latest,METRICS_TOKEN=metrics-token:latest `
       --timeout=500s
 }
 
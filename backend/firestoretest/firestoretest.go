@@ -0,0 +1,24 @@
+// Package firestoretest points tests at the Firestore emulator. Tests that
+// need Firestore are skipped unless FIRESTORE_EMULATOR_HOST is set.
+package firestoretest
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// ProjectID returns a project ID of its own for t, so tests sharing one
+// emulator never see each other's documents. It skips t when no emulator is
+// configured.
+func ProjectID(t testing.TB) string {
+	t.Helper()
+
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set; start the Firestore emulator to run this test")
+	}
+
+	return "test-" + strings.ReplaceAll(uuid.New().String(), "-", "")[:16]
+}
@@ -1,25 +1,35 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
+	"rice-monitor-api/apperrors"
 	"rice-monitor-api/models"
 	"rice-monitor-api/services"
+	"rice-monitor-api/utils"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/api/iterator"
 )
 
+// maxTrendDays bounds the look-back of the trends endpoint to a year
+const maxTrendDays = 366
+
 type AnalyticsHandler struct {
 	firestoreService *services.FirestoreService
+	aggregateService *services.AggregateService
 }
 
-func NewAnalyticsHandler(firestoreService *services.FirestoreService) *AnalyticsHandler {
+func NewAnalyticsHandler(firestoreService *services.FirestoreService, aggregateService *services.AggregateService) *AnalyticsHandler {
 	return &AnalyticsHandler{
 		firestoreService: firestoreService,
+		aggregateService: aggregateService,
 	}
 }
 
@@ -29,68 +39,57 @@ func NewAnalyticsHandler(firestoreService *services.FirestoreService) *Analytics
 // @Produce  json
 // @Security ApiKeyAuth
 // @Success 200 {object} models.SuccessResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 500 {object} models.Problem
 // @Router /analytics/dashboard [get]
 func (ah *AnalyticsHandler) GetDashboardData(c *gin.Context) {
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
 
-	ctx := ah.firestoreService.Context()
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	// Counts come from the precomputed totals
+	total, err := ah.aggregateService.Total(ctx, analyticsScope(user))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to retrieve dashboard data", err))
+		return
+	}
 
-	// Get submissions count
 	submissionsQuery := ah.firestoreService.Submissions().Query
-	if user.Role != "admin" {
+	if !user.Can(models.PermissionAnalyticsViewAll) {
 		submissionsQuery = submissionsQuery.Where("user_id", "==", user.ID)
 	}
 
-	totalSubmissions := 0
-	submissionsByStatus := make(map[string]int)
-	submissionsByStage := make(map[string]int)
+	// Get recent submissions (last 5), skipping soft-deleted ones
+	recentIter := submissionsQuery.OrderBy("created_at", firestore.Desc).Documents(ctx)
+	defer recentIter.Stop()
 
-	iter := submissionsQuery.Documents(ctx)
-	for {
-		doc, err := iter.Next()
+	var recentSubmissions []models.Submission
+	for len(recentSubmissions) < 5 {
+		doc, err := recentIter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error:   "internal_error",
-				Message: "Failed to retrieve dashboard data",
-			})
+			c.Error(apperrors.Internal("Failed to retrieve recent submissions", err))
 			return
 		}
 
 		var submission models.Submission
-		doc.DataTo(&submission)
-
-		totalSubmissions++
-		submissionsByStatus[submission.Status]++
-		submissionsByStage[submission.GrowthStage]++
-	}
-
-	// Get recent submissions (last 5)
-	recentQuery := submissionsQuery.OrderBy("created_at", firestore.Desc).Limit(5)
-	recentDocs, err := recentQuery.Documents(ctx).GetAll()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve recent submissions",
-		})
-		return
-	}
-
-	var recentSubmissions []models.Submission
-	for _, doc := range recentDocs {
-		var submission models.Submission
-		doc.DataTo(&submission)
+		if err := doc.DataTo(&submission); err != nil {
+			c.Error(apperrors.Internal("Failed to decode submission "+doc.Ref.ID, err))
+			return
+		}
+		if submission.DeletedAt != nil {
+			continue
+		}
 		recentSubmissions = append(recentSubmissions, submission)
 	}
 
 	dashboardData := models.DashboardData{
-		TotalSubmissions:    totalSubmissions,
-		SubmissionsByStatus: submissionsByStatus,
-		SubmissionsByStage:  submissionsByStage,
+		TotalSubmissions:    total.Total,
+		SubmissionsByStatus: nonZeroCounts(total.ByStatus),
+		SubmissionsByStage:  nonZeroCounts(total.ByStage),
 		RecentSubmissions:   recentSubmissions,
 		LastUpdated:         time.Now(),
 	}
@@ -106,63 +105,58 @@ func (ah *AnalyticsHandler) GetDashboardData(c *gin.Context) {
 // @Tags analytics
 // @Produce  json
 // @Security ApiKeyAuth
-// @Param days query int false "Number of days to look back"
+// @Param days query int false "Number of days to look back, from 1 to 366 (default 30)"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /analytics/trends [get]
 func (ah *AnalyticsHandler) GetTrends(c *gin.Context) {
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
 
 	// Parse query parameters
-	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > maxTrendDays {
+		c.Error(apperrors.Validation("invalid_request", fmt.Sprintf("days must be a number from 1 to %d", maxTrendDays)))
+		return
+	}
 
-	ctx := ah.firestoreService.Context()
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
 
 	// Calculate date range
 	endDate := time.Now()
 	startDate := endDate.AddDate(0, 0, -days)
 
-	submissionsQuery := ah.firestoreService.Submissions().
-		Where("created_at", ">=", startDate).
-		Where("created_at", "<=", endDate)
-
-	if user.Role != "admin" {
-		submissionsQuery = submissionsQuery.Where("user_id", "==", user.ID)
+	dailyAggregates, err := ah.aggregateService.Days(ctx, analyticsScope(user), startDate, endDate)
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to retrieve trends data", err))
+		return
 	}
 
-	iter := submissionsQuery.Documents(ctx)
 	dailySubmissions := make(map[string]int)
 	stageProgression := make(map[string][]string)
 
-	for {
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			break
+	for _, day := range dailyAggregates {
+		if day.Total > 0 {
+			dailySubmissions[day.Day] = day.Total
 		}
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error:   "internal_error",
-				Message: "Failed to retrieve trends data",
-			})
-			return
-		}
-
-		var submission models.Submission
-		doc.DataTo(&submission)
-
-		// Group by date
-		dateKey := submission.CreatedAt.Format("2006-01-02")
-		dailySubmissions[dateKey]++
 
-		// Track stage progression by field
-		if submission.FieldID != "" {
-			stageProgression[submission.FieldID] = append(
-				stageProgression[submission.FieldID],
-				submission.GrowthStage)
+		for fieldID, field := range day.ByField {
+			for stage := range nonZeroCounts(field.ByStage) {
+				if !utils.Contains(stageProgression[fieldID], stage) {
+					stageProgression[fieldID] = append(stageProgression[fieldID], stage)
+				}
+			}
 		}
 	}
 
+	// Each field's stages are listed in growth order; see GetStageProgress
+	// for when they were observed
+	for _, stages := range stageProgression {
+		sortStages(stages)
+	}
+
 	trendsData := models.TrendsData{
 		DailySubmissions: dailySubmissions,
 		StageProgression: stageProgression,
@@ -179,16 +173,246 @@ func (ah *AnalyticsHandler) GetTrends(c *gin.Context) {
 	})
 }
 
+// @Summary Get Trend Series
+// @Description Get the mean of a trait, or the share of submissions listing a plant condition, bucketed by observation date.
+// @Description Every bucket in the period is present, with a null value where nothing was observed.
+// @Tags analytics
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param trait query string false "Trait (culm_length, panicle_length, panicles_per_hill); give trait or condition"
+// @Param condition query string false "Plant condition whose prevalence to follow"
+// @Param bucket query string false "Bucket size (day, week, month); defaults to week"
+// @Param field_id query string false "Only observations of this field; all fields by default"
+// @Param start_date query string false "First observation date (YYYY-MM-DD); defaults to 90 days before end_date"
+// @Param end_date query string false "Last observation date, inclusive (YYYY-MM-DD); defaults to today"
+// @Param window query int false "Buckets in the trailing moving average; defaults to 3"
+// @Success 200 {object} models.SuccessResponse{data=models.TrendSeries}
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /analytics/trends/series [get]
+func (ah *AnalyticsHandler) GetTrendSeries(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	// Parse query parameters
+	trait := c.Query("trait")
+	condition := c.Query("condition")
+	bucket := c.DefaultQuery("bucket", bucketWeek)
+	fieldID := c.Query("field_id")
+
+	if (trait == "") == (condition == "") {
+		c.Error(apperrors.Validation("invalid_request", "Give either trait or condition"))
+		return
+	}
+	if trait != "" && !utils.Contains(models.Traits, trait) {
+		c.Error(apperrors.Validation("invalid_request", "trait must be one of: culm_length, panicle_length, panicles_per_hill"))
+		return
+	}
+	if bucket != bucketDay && bucket != bucketWeek && bucket != bucketMonth {
+		c.Error(apperrors.Validation("invalid_request", "bucket must be one of: day, week, month"))
+		return
+	}
+
+	window, err := strconv.Atoi(c.DefaultQuery("window", "3"))
+	if err != nil || window < 1 || window > maxSeriesBuckets {
+		c.Error(apperrors.Validation("invalid_request", fmt.Sprintf("window must be a number of buckets from 1 to %d", maxSeriesBuckets)))
+		return
+	}
+
+	period, err := parseReportPeriod(c.Query("start_date"), c.Query("end_date"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if period.end.IsZero() {
+		period.end = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if period.start.IsZero() {
+		period.start = period.end.AddDate(0, 0, -90)
+	}
+	if period.start.After(period.end) {
+		c.Error(apperrors.Validation("invalid_request", "start_date must not be after end_date"))
+		return
+	}
+
+	starts, err := seriesBuckets(bucket, period.start, period.end)
+	if err != nil {
+		c.Error(apperrors.Validation("invalid_request", err.Error()))
+		return
+	}
+
+	ctx, cancel := ah.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
+	query := ah.firestoreService.Submissions().Query
+
+	if !user.Can(models.PermissionAnalyticsViewAll) {
+		query = query.Where("user_id", "==", user.ID)
+	}
+	if fieldID != "" {
+		query = query.Where("field_id", "==", fieldID)
+	}
+	query = query.Where("date", ">=", period.start).Where("date", "<", period.end.AddDate(0, 0, 1))
+
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to retrieve trend data", err))
+		return
+	}
+
+	samples := make(map[string]*seriesSample)
+	for _, doc := range docs {
+		var submission models.Submission
+		if err := doc.DataTo(&submission); err != nil {
+			c.Error(apperrors.Internal("Failed to decode submission "+doc.Ref.ID, err))
+			return
+		}
+		if submission.DeletedAt != nil {
+			continue
+		}
+
+		key := utils.FormatDate(bucketStart(bucket, submission.Date.UTC()))
+		sample, ok := samples[key]
+		if !ok {
+			sample = &seriesSample{}
+			samples[key] = sample
+		}
+
+		if trait != "" {
+			// Only submissions that measured the trait count towards its mean
+			if value, ok := submission.TraitMeasurements.Values()[trait]; ok {
+				sample.n++
+				sample.sum += value
+			}
+			continue
+		}
+		sample.n++
+		if utils.Contains(submission.PlantConditions, condition) {
+			sample.sum++
+		}
+	}
+
+	metric := trait
+	if condition != "" {
+		metric = "condition:" + condition
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data: models.TrendSeries{
+			Metric:  metric,
+			Bucket:  bucket,
+			Window:  window,
+			FieldID: fieldID,
+			Points:  buildSeries(starts, samples, window),
+			Period: map[string]interface{}{
+				"start_date": utils.FormatDate(period.start),
+				"end_date":   utils.FormatDate(period.end),
+			},
+		},
+	})
+}
+
+// @Summary Get Growth Stage Progress
+// @Description Get each field's growth-stage timeline by observation date: when each stage was first observed,
+// @Description days spent in it against the expected duration, and observations that report an earlier stage than already reached.
+// @Tags analytics
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param field_id query string false "Only this field; all fields by default"
+// @Param start_date query string false "First observation date (YYYY-MM-DD)"
+// @Param end_date query string false "Last observation date, inclusive (YYYY-MM-DD); the current stage is timed up to it, or to today"
+// @Success 200 {object} models.SuccessResponse{data=models.StageProgress}
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /analytics/stages [get]
+func (ah *AnalyticsHandler) GetStageProgress(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	// Parse query parameters
+	fieldID := c.Query("field_id")
+	period, err := parseReportPeriod(c.Query("start_date"), c.Query("end_date"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	asOf := period.end
+	if asOf.IsZero() {
+		asOf = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+
+	ctx, cancel := ah.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
+	query := ah.firestoreService.Submissions().Query
+
+	if !user.Can(models.PermissionAnalyticsViewAll) {
+		query = query.Where("user_id", "==", user.ID)
+	}
+	if fieldID != "" {
+		query = query.Where("field_id", "==", fieldID)
+	}
+	if !period.start.IsZero() {
+		query = query.Where("date", ">=", period.start)
+	}
+	if !period.end.IsZero() {
+		query = query.Where("date", "<", period.end.AddDate(0, 0, 1))
+	}
+
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to retrieve stage data", err))
+		return
+	}
+
+	byField := make(map[string][]models.Submission)
+	for _, doc := range docs {
+		var submission models.Submission
+		if err := doc.DataTo(&submission); err != nil {
+			c.Error(apperrors.Internal("Failed to decode submission "+doc.Ref.ID, err))
+			return
+		}
+		if submission.DeletedAt != nil {
+			continue
+		}
+		key := groupKey(submission.FieldID)
+		byField[key] = append(byField[key], submission)
+	}
+
+	fieldIDs := make([]string, 0, len(byField))
+	for id := range byField {
+		fieldIDs = append(fieldIDs, id)
+	}
+	sort.Strings(fieldIDs)
+
+	timelines := make([]models.StageTimeline, 0, len(fieldIDs))
+	for _, id := range fieldIDs {
+		timelines = append(timelines, buildStageTimeline(id, byField[id], asOf))
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data: models.StageProgress{
+			Stages:      models.GrowthStages,
+			Fields:      timelines,
+			AsOf:        utils.FormatDate(asOf),
+			Period:      period.describe(),
+			GeneratedAt: time.Now(),
+		},
+	})
+}
+
 // @Summary Get Reports
 // @Description Generate and retrieve reports
 // @Tags analytics
 // @Produce  json
 // @Security ApiKeyAuth
-// @Param type query string false "Report type (summary, detailed, field_analysis)"
-// @Param start_date query string false "Start date for the report (YYYY-MM-DD)"
-// @Param end_date query string false "End date for the report (YYYY-MM-DD)"
+// @Param type query string false "Report type (summary, detailed, field_analysis, traits)"
+// @Param start_date query string false "Start date for the report (YYYY-MM-DD): the creation day, or the observation date for traits"
+// @Param end_date query string false "End date for the report, inclusive (YYYY-MM-DD)"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /analytics/reports [get]
 func (ah *AnalyticsHandler) GetReports(c *gin.Context) {
 	currentUser, _ := c.Get("user")
@@ -196,48 +420,69 @@ func (ah *AnalyticsHandler) GetReports(c *gin.Context) {
 
 	// Parse query parameters
 	reportType := c.DefaultQuery("type", "summary")
-	startDate := c.Query("start_date")
-	endDate := c.Query("end_date")
+	period, err := parseReportPeriod(c.Query("start_date"), c.Query("end_date"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
 
-	ctx := ah.firestoreService.Context()
-	query := ah.firestoreService.Submissions().Query
+	// Only the reports that look at each submission scan them
+	switch reportType {
+	case "detailed", "traits":
+		ah.getSubmissionReport(c, user, reportType, period)
+		return
+	}
 
-	if user.Role != "admin" {
-		query = query.Where("user_id", "==", user.ID)
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	aggregate, err := ah.periodAggregate(ctx, analyticsScope(user), period)
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to generate report", err))
+		return
 	}
 
-	// Apply date filters if provided
-	if startDate != "" {
-		if start, err := time.Parse("2006-01-02", startDate); err == nil {
-			query = query.Where("created_at", ">=", start)
-		}
+	var reportData interface{}
+
+	switch reportType {
+	case "field_analysis":
+		reportData = ah.generateFieldAnalysisReport(aggregate)
+	default:
+		reportData = ah.generateSummaryReport(aggregate)
 	}
-	if endDate != "" {
-		if end, err := time.Parse("2006-01-02", endDate); err == nil {
-			query = query.Where("created_at", "<=", end)
-		}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    reportData,
+	})
+}
+
+// getSubmissionReport generates the reports that need the submissions
+// themselves rather than their counts
+func (ah *AnalyticsHandler) getSubmissionReport(c *gin.Context, user *models.User, reportType string, period reportPeriod) {
+	ctx, cancel := ah.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
+
+	// Traits are grouped by when they were observed, so they are selected
+	// that way too
+	dateField := "created_at"
+	if reportType == "traits" {
+		dateField = "date"
 	}
 
-	docs, err := query.Documents(ctx).GetAll()
+	submissions, err := ah.periodSubmissions(ctx, user, period, dateField)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to generate report",
-		})
+		c.Error(err)
 		return
 	}
 
 	var reportData interface{}
 
 	switch reportType {
-	case "summary":
-		reportData = ah.generateSummaryReport(docs)
-	case "detailed":
-		reportData = ah.generateDetailedReport(docs)
-	case "field_analysis":
-		reportData = ah.generateFieldAnalysisReport(docs)
+	case "traits":
+		reportData = ah.generateTraitsReport(submissions, period)
 	default:
-		reportData = ah.generateSummaryReport(docs)
+		reportData = ah.generateDetailedReport(submissions)
 	}
 
 	c.JSON(http.StatusOK, models.SuccessResponse{
@@ -246,42 +491,181 @@ func (ah *AnalyticsHandler) GetReports(c *gin.Context) {
 	})
 }
 
-// Report generation functions
-func (ah *AnalyticsHandler) generateSummaryReport(docs []*firestore.DocumentSnapshot) map[string]interface{} {
-	totalSubmissions := len(docs)
-	statusCounts := make(map[string]int)
-	stageCounts := make(map[string]int)
-	conditionCounts := make(map[string]int)
+// periodSubmissions returns the submissions user may analyse whose dateField,
+// created_at or date, falls within period, skipping soft-deleted ones
+func (ah *AnalyticsHandler) periodSubmissions(ctx context.Context, user *models.User, period reportPeriod, dateField string) ([]models.Submission, error) {
+	query := ah.firestoreService.Submissions().Query
 
+	if !user.Can(models.PermissionAnalyticsViewAll) {
+		query = query.Where("user_id", "==", user.ID)
+	}
+
+	// Apply date filters if provided
+	if !period.start.IsZero() {
+		query = query.Where(dateField, ">=", period.start)
+	}
+	if !period.end.IsZero() {
+		query = query.Where(dateField, "<", period.end.AddDate(0, 0, 1))
+	}
+
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, apperrors.Internal("Failed to generate report", err)
+	}
+
+	var submissions []models.Submission
 	for _, doc := range docs {
 		var submission models.Submission
-		doc.DataTo(&submission)
+		if err := doc.DataTo(&submission); err != nil {
+			return nil, apperrors.Internal("Failed to decode submission "+doc.Ref.ID, err)
+		}
+		if submission.DeletedAt != nil {
+			continue
+		}
+		submissions = append(submissions, submission)
+	}
+
+	return submissions, nil
+}
+
+// reportPeriod is the range of days a report covers, by creation or
+// observation date; a zero start or end leaves that side open
+type reportPeriod struct {
+	start time.Time
+	end   time.Time
+}
 
-		statusCounts[submission.Status]++
-		stageCounts[submission.GrowthStage]++
+// describe returns the dates bounding the period, for reports to echo
+func (p reportPeriod) describe() map[string]interface{} {
+	described := make(map[string]interface{})
+	if !p.start.IsZero() {
+		described["start_date"] = utils.FormatDate(p.start)
+	}
+	if !p.end.IsZero() {
+		described["end_date"] = utils.FormatDate(p.end)
+	}
+	return described
+}
+
+func parseReportPeriod(startDate, endDate string) (reportPeriod, error) {
+	var period reportPeriod
+	var err error
 
-		for _, condition := range submission.PlantConditions {
-			conditionCounts[condition]++
+	if startDate != "" {
+		if period.start, err = utils.ParseDate(startDate); err != nil {
+			return period, apperrors.Validation("invalid_request", "start_date must be a date in YYYY-MM-DD format")
+		}
+	}
+	if endDate != "" {
+		if period.end, err = utils.ParseDate(endDate); err != nil {
+			return period, apperrors.Validation("invalid_request", "end_date must be a date in YYYY-MM-DD format")
 		}
 	}
+	return period, nil
+}
 
-	return map[string]interface{}{
-		"total_submissions":   totalSubmissions,
-		"status_distribution": statusCounts,
-		"stage_distribution":  stageCounts,
-		"condition_frequency": conditionCounts,
-		"generated_at":        time.Now(),
+// periodAggregate returns the counts of submissions in scope created within
+// period, from the running total when the period is open on both sides
+func (ah *AnalyticsHandler) periodAggregate(ctx context.Context, scope string, period reportPeriod) (*models.SubmissionAggregate, error) {
+	if period.start.IsZero() && period.end.IsZero() {
+		return ah.aggregateService.Total(ctx, scope)
+	}
+
+	end := period.end
+	if end.IsZero() {
+		end = time.Now()
 	}
+	days, err := ah.aggregateService.Days(ctx, scope, period.start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sumAggregates(days)
+	return &sum, nil
 }
 
-func (ah *AnalyticsHandler) generateDetailedReport(docs []*firestore.DocumentSnapshot) map[string]interface{} {
-	var submissions []models.Submission
-	for _, doc := range docs {
-		var submission models.Submission
-		doc.DataTo(&submission)
-		submissions = append(submissions, submission)
+// analyticsScope returns the aggregate scope a user's analytics cover
+func analyticsScope(user *models.User) string {
+	if user.Can(models.PermissionAnalyticsViewAll) {
+		return services.AggregateScopeAll
+	}
+	return services.AggregateScopeUser(user.ID)
+}
+
+// sumAggregates adds up daily aggregates
+func sumAggregates(days []models.SubmissionAggregate) models.SubmissionAggregate {
+	sum := models.SubmissionAggregate{
+		ByStatus:    make(map[string]int),
+		ByStage:     make(map[string]int),
+		ByCondition: make(map[string]int),
+		ByField:     make(map[string]models.FieldAggregate),
+	}
+
+	for _, day := range days {
+		sum.Total += day.Total
+		addCounts(sum.ByStatus, day.ByStatus)
+		addCounts(sum.ByStage, day.ByStage)
+		addCounts(sum.ByCondition, day.ByCondition)
+
+		for fieldID, field := range day.ByField {
+			fieldSum, ok := sum.ByField[fieldID]
+			if !ok {
+				fieldSum = models.FieldAggregate{
+					ByStage:     make(map[string]int),
+					ByCondition: make(map[string]int),
+					ByDate:      make(map[string]int),
+				}
+			}
+			fieldSum.Total += field.Total
+			addCounts(fieldSum.ByStage, field.ByStage)
+			addCounts(fieldSum.ByCondition, field.ByCondition)
+			addCounts(fieldSum.ByDate, field.ByDate)
+			sum.ByField[fieldID] = fieldSum
+		}
+	}
+
+	return sum
+}
+
+func addCounts(sum, counts map[string]int) {
+	for key, count := range counts {
+		sum[key] += count
+	}
+}
+
+// nonZeroCounts drops the keys aggregates keep after their count has gone
+// back to zero
+func nonZeroCounts(counts map[string]int) map[string]int {
+	nonZero := make(map[string]int)
+	for key, count := range counts {
+		if count != 0 {
+			nonZero[key] = count
+		}
+	}
+	return nonZero
+}
+
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Report generation functions
+func (ah *AnalyticsHandler) generateSummaryReport(aggregate *models.SubmissionAggregate) map[string]interface{} {
+	return map[string]interface{}{
+		"total_submissions":   aggregate.Total,
+		"status_distribution": nonZeroCounts(aggregate.ByStatus),
+		"stage_distribution":  nonZeroCounts(aggregate.ByStage),
+		"condition_frequency": nonZeroCounts(aggregate.ByCondition),
+		"generated_at":        time.Now(),
 	}
+}
 
+func (ah *AnalyticsHandler) generateDetailedReport(submissions []models.Submission) map[string]interface{} {
 	return map[string]interface{}{
 		"submissions":  submissions,
 		"total_count":  len(submissions),
@@ -289,35 +673,26 @@ func (ah *AnalyticsHandler) generateDetailedReport(docs []*firestore.DocumentSna
 	}
 }
 
-func (ah *AnalyticsHandler) generateFieldAnalysisReport(docs []*firestore.DocumentSnapshot) map[string]interface{} {
+func (ah *AnalyticsHandler) generateFieldAnalysisReport(aggregate *models.SubmissionAggregate) map[string]interface{} {
 	fieldData := make(map[string]map[string]interface{})
 
-	for _, doc := range docs {
-		var submission models.Submission
-		doc.DataTo(&submission)
-
-		if fieldData[submission.FieldID] == nil {
-			fieldData[submission.FieldID] = map[string]interface{}{
-				"submission_count": 0,
-				"stages":           make(map[string]int),
-				"conditions":       make(map[string]int),
-				"latest_date":      submission.Date,
-			}
+	for fieldID, field := range aggregate.ByField {
+		if field.Total == 0 {
+			continue
 		}
 
-		data := fieldData[submission.FieldID]
-		data["submission_count"] = data["submission_count"].(int) + 1
-
-		stages := data["stages"].(map[string]int)
-		stages[submission.GrowthStage]++
-
-		conditions := data["conditions"].(map[string]int)
-		for _, condition := range submission.PlantConditions {
-			conditions[condition]++
+		// Observation dates are counted by day, so the latest is a day
+		dates := sortedKeys(nonZeroCounts(field.ByDate))
+		var latestDate time.Time
+		if len(dates) > 0 {
+			latestDate, _ = utils.ParseDate(dates[len(dates)-1])
 		}
 
-		if submission.Date.After(data["latest_date"].(time.Time)) {
-			data["latest_date"] = submission.Date
+		fieldData[fieldID] = map[string]interface{}{
+			"submission_count": field.Total,
+			"stages":           nonZeroCounts(field.ByStage),
+			"conditions":       nonZeroCounts(field.ByCondition),
+			"latest_date":      latestDate,
 		}
 	}
 
@@ -327,3 +702,76 @@ func (ah *AnalyticsHandler) generateFieldAnalysisReport(docs []*firestore.Docume
 		"generated_at":   time.Now(),
 	}
 }
+
+func (ah *AnalyticsHandler) generateTraitsReport(submissions []models.Submission, period reportPeriod) models.TraitsReport {
+	overall := make(traitSamples)
+	byField := make(map[string]traitSamples)
+	byStage := make(map[string]traitSamples)
+	byPeriod := make(map[string]traitSamples)
+
+	for _, submission := range submissions {
+		values := submission.TraitMeasurements.Values()
+		if len(values) == 0 {
+			continue
+		}
+
+		overall.add(values)
+		groupSamples(byField, groupKey(submission.FieldID)).add(values)
+		groupSamples(byStage, groupKey(submission.GrowthStage)).add(values)
+		groupSamples(byPeriod, submission.Date.UTC().Format("2006-01")).add(values)
+	}
+
+	return models.TraitsReport{
+		Traits:      models.Traits,
+		Overall:     overall.describe(),
+		ByField:     describeGroups(byField),
+		ByStage:     describeGroups(byStage),
+		ByPeriod:    describeGroups(byPeriod),
+		Submissions: len(submissions),
+		Period:      period.describe(),
+		GeneratedAt: time.Now(),
+	}
+}
+
+// traitSamples collects measurements by trait name
+type traitSamples map[string][]float64
+
+func (ts traitSamples) add(values map[string]float64) {
+	for trait, value := range values {
+		ts[trait] = append(ts[trait], value)
+	}
+}
+
+func (ts traitSamples) describe() map[string]models.TraitStatistics {
+	statistics := make(map[string]models.TraitStatistics)
+	for trait, values := range ts {
+		statistics[trait] = utils.DescribeValues(values)
+	}
+	return statistics
+}
+
+func groupSamples(groups map[string]traitSamples, key string) traitSamples {
+	samples, ok := groups[key]
+	if !ok {
+		samples = make(traitSamples)
+		groups[key] = samples
+	}
+	return samples
+}
+
+func describeGroups(groups map[string]traitSamples) map[string]map[string]models.TraitStatistics {
+	described := make(map[string]map[string]models.TraitStatistics)
+	for key, samples := range groups {
+		described[key] = samples.describe()
+	}
+	return described
+}
+
+// groupKey names the group of submissions missing a value "unknown", as the
+// aggregates do
+func groupKey(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+)
+
+func TestGenerateTraitsReport(t *testing.T) {
+	submissions := []models.Submission{
+		{FieldID: "f1", GrowthStage: "heading", Date: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), TraitMeasurements: models.TraitMeasurements{CulmLength: 90, PanicleLength: 20, PaniclesPerHill: 10}},
+		{FieldID: "f1", GrowthStage: "heading", Date: time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC), TraitMeasurements: models.TraitMeasurements{CulmLength: 110}},
+		{FieldID: "f2", GrowthStage: "", Date: time.Date(2026, 8, 2, 0, 0, 0, 0, time.UTC), TraitMeasurements: models.TraitMeasurements{CulmLength: 100, PaniclesPerHill: 14}},
+		{FieldID: "f2", GrowthStage: "tillering", Date: time.Date(2026, 8, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	period := reportPeriod{start: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)}
+
+	report := (&AnalyticsHandler{}).generateTraitsReport(submissions, period)
+
+	tests := []struct {
+		name   string
+		got    map[string]models.TraitStatistics
+		trait  string
+		n      int
+		mean   float64
+		absent []string
+	}{
+		{name: "overall culm length", got: report.Overall, trait: models.TraitCulmLength, n: 3, mean: 100},
+		{name: "overall panicles per hill", got: report.Overall, trait: models.TraitPaniclesPerHill, n: 2, mean: 12},
+		{name: "field f1", got: report.ByField["f1"], trait: models.TraitCulmLength, n: 2, mean: 100},
+		{name: "field f2 skips unmeasured traits", got: report.ByField["f2"], trait: models.TraitCulmLength, n: 1, mean: 100, absent: []string{models.TraitPanicleLength}},
+		{name: "missing stage", got: report.ByStage["unknown"], trait: models.TraitPaniclesPerHill, n: 1, mean: 14},
+		{name: "July", got: report.ByPeriod["2026-07"], trait: models.TraitPanicleLength, n: 1, mean: 20},
+		{name: "August", got: report.ByPeriod["2026-08"], trait: models.TraitCulmLength, n: 1, mean: 100, absent: []string{models.TraitPanicleLength}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statistics, ok := tt.got[tt.trait]
+			if !ok || statistics.N != tt.n || statistics.Mean != tt.mean {
+				t.Errorf("got %+v for %s, want n=%d mean=%v", statistics, tt.trait, tt.n, tt.mean)
+			}
+			for _, trait := range tt.absent {
+				if _, ok := tt.got[trait]; ok {
+					t.Errorf("%s has statistics but was never measured", trait)
+				}
+			}
+		})
+	}
+
+	if _, ok := report.ByStage["tillering"]; ok {
+		t.Error("a stage without measurements has a group")
+	}
+	if report.Submissions != len(submissions) || report.Period["start_date"] != "2026-07-01" || report.Period["end_date"] != nil {
+		t.Errorf("got %d submissions, period %v", report.Submissions, report.Period)
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyHandler struct {
+	firestoreService *services.FirestoreService
+	apiKeyService    *services.APIKeyService
+}
+
+func NewAPIKeyHandler(firestoreService *services.FirestoreService, apiKeyService *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		firestoreService: firestoreService,
+		apiKeyService:    apiKeyService,
+	}
+}
+
+// @Summary List API keys
+// @Description List the current user's active API keys. Secrets are never returned.
+// @Tags auth
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse{data=[]models.APIKey}
+// @Failure 500 {object} models.Problem
+// @Router /auth/api-keys [get]
+func (kh *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	ctx, cancel := kh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	keys, err := kh.apiKeyService.List(ctx, c.GetString("user_id"))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to retrieve API keys", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    keys,
+	})
+}
+
+// @Summary Create API key
+// @Description Create a personal API key for scripts, sent in the X-API-Key header. The key can
+// @Description only use the listed scopes, which must be permissions of the user's role. The key
+// @Description is returned once and cannot be retrieved again. Keys expire after 90 days by default.
+// @Tags auth
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param   key  body  models.CreateAPIKeyRequest  true  "API key"
+// @Success 201 {object} models.SuccessResponse{data=models.CreateAPIKeyResponse}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /auth/api-keys [post]
+func (kh *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !user.Can(scope) {
+			c.Error(apperrors.Validation("invalid_scope", "Scope "+string(scope)+" is not a permission of your role"))
+			return
+		}
+	}
+
+	ctx, cancel := kh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	apiKey, key, err := kh.apiKeyService.Create(ctx, user.ID, req)
+	if err == services.ErrAPIKeyLimit {
+		c.Error(apperrors.Conflict("api_key_limit", "Revoke an existing API key before creating another"))
+		return
+	}
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to create API key", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.SuccessResponse{
+		Success: true,
+		Data: models.CreateAPIKeyResponse{
+			APIKey: *apiKey,
+			Key:    key,
+		},
+		Message: "Store this key now; it will not be shown again",
+	})
+}
+
+// @Summary Revoke API key
+// @Description Revoke one of the current user's API keys
+// @Tags auth
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "API key ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /auth/api-keys/{id} [delete]
+func (kh *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	ctx, cancel := kh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	err := kh.apiKeyService.Revoke(ctx, c.GetString("user_id"), c.Param("id"))
+	if err == services.ErrAPIKeyNotFound {
+		c.Error(apperrors.NotFound("not_found", "API key not found"))
+		return
+	}
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to revoke API key", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "API key revoked successfully",
+	})
+}
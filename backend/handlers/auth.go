@@ -1,27 +1,46 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"rice-monitor-api/apperrors"
 	"rice-monitor-api/models"
 	"rice-monitor-api/services"
-	"rice-monitor-api/utils"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
-	"google.golang.org/api/idtoken"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errUserDeleted      = errors.New("user account has been deleted")
+	errEmailNotVerified = errors.New("identity has no verified email")
+	// errIdentityNotLinked is returned when a new identity's email belongs
+	// to an account its provider may not claim by email
+	errIdentityNotLinked = errors.New("identity is not linked to the account with its email")
 )
 
 type AuthHandler struct {
 	firestoreService *services.FirestoreService
+	tokenService     *services.TokenService
+	onboarding       *services.OnboardingService
+	providers        map[string]services.IdentityProvider
+	magicLinks       *services.MagicLinkService
 }
 
-func NewAuthHandler(firestoreService *services.FirestoreService) *AuthHandler {
+func NewAuthHandler(firestoreService *services.FirestoreService, tokenService *services.TokenService, onboarding *services.OnboardingService, providers map[string]services.IdentityProvider, magicLinks *services.MagicLinkService) *AuthHandler {
 	return &AuthHandler{
 		firestoreService: firestoreService,
+		tokenService:     tokenService,
+		onboarding:       onboarding,
+		providers:        providers,
+		magicLinks:       magicLinks,
 	}
 }
 
@@ -32,147 +51,417 @@ func NewAuthHandler(firestoreService *services.FirestoreService) *AuthHandler {
 // @Produce  json
 // @Param   token  body  models.GoogleTokenRequest  true  "Google Token"
 // @Success 200 {object} models.AuthResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 401 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /auth/google [post]
 func (ah *AuthHandler) GoogleLogin(c *gin.Context) {
 	var req models.GoogleTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: err.Error(),
-		})
+		c.Error(bindError(err))
 		return
 	}
 
-	// Verify Google token
-	ctx := ah.firestoreService.Context()
+	ah.login(c, ah.providers["google"], req.Token, req.Device)
+}
 
-	// Validate the ID token - replace "YOUR_GOOGLE_CLIENT_ID" with your actual client ID or fetch from config/env
-	payload, err := idtoken.Validate(ctx, req.Token, utils.GetEnvOrDefault("GOOGLE_CLIENT_ID", ""))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-			Error:   "invalid_token",
-			Message: "Invalid Google ID token",
-		})
+// @Summary Login
+// @Description Authenticate with any enabled identity provider and get JWT tokens. The credential is
+// @Description an ID token for google and OIDC providers, or the token from the link for email.
+// @Tags auth
+// @Accept  json
+// @Produce  json
+// @Param   provider  path  string  true  "Identity provider"
+// @Param   login  body  models.LoginRequest  true  "Credential"
+// @Success 200 {object} models.AuthResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /auth/login/{provider} [post]
+func (ah *AuthHandler) Login(c *gin.Context) {
+	provider, ok := ah.providers[c.Param("provider")]
+	if !ok {
+		c.Error(apperrors.NotFound("unknown_provider", "Identity provider is not enabled"))
 		return
 	}
 
-	email, _ := payload.Claims["email"].(string)
-	name, _ := payload.Claims["name"].(string)
-	picture, _ := payload.Claims["picture"].(string)
-
-	// Construct a simplified tokenInfo-like struct or map to pass to your getOrCreateUser
-	tokenInfo := models.GoogleUserInfo{
-		Email:   email,
-		Name:    name,
-		Picture: picture,
+	var req models.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
 	}
 
-	// Get or create user
-	user, err := ah.getOrCreateUser(tokenInfo)
-	if err != nil {
-		fmt.Println(err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to process user",
-		})
+	ah.login(c, provider, req.Credential, req.Device)
+}
+
+// @Summary Request magic link
+// @Description Email a single-use login link. The response is the same whether or not an account
+// @Description exists for the address.
+// @Tags auth
+// @Accept  json
+// @Produce  json
+// @Param   request  body  models.MagicLinkRequest  true  "Email address"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /auth/magic-link [post]
+func (ah *AuthHandler) RequestMagicLink(c *gin.Context) {
+	if ah.magicLinks == nil {
+		c.Error(apperrors.NotFound("unknown_provider", "Email login is not enabled"))
 		return
 	}
 
-	// Generate JWT tokens
-	accessToken, refreshToken, err := utils.GenerateTokens(user)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to generate tokens",
-		})
+	var req models.MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
 		return
 	}
 
-	// Update last login
-	user.LastLoginAt = time.Now()
-	ah.updateUserLastLogin(user.ID)
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	if err := ah.magicLinks.RequestLink(ctx, req.Email); err != nil {
+		c.Error(apperrors.Internal("Failed to send login link", err))
+		return
+	}
 
-	c.JSON(http.StatusOK, models.AuthResponse{
-		User:         *user,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    3600, // 1 hour
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "If the address can sign in, a login link has been sent",
 	})
 }
 
 // @Summary Refresh Token
-// @Description Get a new access token using a refresh token
+// @Description Exchange a refresh token for a new access and refresh token. Each refresh token
+// @Description can be used once; reusing one revokes every token issued from the same login.
 // @Tags auth
 // @Accept  json
 // @Produce  json
 // @Param   token  body  models.RefreshTokenRequest  true  "Refresh Token"
 // @Success 200 {object} models.AuthResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 401 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /auth/refresh [post]
 func (ah *AuthHandler) RefreshToken(c *gin.Context) {
 	var req models.RefreshTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: err.Error(),
-		})
+		c.Error(bindError(err))
+		return
+	}
+
+	// Rotate refresh token
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	user, tokens, err := ah.tokenService.RotateRefreshToken(ctx, req.RefreshToken, services.ClientInfo{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	switch {
+	case err == services.ErrRefreshTokenReused:
+		c.Error(apperrors.Unauthorized("token_reused", "Refresh token was already used; all sessions from this login have been revoked"))
+		return
+	case err == services.ErrRefreshTokenInvalid:
+		c.Error(apperrors.Unauthorized("invalid_token", "Invalid refresh token"))
+		return
+	case err != nil:
+		c.Error(apperrors.Internal("Failed to generate tokens", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.AuthResponse{
+		User:         *user,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	})
+}
+
+// @Summary Logout
+// @Description Logout the current session by revoking its refresh tokens
+// @Tags auth
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse
+// @Failure 500 {object} models.Problem
+// @Router /auth/logout [post]
+func (ah *AuthHandler) Logout(c *gin.Context) {
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	if err := ah.tokenService.RevokeSession(ctx, c.GetString("session_id")); err != nil {
+		c.Error(apperrors.Internal("Failed to log out", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Logged out successfully",
+	})
+}
+
+// @Summary Logout all sessions
+// @Description Revoke every refresh token of the current user, logging out all devices
+// @Tags auth
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse
+// @Failure 500 {object} models.Problem
+// @Router /auth/logout-all [post]
+func (ah *AuthHandler) LogoutAll(c *gin.Context) {
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	if err := ah.tokenService.RevokeAllForUser(ctx, c.GetString("user_id")); err != nil {
+		c.Error(apperrors.Internal("Failed to log out all sessions", err))
 		return
 	}
 
-	// Validate refresh token
-	claims, err := utils.ValidateToken(req.RefreshToken)
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Logged out of all sessions successfully",
+	})
+}
+
+// @Summary List sessions
+// @Description List the devices the current user is logged in on
+// @Tags auth
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse{data=[]models.Session}
+// @Failure 500 {object} models.Problem
+// @Router /auth/sessions [get]
+func (ah *AuthHandler) GetSessions(c *gin.Context) {
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	sessions, err := ah.tokenService.ListSessions(ctx, c.GetString("user_id"))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-			Error:   "invalid_token",
-			Message: "Invalid refresh token",
-		})
+		c.Error(apperrors.Internal("Failed to retrieve sessions", err))
 		return
 	}
 
-	// Get user
-	user, err := ah.getUserByID(claims.UserID)
+	currentSessionID := c.GetString("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    sessions,
+	})
+}
+
+// @Summary Revoke session
+// @Description Log out one of the current user's sessions
+// @Tags auth
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "Session ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /auth/sessions/{id} [delete]
+func (ah *AuthHandler) RevokeSession(c *gin.Context) {
+	sessionID := c.Param("id")
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	session, err := ah.tokenService.GetSession(ctx, sessionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "user_not_found",
-			Message: "User not found",
-		})
+		c.Error(apperrors.Lookup(err, "Session"))
+		return
+	}
+	if session.UserID != c.GetString("user_id") || session.RevokedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "Session not found"))
+		return
+	}
+
+	if err := ah.tokenService.RevokeSession(ctx, sessionID); err != nil {
+		c.Error(apperrors.Internal("Failed to revoke session", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Session revoked successfully",
+	})
+}
+
+// @Summary Get permissions
+// @Description List the permissions granted by the current user's role
+// @Tags auth
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse{data=models.PermissionsResponse}
+// @Router /auth/permissions [get]
+func (ah *AuthHandler) GetPermissions(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	permissions := models.RolePermissions[user.Role]
+	if permissions == nil {
+		permissions = []models.Permission{}
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data: models.PermissionsResponse{
+			Role:        user.Role,
+			Permissions: permissions,
+		},
+	})
+}
+
+// @Summary List identities
+// @Description List the identity providers linked to the current user
+// @Tags auth
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse{data=[]models.UserIdentity}
+// @Failure 500 {object} models.Problem
+// @Router /auth/identities [get]
+func (ah *AuthHandler) GetIdentities(c *gin.Context) {
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	identities, err := ah.getUserIdentities(ctx, c.GetString("user_id"))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to retrieve identities", err))
 		return
 	}
 
-	// Generate new tokens
-	accessToken, refreshToken, err := utils.GenerateTokens(user)
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    identities,
+	})
+}
+
+// @Summary Link identity
+// @Description Link another identity provider to the current user so either can be used to log in
+// @Tags auth
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param   identity  body  models.LinkIdentityRequest  true  "Provider credential"
+// @Success 201 {object} models.SuccessResponse{data=models.UserIdentity}
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /auth/identities [post]
+func (ah *AuthHandler) LinkIdentity(c *gin.Context) {
+	var req models.LinkIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+
+	provider, ok := ah.providers[req.Provider]
+	if !ok {
+		c.Error(apperrors.NotFound("unknown_provider", "Identity provider is not enabled"))
+		return
+	}
+
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	identity, err := provider.Authenticate(ctx, req.Credential)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to generate tokens",
+		ah.respondAuthenticateError(c, err)
+		return
+	}
+
+	userID := c.GetString("user_id")
+	docRef := ah.firestoreService.Identities().Doc(services.IdentityDocID(identity.Provider, identity.Subject))
+	doc, err := docRef.Get(ctx)
+	if err == nil {
+		var existing models.UserIdentity
+		if err := doc.DataTo(&existing); err != nil {
+			c.Error(apperrors.Internal("Failed to link identity", err))
+			return
+		}
+		if existing.UserID != userID {
+			c.Error(apperrors.Conflict("identity_linked", "This identity is already linked to another account"))
+			return
+		}
+
+		c.JSON(http.StatusOK, models.SuccessResponse{
+			Success: true,
+			Data:    existing,
+			Message: "Identity is already linked",
 		})
 		return
 	}
+	if status.Code(err) != codes.NotFound {
+		c.Error(apperrors.Internal("Failed to link identity", err))
+		return
+	}
 
-	c.JSON(http.StatusOK, models.AuthResponse{
-		User:         *user,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    3600,
+	linked, err := ah.linkIdentity(ctx, userID, identity)
+	if status.Code(err) == codes.AlreadyExists {
+		c.Error(apperrors.Conflict("identity_linked", "This identity is already linked to another account"))
+		return
+	}
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to link identity", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.SuccessResponse{
+		Success: true,
+		Data:    linked,
+		Message: "Identity linked successfully",
 	})
 }
 
-// @Summary Logout
-// @Description Logout the current user
+// @Summary Unlink identity
+// @Description Unlink an identity provider from the current user. The last identity cannot be unlinked.
 // @Tags auth
+// @Produce  json
 // @Security ApiKeyAuth
+// @Param id path string true "Identity ID"
 // @Success 200 {object} models.SuccessResponse
-// @Router /auth/logout [post]
-func (ah *AuthHandler) Logout(c *gin.Context) {
-	// In a production system, you might want to blacklist the token
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /auth/identities/{id} [delete]
+func (ah *AuthHandler) UnlinkIdentity(c *gin.Context) {
+	identityID := c.Param("id")
+
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	identities, err := ah.getUserIdentities(ctx, c.GetString("user_id"))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to unlink identity", err))
+		return
+	}
+
+	found := false
+	for _, identity := range identities {
+		if identity.ID == identityID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.Error(apperrors.NotFound("not_found", "Identity not found"))
+		return
+	}
+
+	if len(identities) == 1 {
+		c.Error(apperrors.Conflict("last_identity", "Cannot unlink the only identity used to log in"))
+		return
+	}
+
+	if _, err := ah.firestoreService.Identities().Doc(identityID).Delete(ctx); err != nil {
+		c.Error(apperrors.Internal("Failed to unlink identity", err))
+		return
+	}
+
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
-		Message: "Logged out successfully",
+		Message: "Identity unlinked successfully",
 	})
 }
 
@@ -182,15 +471,12 @@ func (ah *AuthHandler) Logout(c *gin.Context) {
 // @Produce  json
 // @Security ApiKeyAuth
 // @Success 200 {object} models.SuccessResponse
-// @Failure 401 {object} models.ErrorResponse
+// @Failure 401 {object} models.Problem
 // @Router /auth/me [get]
 func (ah *AuthHandler) GetCurrentUser(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-			Error:   "unauthorized",
-			Message: "User not found in context",
-		})
+		c.Error(apperrors.Unauthorized("unauthorized", "User not found in context"))
 		return
 	}
 
@@ -201,71 +487,209 @@ func (ah *AuthHandler) GetCurrentUser(c *gin.Context) {
 }
 
 // Helper functions
-func (ah *AuthHandler) getOrCreateUser(tokenInfo models.GoogleUserInfo) (*models.User, error) {
-	ctx := ah.firestoreService.Context()
 
-	email := tokenInfo.Email
-	name := tokenInfo.Name
-	picture := tokenInfo.Picture
+// login verifies a credential with the given provider and issues tokens for
+// the user the identity belongs to
+func (ah *AuthHandler) login(c *gin.Context, provider services.IdentityProvider, credential, device string) {
+	ctx, cancel := ah.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
 
-	// Check if user exists
-	docs, err := ah.firestoreService.Users().Where("email", "==", tokenInfo.Email).Documents(ctx).GetAll()
+	identity, err := provider.Authenticate(ctx, credential)
 	if err != nil {
-		return nil, err
+		ah.respondAuthenticateError(c, err)
+		return
 	}
 
-	if len(docs) > 0 {
-		// User exists, return it
+	user, err := ah.resolveUser(ctx, provider, identity)
+	switch {
+	case err == errUserDeleted:
+		c.Error(apperrors.Forbidden("account_deleted", "This account has been deleted"))
+		return
+	case err == errIdentityNotLinked:
+		c.Error(apperrors.Conflict("identity_not_linked", "An account with this email already exists; sign in to it and link this provider"))
+		return
+	case err == errEmailNotVerified:
+		c.Error(apperrors.Forbidden("email_not_verified", "The identity provider did not verify an email address for this account"))
+		return
+	case err == services.ErrDomainNotAllowed:
+		c.Error(apperrors.Forbidden("domain_not_allowed", "Sign-up is limited to invited users and allowed email domains"))
+		return
+	case err != nil:
+		c.Error(apperrors.Internal("Failed to process user", fmt.Errorf("resolve %s identity: %w", identity.Provider, err)))
+		return
+	}
+
+	// A pending user may have been invited since signing up
+	user, err = ah.onboarding.ApplyInvitation(ctx, user)
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to process user", err))
+		return
+	}
+
+	if !user.IsApproved() {
+		c.Error(user.ApprovalError())
+		return
+	}
+
+	// Generate JWT tokens
+	tokens, err := ah.tokenService.IssueTokens(ctx, user, services.ClientInfo{
+		Device:    device,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to generate tokens", err))
+		return
+	}
+
+	// Update last login
+	user.LastLoginAt = time.Now()
+	ah.updateUserLastLogin(ctx, user.ID)
+
+	c.JSON(http.StatusOK, models.AuthResponse{
+		User:         *user,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	})
+}
+
+func (ah *AuthHandler) respondAuthenticateError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidCredential) {
+		c.Error(apperrors.Unauthorized("invalid_token", "Invalid credential"))
+		return
+	}
+
+	c.Error(apperrors.Internal("Failed to verify credential", err))
+}
+
+// resolveUser returns the user an identity is linked to. An identity seen for
+// the first time is linked to the user with the same verified email when the
+// provider is trusted to link by email, which keeps accounts created before
+// identities existed, or a new observer is created for it.
+func (ah *AuthHandler) resolveUser(ctx context.Context, provider services.IdentityProvider, identity *models.ExternalIdentity) (*models.User, error) {
+	docRef := ah.firestoreService.Identities().Doc(services.IdentityDocID(identity.Provider, identity.Subject))
+
+	doc, err := docRef.Get(ctx)
+	if err == nil {
+		var linked models.UserIdentity
+		if err := doc.DataTo(&linked); err != nil {
+			return nil, err
+		}
+
+		userDoc, err := ah.firestoreService.Users().Doc(linked.UserID).Get(ctx)
+		if err != nil {
+			return nil, err
+		}
 		var user models.User
-		docs[0].DataTo(&user)
+		if err := userDoc.DataTo(&user); err != nil {
+			return nil, err
+		}
+		if user.DeletedAt != nil {
+			return nil, errUserDeleted
+		}
+
+		if _, err := docRef.Update(ctx, []firestore.Update{{Path: "last_used_at", Value: time.Now()}}); err != nil {
+			slog.WarnContext(ctx, "Failed to update identity last use", "error", err)
+		}
 		return &user, nil
 	}
+	if status.Code(err) != codes.NotFound {
+		return nil, err
+	}
 
-	// Create new user
-	user := &models.User{
-		ID:          utils.GenerateID(),
-		Email:       email,
-		Name:        name,       // Will be updated from Google profile if available
-		Picture:     picture,    // Will be updated from Google profile if available
-		Role:        "observer", // Default role
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-		LastLoginAt: time.Now(),
+	// Accounts are matched by email, so only a verified one may claim them
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errEmailNotVerified
 	}
 
-	_, err = ah.firestoreService.Users().Doc(user.ID).Set(ctx, user)
+	user, err := ah.getOrCreateUser(ctx, identity, provider.LinksByEmail())
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err := ah.linkIdentity(ctx, user.ID, identity); err != nil && status.Code(err) != codes.AlreadyExists {
+		return nil, err
+	}
+
 	return user, nil
 }
 
-func (ah *AuthHandler) getUserByID(userID string) (*models.User, error) {
-	ctx := ah.firestoreService.Context()
-	doc, err := ah.firestoreService.Users().Doc(userID).Get(ctx)
+// getOrCreateUser returns the user with the identity's email, or
+// errIdentityNotLinked when there is one but linkByEmail is false, or creates
+// a new user
+func (ah *AuthHandler) getOrCreateUser(ctx context.Context, identity *models.ExternalIdentity, linkByEmail bool) (*models.User, error) {
+	// Check if user exists
+	docs, err := ah.firestoreService.Users().Where("email", "==", identity.Email).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
 
-	var user models.User
-	err = doc.DataTo(&user)
+	if len(docs) > 0 {
+		// User exists, return it
+		var user models.User
+		if err := docs[0].DataTo(&user); err != nil {
+			return nil, err
+		}
+		if user.DeletedAt != nil {
+			return nil, errUserDeleted
+		}
+		if !linkByEmail {
+			return nil, errIdentityNotLinked
+		}
+		return &user, nil
+	}
+
+	// Create new user with the role and status onboarding allows
+	return ah.onboarding.CreateUser(ctx, identity)
+}
+
+// linkIdentity records that a provider identity belongs to a user. It fails
+// with codes.AlreadyExists if the identity is already linked.
+func (ah *AuthHandler) linkIdentity(ctx context.Context, userID string, identity *models.ExternalIdentity) (*models.UserIdentity, error) {
+	now := time.Now()
+	linked := &models.UserIdentity{
+		ID:         services.IdentityDocID(identity.Provider, identity.Subject),
+		UserID:     userID,
+		Provider:   identity.Provider,
+		Subject:    identity.Subject,
+		Email:      identity.Email,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+
+	if _, err := ah.firestoreService.Identities().Doc(linked.ID).Create(ctx, linked); err != nil {
+		return nil, err
+	}
+
+	return linked, nil
+}
+
+func (ah *AuthHandler) getUserIdentities(ctx context.Context, userID string) ([]models.UserIdentity, error) {
+	docs, err := ah.firestoreService.Identities().Where("user_id", "==", userID).Documents(ctx).GetAll()
 	if err != nil {
 		return nil, err
 	}
 
-	return &user, nil
+	identities := []models.UserIdentity{}
+	for _, doc := range docs {
+		var identity models.UserIdentity
+		if err := doc.DataTo(&identity); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, nil
 }
 
-func (ah *AuthHandler) updateUserLastLogin(userID string) {
-	ctx := ah.firestoreService.Context()
+func (ah *AuthHandler) updateUserLastLogin(ctx context.Context, userID string) {
 	_, err := ah.firestoreService.Users().Doc(userID).Update(ctx,
 		[]firestore.Update{
 			{Path: "last_login_at", Value: time.Now()},
 		},
 	)
 	if err != nil {
-		// handle error
-		log.Printf("Failed to update last login: %v", err)
+		slog.WarnContext(ctx, "Failed to update last login", "user_id", userID, "error", err)
 	}
 }
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/middleware"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRefreshTokenReuse(t *testing.T) {
+	fs := newTestFirestore(t)
+	tokenService := services.NewTokenService(fs)
+	handler := NewAuthHandler(fs, tokenService, nil, nil, nil)
+
+	user := createUser(t, fs, &models.User{ID: "u1", Email: "u1@example.com", Role: "researcher"})
+	issued, err := tokenService.IssueTokens(context.Background(), user, services.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(middleware.Errors())
+	router.POST("/auth/refresh", handler.RefreshToken)
+
+	refresh := func(token string) (int, map[string]interface{}) {
+		recorder := serveJSON(router, http.MethodPost, "/auth/refresh", models.RefreshTokenRequest{RefreshToken: token})
+		var body map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		return recorder.Code, body
+	}
+
+	code, body := refresh(issued.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("first refresh: status %d, body %v", code, body)
+	}
+	rotated, _ := body["refresh_token"].(string)
+	if rotated == "" || rotated == issued.RefreshToken {
+		t.Fatalf("first refresh returned refresh token %q", rotated)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+		error string
+	}{
+		{name: "replayed token", token: issued.RefreshToken, code: http.StatusUnauthorized, error: "token_reused"},
+		{name: "rotated token after reuse", token: rotated, code: http.StatusUnauthorized, error: "invalid_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := refresh(tt.token)
+			if code != tt.code || body["code"] != tt.error {
+				t.Errorf("got status %d, error %v; want %d, %s", code, body["code"], tt.code, tt.error)
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	fs := newTestFirestore(t)
+	tokenService := services.NewTokenService(fs)
+	handler := NewAuthHandler(fs, tokenService, nil, nil, nil)
+	authMiddleware := middleware.NewAuthMiddleware(fs, tokenService, services.NewAPIKeyService(fs))
+
+	user := createUser(t, fs, &models.User{ID: "u1", Email: "u1@example.com", Role: "researcher"})
+	laptop, err := tokenService.IssueTokens(context.Background(), user, services.ClientInfo{Device: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, err := tokenService.IssueTokens(context.Background(), user, services.ClientInfo{Device: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(middleware.Errors())
+	router.GET("/auth/sessions", authMiddleware.RequireAuth(), handler.GetSessions)
+	router.DELETE("/auth/sessions/:id", authMiddleware.RequireAuth(), handler.RevokeSession)
+
+	recorder := serveAs(router, laptop.AccessToken, http.MethodGet, "/auth/sessions", nil)
+	var listed struct {
+		Data []models.Session `json:"data"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &listed)
+	if recorder.Code != http.StatusOK || len(listed.Data) != 2 {
+		t.Fatalf("listing sessions: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	for _, session := range listed.Data {
+		if session.Current != (session.ID == laptop.SessionID) {
+			t.Errorf("session %s (%s) has current=%v", session.ID, session.Device, session.Current)
+		}
+	}
+
+	recorder = serveAs(router, laptop.AccessToken, http.MethodDelete, "/auth/sessions/"+phone.SessionID, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("revoking the phone session: status %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	// The phone's access token has not expired, but its session is gone
+	recorder = serveAs(router, phone.AccessToken, http.MethodGet, "/auth/sessions", nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session: status %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+	recorder = serveAs(router, laptop.AccessToken, http.MethodGet, "/auth/sessions", nil)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("remaining session: status %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestRequireAuthAccountStatus(t *testing.T) {
+	fs := newTestFirestore(t)
+	tokenService := services.NewTokenService(fs)
+	authMiddleware := middleware.NewAuthMiddleware(fs, tokenService, services.NewAPIKeyService(fs))
+
+	router := gin.New()
+	router.Use(middleware.Errors())
+	router.GET("/ping", authMiddleware.RequireAuth(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		status string
+		code   int
+		error  string
+	}{
+		{status: "", code: http.StatusNoContent},
+		{status: models.UserStatusApproved, code: http.StatusNoContent},
+		{status: models.UserStatusPending, code: http.StatusForbidden, error: "account_pending"},
+		{status: models.UserStatusSuspended, code: http.StatusForbidden, error: "account_suspended"},
+	}
+
+	for i, tt := range tests {
+		t.Run("status "+tt.status, func(t *testing.T) {
+			user := createUser(t, fs, &models.User{ID: fmt.Sprintf("u%d", i), Role: "researcher", Status: tt.status})
+			tokens, err := tokenService.IssueTokens(context.Background(), user, services.ClientInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := serveAs(router, tokens.AccessToken, http.MethodGet, "/ping", nil)
+			var body map[string]interface{}
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			if recorder.Code != tt.code || (tt.error != "" && body["code"] != tt.error) {
+				t.Errorf("got status %d, body %s; want %d %s", recorder.Code, recorder.Body, tt.code, tt.error)
+			}
+		})
+	}
+}
+
+func TestLogoutAllKeepsAPIKeys(t *testing.T) {
+	fs := newTestFirestore(t)
+	tokenService := services.NewTokenService(fs)
+	apiKeyService := services.NewAPIKeyService(fs)
+	handler := NewAuthHandler(fs, tokenService, nil, nil, nil)
+	authMiddleware := middleware.NewAuthMiddleware(fs, tokenService, apiKeyService)
+
+	user := createUser(t, fs, &models.User{ID: "u1", Email: "u1@example.com", Role: "researcher"})
+	tokens, err := tokenService.IssueTokens(context.Background(), user, services.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := apiKeyService.Create(context.Background(), user.ID, models.CreateAPIKeyRequest{
+		Name:   "sync script",
+		Scopes: []models.Permission{models.PermissionSubmissionCreate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(middleware.Errors())
+	router.POST("/auth/logout-all", authMiddleware.RequireAuth(), handler.LogoutAll)
+	router.GET("/ping", authMiddleware.RequireAuth(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	withKey := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.Header.Set(middleware.APIKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	recorder := serveAs(router, tokens.AccessToken, http.MethodPost, "/auth/logout-all", nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("logging out everywhere: status %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	if code := serveAs(router, tokens.AccessToken, http.MethodGet, "/ping", nil).Code; code != http.StatusUnauthorized {
+		t.Errorf("access token after logging out everywhere: status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := withKey(); code != http.StatusNoContent {
+		t.Errorf("API key after logging out everywhere: status %d, want %d", code, http.StatusNoContent)
+	}
+
+	// Deleting the user revokes the keys separately
+	if err := tokenService.RevokeAPIKeysForUser(context.Background(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := withKey(); code != http.StatusUnauthorized {
+		t.Errorf("revoked API key: status %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+// stubProvider authenticates a credential of the form "<subject> <email>"
+// as a verified identity
+type stubProvider struct {
+	name         string
+	linksByEmail bool
+}
+
+func (sp *stubProvider) Name() string { return sp.name }
+
+func (sp *stubProvider) LinksByEmail() bool { return sp.linksByEmail }
+
+func (sp *stubProvider) Authenticate(ctx context.Context, credential string) (*models.ExternalIdentity, error) {
+	subject, email, _ := strings.Cut(credential, " ")
+	return &models.ExternalIdentity{Provider: sp.name, Subject: subject, Email: email, EmailVerified: true}, nil
+}
+
+func TestLoginLinksByEmail(t *testing.T) {
+	fs := newTestFirestore(t)
+	onboarding := services.NewOnboardingService(fs, nil, config.OnboardingConfig{AllowedEmailDomains: []string{"example.com"}, InvitationTTL: time.Hour})
+	handler := NewAuthHandler(fs, services.NewTokenService(fs), onboarding, map[string]services.IdentityProvider{
+		"trusted":   &stubProvider{name: "trusted", linksByEmail: true},
+		"untrusted": &stubProvider{name: "untrusted"},
+	}, nil)
+
+	createUser(t, fs, &models.User{ID: "existing", Email: "farmer@example.com", Role: "researcher", Status: models.UserStatusApproved})
+	_, err := fs.Identities().Doc(services.IdentityDocID("untrusted", "linked")).Set(context.Background(), &models.UserIdentity{
+		ID:       services.IdentityDocID("untrusted", "linked"),
+		UserID:   "existing",
+		Provider: "untrusted",
+		Subject:  "linked",
+		Email:    "farmer@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(middleware.Errors())
+	router.POST("/auth/login/:provider", handler.Login)
+
+	tests := []struct {
+		name       string
+		provider   string
+		credential string
+		code       int
+		error      string
+		wantUserID string
+	}{
+		{name: "untrusted provider can't claim an existing account", provider: "untrusted", credential: "new-subject farmer@example.com", code: http.StatusConflict, error: "identity_not_linked"},
+		{name: "trusted provider links the existing account", provider: "trusted", credential: "new-subject farmer@example.com", code: http.StatusOK, wantUserID: "existing"},
+		{name: "identity linked from the account signs in", provider: "untrusted", credential: "linked farmer@example.com", code: http.StatusOK, wantUserID: "existing"},
+		{name: "untrusted provider signs up a new address", provider: "untrusted", credential: "other-subject new@example.com", code: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveJSON(router, http.MethodPost, "/auth/login/"+tt.provider, models.LoginRequest{Credential: tt.credential})
+			var body struct {
+				Code string      `json:"code"`
+				User models.User `json:"user"`
+			}
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			if recorder.Code != tt.code || body.Code != tt.error {
+				t.Fatalf("got status %d, body %s; want %d %s", recorder.Code, recorder.Body, tt.code, tt.error)
+			}
+			if tt.wantUserID != "" && body.User.ID != tt.wantUserID {
+				t.Errorf("signed in as %q, want %q", body.User.ID, tt.wantUserID)
+			}
+		})
+	}
+}
+
+// recordingMailer records the addresses it sends to
+type recordingMailer struct {
+	sent []string
+}
+
+func (rm *recordingMailer) Send(ctx context.Context, to, subject, body string) error {
+	rm.sent = append(rm.sent, to)
+	return nil
+}
+
+func TestRequestMagicLink(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFirestore(t)
+	mailer := &recordingMailer{}
+	onboarding := services.NewOnboardingService(fs, nil, config.OnboardingConfig{AllowedEmailDomains: []string{"irri.org"}, InvitationTTL: time.Hour})
+	handler := NewAuthHandler(fs, services.NewTokenService(fs), onboarding, nil, services.NewMagicLinkService(fs, onboarding, mailer, "http://localhost:3000/login/email"))
+
+	deletedAt := time.Now()
+	createUser(t, fs, &models.User{ID: "farmer", Email: "farmer@example.com", Role: "observer"})
+	createUser(t, fs, &models.User{ID: "gone", Email: "gone@example.com", Role: "observer", DeletedAt: &deletedAt})
+	if _, err := onboarding.Invite(ctx, "invited@example.com", "observer", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(middleware.Errors())
+	router.POST("/auth/magic-link", handler.RequestMagicLink)
+
+	tests := []struct {
+		email    string
+		wantSent bool
+	}{
+		{email: "farmer@example.com", wantSent: true},
+		{email: "invited@example.com", wantSent: true},
+		{email: "agronomist@irri.org", wantSent: true},
+		{email: "gone@example.com", wantSent: false},
+		{email: "stranger@example.com", wantSent: false},
+	}
+
+	var wantBody string
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			mailer.sent = nil
+			recorder := serveJSON(router, http.MethodPost, "/auth/magic-link", models.MagicLinkRequest{Email: tt.email})
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("got status %d, body %s", recorder.Code, recorder.Body)
+			}
+
+			// The response must not tell which addresses can sign in
+			if wantBody == "" {
+				wantBody = recorder.Body.String()
+			} else if recorder.Body.String() != wantBody {
+				t.Errorf("got body %s, want %s", recorder.Body, wantBody)
+			}
+
+			if sent := len(mailer.sent) == 1 && mailer.sent[0] == tt.email; sent != tt.wantSent {
+				t.Errorf("sent to %v, want sent %v", mailer.sent, tt.wantSent)
+			}
+		})
+	}
+}
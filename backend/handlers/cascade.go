@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+	"rice-monitor-api/utils"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+// dependentBatchSize bounds the documents touched by one transaction. With
+// the aggregate counters a submission can change, up to three more per
+// document, a batch stays under Firestore's 500 writes per commit.
+const dependentBatchSize = 100
+
+// deletePolicy is the caller's explicit choice of what happens to records
+// that depend on the one being deleted. Without cascade or reassign_to the
+// delete is refused while dependents exist.
+type deletePolicy struct {
+	cascade    []string
+	reassignTo string
+}
+
+func parseDeletePolicy(c *gin.Context, allowedCascade ...string) (*deletePolicy, error) {
+	policy := &deletePolicy{reassignTo: c.Query("reassign_to")}
+
+	if cascade := c.Query("cascade"); cascade != "" {
+		for _, target := range strings.Split(cascade, ",") {
+			target = strings.TrimSpace(target)
+			if !utils.Contains(allowedCascade, target) {
+				return nil, fmt.Errorf("cascade must be one of: %s", strings.Join(allowedCascade, ", "))
+			}
+			policy.cascade = append(policy.cascade, target)
+		}
+	}
+
+	if len(policy.cascade) > 0 && policy.reassignTo != "" {
+		return nil, errors.New("cascade and reassign_to cannot be combined")
+	}
+
+	return policy, nil
+}
+
+func (p *deletePolicy) cascades(target string) bool {
+	return utils.Contains(p.cascade, target)
+}
+
+func (p *deletePolicy) name() string {
+	switch {
+	case p.reassignTo != "":
+		return "reassign"
+	case len(p.cascade) > 0:
+		return "cascade"
+	default:
+		return "refuse"
+	}
+}
+
+// activeDocuments returns the documents matching query that are not
+// soft-deleted
+func activeDocuments(ctx context.Context, query firestore.Query) ([]*firestore.DocumentSnapshot, error) {
+	docs, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var active []*firestore.DocumentSnapshot
+	for _, doc := range docs {
+		if isActive(doc) {
+			active = append(active, doc)
+		}
+	}
+
+	return active, nil
+}
+
+func isActive(doc *firestore.DocumentSnapshot) bool {
+	deletedAt, err := doc.DataAt("deleted_at")
+	return err != nil || deletedAt == nil
+}
+
+// countImages sums the images attached to submission documents
+func countImages(docs []*firestore.DocumentSnapshot) int {
+	total := 0
+	for _, doc := range docs {
+		if images, err := doc.DataAt("images"); err == nil {
+			if list, ok := images.([]interface{}); ok {
+				total += len(list)
+			}
+		}
+	}
+	return total
+}
+
+// batchHook adds writes to a batch transaction for the snapshots it updated
+type batchHook func(tx *firestore.Transaction, updated []*firestore.DocumentSnapshot) error
+
+// updateInBatches applies updates to docs with one transaction per batch, so
+// every batch commits atomically. Documents that were soft-deleted since they
+// were listed are skipped, which makes a failed run safe to retry. A non-nil
+// hook runs in each batch after its updates. Returns the number of documents
+// updated.
+func updateInBatches(ctx context.Context, firestoreService *services.FirestoreService, docs []*firestore.DocumentSnapshot, updates []firestore.Update, hook batchHook) (int, error) {
+	return updateMatchingInBatches(ctx, firestoreService, docs, updates, isActive, hook)
+}
+
+// updateMatchingInBatches is updateInBatches for the documents that still
+// match when their batch runs
+func updateMatchingInBatches(ctx context.Context, firestoreService *services.FirestoreService, docs []*firestore.DocumentSnapshot, updates []firestore.Update, matches func(*firestore.DocumentSnapshot) bool, hook batchHook) (int, error) {
+	updated := 0
+
+	for start := 0; start < len(docs); start += dependentBatchSize {
+		end := start + dependentBatchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+
+		refs := make([]*firestore.DocumentRef, 0, end-start)
+		for _, doc := range docs[start:end] {
+			refs = append(refs, doc.Ref)
+		}
+
+		batchUpdated := 0
+		err := firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			batchUpdated = 0
+
+			snapshots, err := tx.GetAll(refs)
+			if err != nil {
+				return err
+			}
+
+			var updated []*firestore.DocumentSnapshot
+			for _, snapshot := range snapshots {
+				if !snapshot.Exists() || !matches(snapshot) {
+					continue
+				}
+				if err := tx.Update(snapshot.Ref, updates); err != nil {
+					return err
+				}
+				updated = append(updated, snapshot)
+			}
+			batchUpdated = len(updated)
+
+			if hook == nil {
+				return nil
+			}
+			return hook(tx, updated)
+		})
+		if err != nil {
+			return updated, err
+		}
+
+		updated += batchUpdated
+	}
+
+	return updated, nil
+}
+
+// submissionAggregatesHook keeps the analytics aggregates in step with a
+// batch of submission updates; change returns a submission as updated
+func submissionAggregatesHook(aggregateService *services.AggregateService, change func(models.Submission) models.Submission) batchHook {
+	return func(tx *firestore.Transaction, updated []*firestore.DocumentSnapshot) error {
+		delta := services.NewAggregateDelta()
+		for _, snapshot := range updated {
+			var before models.Submission
+			if err := snapshot.DataTo(&before); err != nil {
+				return err
+			}
+			after := change(before)
+			delta.Add(&before, &after)
+		}
+		return aggregateService.Apply(tx, delta)
+	}
+}
+
+// cascadeMarker names the record whose delete cascaded to a dependent, as
+// <collection>/<id>
+func cascadeMarker(ref *firestore.DocumentRef) string {
+	return ref.Parent.ID + "/" + ref.ID
+}
+
+// cascadeDeleteUpdates soft-deletes a dependent and records the record whose
+// delete it went with, so restoring that record brings it back
+func cascadeDeleteUpdates(deletedBy, marker string) []firestore.Update {
+	return append(softDeleteUpdates(deletedBy), firestore.Update{Path: "deleted_with", Value: marker})
+}
+
+// restoreCascaded restores the documents of collection that were soft-deleted
+// with the record marker names. Like updateInBatches it runs in batches and
+// is safe to retry.
+func restoreCascaded(ctx context.Context, firestoreService *services.FirestoreService, collection *firestore.CollectionRef, marker string, hook batchHook) (int, error) {
+	docs, err := collection.Where("deleted_with", "==", marker).Documents(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	deletedWith := func(doc *firestore.DocumentSnapshot) bool {
+		value, err := doc.DataAt("deleted_with")
+		return err == nil && value == marker
+	}
+	return updateMatchingInBatches(ctx, firestoreService, docs, restoreUpdates(), deletedWith, hook)
+}
+
+// restoredSubmission returns submission as restored
+func restoredSubmission(submission models.Submission) models.Submission {
+	submission.DeletedAt = nil
+	return submission
+}
+
+// softDeletedSubmission returns submission as soft-deleted by the cascade
+func softDeletedSubmission(submission models.Submission) models.Submission {
+	now := time.Now()
+	submission.DeletedAt = &now
+	return submission
+}
+
+// uniqueDocuments concatenates document lists, dropping repeats
+func uniqueDocuments(lists ...[]*firestore.DocumentSnapshot) []*firestore.DocumentSnapshot {
+	seen := make(map[string]bool)
+	var unique []*firestore.DocumentSnapshot
+	for _, docs := range lists {
+		for _, doc := range docs {
+			if seen[doc.Ref.Path] {
+				continue
+			}
+			seen[doc.Ref.Path] = true
+			unique = append(unique, doc)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"rice-monitor-api/apperrors"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report invalid fields by their JSON names rather than Go field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+// bindError reports a request body that failed to bind, with one entry per
+// invalid field where the cause is known
+func bindError(err error) *apperrors.Error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]apperrors.FieldError, 0, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			fields = append(fields, apperrors.FieldError{
+				Field:   fieldErr.Field(),
+				Message: validationMessage(fieldErr),
+			})
+		}
+		return apperrors.Validation("invalid_request", "Request validation failed", fields...)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return apperrors.Validation("invalid_request", "Request validation failed", apperrors.FieldError{
+			Field:   typeErr.Field,
+			Message: "must be of type " + typeErr.Type.String(),
+		})
+	}
+
+	return apperrors.Validation("invalid_request", "Request body is not valid JSON")
+}
+
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fieldErr.Param(), " ", ", ")
+	case "min":
+		if fieldErr.Kind() == reflect.String {
+			return "must be at least " + fieldErr.Param() + " characters"
+		}
+		if fieldErr.Kind() == reflect.Slice {
+			return "must have at least " + fieldErr.Param() + " items"
+		}
+		return "must be at least " + fieldErr.Param()
+	case "max":
+		if fieldErr.Kind() == reflect.String {
+			return "must be at most " + fieldErr.Param() + " characters"
+		}
+		if fieldErr.Kind() == reflect.Slice {
+			return "must have at most " + fieldErr.Param() + " items"
+		}
+		return "must be at most " + fieldErr.Param()
+	default:
+		return "failed the " + fieldErr.Tag() + " check"
+	}
+}
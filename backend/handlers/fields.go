@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"rice-monitor-api/apperrors"
 	"rice-monitor-api/models"
 	"rice-monitor-api/services"
 	"rice-monitor-api/utils"
@@ -14,11 +18,13 @@ import (
 
 type FieldHandler struct {
 	firestoreService *services.FirestoreService
+	aggregateService *services.AggregateService
 }
 
-func NewFieldHandler(firestoreService *services.FirestoreService) *FieldHandler {
+func NewFieldHandler(firestoreService *services.FirestoreService, aggregateService *services.AggregateService) *FieldHandler {
 	return &FieldHandler{
 		firestoreService: firestoreService,
+		aggregateService: aggregateService,
 	}
 }
 
@@ -27,34 +33,40 @@ func NewFieldHandler(firestoreService *services.FirestoreService) *FieldHandler
 // @Tags fields
 // @Produce  json
 // @Security ApiKeyAuth
+// @Param deleted query bool false "List soft-deleted fields instead"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 500 {object} models.Problem
 // @Router /fields [get]
 func (fh *FieldHandler) GetFields(c *gin.Context) {
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
+	showDeleted := c.Query("deleted") == "true"
 
-	ctx := fh.firestoreService.Context()
+	ctx, cancel := fh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
 	query := fh.firestoreService.Fields().Query
 
-	// Filter by owner (non-admin users can only see their fields)
-	if user.Role != "admin" {
+	// Filter by owner unless they may see everyone's fields
+	if !user.Can(models.PermissionFieldViewAll) {
 		query = query.Where("owner_id", "==", user.ID)
 	}
 
 	docs, err := query.Documents(ctx).GetAll()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve fields",
-		})
+		c.Error(apperrors.Internal("Failed to retrieve fields", err))
 		return
 	}
 
 	var fields []models.Field
 	for _, doc := range docs {
 		var field models.Field
-		doc.DataTo(&field)
+		if err := doc.DataTo(&field); err != nil {
+			c.Error(apperrors.Internal("Failed to decode field "+doc.Ref.ID, err))
+			return
+		}
+		if (field.DeletedAt != nil) != showDeleted {
+			continue
+		}
 		fields = append(fields, field)
 	}
 
@@ -72,16 +84,13 @@ func (fh *FieldHandler) GetFields(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Param field body models.CreateFieldRequest true "Field object that needs to be added"
 // @Success 201 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /fields [post]
 func (fh *FieldHandler) CreateField(c *gin.Context) {
 	var req models.CreateFieldRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: err.Error(),
-		})
+		c.Error(bindError(err))
 		return
 	}
 
@@ -99,13 +108,11 @@ func (fh *FieldHandler) CreateField(c *gin.Context) {
 		UpdatedAt:   time.Now(),
 	}
 
-	ctx := fh.firestoreService.Context()
+	ctx, cancel := fh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
 	_, err := fh.firestoreService.Fields().Doc(field.ID).Set(ctx, field)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to create field",
-		})
+		c.Error(apperrors.Internal("Failed to create field", err))
 		return
 	}
 
@@ -123,29 +130,30 @@ func (fh *FieldHandler) CreateField(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Param id path string true "Field ID"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
 // @Router /fields/{id} [get]
 func (fh *FieldHandler) GetField(c *gin.Context) {
 	fieldID := c.Param("id")
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
 
-	field, err := fh.getFieldByID(fieldID)
+	ctx, cancel := fh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	field, err := fh.getFieldByID(ctx, fieldID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "not_found",
-			Message: "Field not found",
-		})
+		c.Error(apperrors.Lookup(err, "Field"))
+		return
+	}
+	if field.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "Field not found"))
 		return
 	}
 
 	// Check if user can access this field
-	if user.Role != "admin" && field.OwnerID != user.ID {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
+	if field.OwnerID != user.ID && !user.Can(models.PermissionFieldViewAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
 		return
 	}
 
@@ -164,10 +172,10 @@ func (fh *FieldHandler) GetField(c *gin.Context) {
 // @Param id path string true "Field ID"
 // @Param field body object true "Field object that needs to be updated"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /fields/{id} [put]
 func (fh *FieldHandler) UpdateField(c *gin.Context) {
 	fieldID := c.Param("id")
@@ -176,29 +184,27 @@ func (fh *FieldHandler) UpdateField(c *gin.Context) {
 
 	var updateData map[string]interface{}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: err.Error(),
-		})
+		c.Error(bindError(err))
 		return
 	}
 
+	ctx, cancel := fh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
 	// Get existing field
-	field, err := fh.getFieldByID(fieldID)
+	field, err := fh.getFieldByID(ctx, fieldID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "not_found",
-			Message: "Field not found",
-		})
+		c.Error(apperrors.Lookup(err, "Field"))
+		return
+	}
+	if field.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "Field not found"))
 		return
 	}
 
 	// Check permissions
-	if user.Role != "admin" && field.OwnerID != user.ID {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
+	if !user.CanAccess(field.OwnerID, models.PermissionFieldEditOwn, models.PermissionFieldEditAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
 		return
 	}
 
@@ -206,32 +212,27 @@ func (fh *FieldHandler) UpdateField(c *gin.Context) {
 	delete(updateData, "id")
 	delete(updateData, "owner_id")
 	delete(updateData, "created_at")
+	delete(updateData, "deleted_at")
+	delete(updateData, "deleted_by")
+	delete(updateData, "deleted_with")
 	updateData["updated_at"] = time.Now()
 
-	ctx := fh.firestoreService.Context()
-
 	// Update document
-	updates := []firestore.Update{{Path: "updated_at", Value: time.Now()}}
+	updates := make([]firestore.Update, 0, len(updateData))
 	for key, value := range updateData {
 		updates = append(updates, firestore.Update{Path: key, Value: value})
 	}
 
 	_, err = fh.firestoreService.Fields().Doc(fieldID).Update(ctx, updates)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to update field",
-		})
+		c.Error(apperrors.Internal("Failed to update field", err))
 		return
 	}
 
 	// Get updated field
-	updatedField, err := fh.getFieldByID(fieldID)
+	updatedField, err := fh.getFieldByID(ctx, fieldID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve updated field",
-		})
+		c.Error(apperrors.Internal("Failed to retrieve updated field", err))
 		return
 	}
 
@@ -243,61 +244,158 @@ func (fh *FieldHandler) UpdateField(c *gin.Context) {
 }
 
 // @Summary Delete a field
-// @Description Delete a field by its ID
+// @Description Soft-delete a field by its ID; it can be restored until the retention period expires.
+// @Description Deletion is refused while the field has submissions unless cascade=submissions is given.
 // @Tags fields
 // @Produce  json
 // @Security ApiKeyAuth
 // @Param id path string true "Field ID"
-// @Success 200 {object} models.SuccessResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Param cascade query string false "Also soft-delete dependents (submissions)"
+// @Success 200 {object} models.SuccessResponse{data=models.DeleteSummary}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /fields/{id} [delete]
 func (fh *FieldHandler) DeleteField(c *gin.Context) {
 	fieldID := c.Param("id")
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
 
+	ctx, cancel := fh.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
+
 	// Get existing field
-	field, err := fh.getFieldByID(fieldID)
+	field, err := fh.getFieldByID(ctx, fieldID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "not_found",
-			Message: "Field not found",
-		})
+		c.Error(apperrors.Lookup(err, "Field"))
+		return
+	}
+	if field.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "Field not found"))
 		return
 	}
 
 	// Check permissions
-	if user.Role != "admin" && field.OwnerID != user.ID {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
+	if !user.CanAccess(field.OwnerID, models.PermissionFieldDeleteOwn, models.PermissionFieldDeleteAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
+		return
+	}
+
+	policy, err := parseDeletePolicy(c, "submissions")
+	if err == nil && policy.reassignTo != "" {
+		err = errors.New("reassign_to is not supported when deleting fields")
+	}
+	if err != nil {
+		c.Error(apperrors.Validation("invalid_request", err.Error()))
 		return
 	}
 
-	ctx := fh.firestoreService.Context()
+	submissions, err := activeDocuments(ctx, fh.firestoreService.Submissions().Where("field_id", "==", fieldID))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to check field submissions", err))
+		return
+	}
+
+	if len(submissions) > 0 && !policy.cascades("submissions") {
+		c.Error(apperrors.Conflict("has_dependents", fmt.Sprintf("Field has %d submissions; pass cascade=submissions to delete them too", len(submissions))))
+		return
+	}
+
+	summary := models.DeleteSummary{
+		Policy: policy.name(),
+		Images: countImages(submissions),
+	}
 
-	// Delete field
-	_, err = fh.firestoreService.Fields().Doc(fieldID).Delete(ctx)
+	// Dependents go first so a failure leaves the field in place for a retry
+	fieldRef := fh.firestoreService.Fields().Doc(fieldID)
+	summary.Submissions, err = updateInBatches(ctx, fh.firestoreService, submissions, cascadeDeleteUpdates(user.ID, cascadeMarker(fieldRef)),
+		submissionAggregatesHook(fh.aggregateService, softDeletedSubmission))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to delete field",
-		})
+		c.Error(apperrors.Internal("Failed to delete field submissions", err))
 		return
 	}
 
+	// Soft-delete field
+	_, err = fieldRef.Update(ctx, softDeleteUpdates(user.ID))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to delete field", err))
+		return
+	}
+	summary.Fields = 1
+
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
+		Data:    summary,
 		Message: "Field deleted successfully",
 	})
 }
 
+// @Summary Restore a field
+// @Description Restore a soft-deleted field, with the submissions its delete cascaded to
+// @Tags fields
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "Field ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /fields/{id}/restore [post]
+func (fh *FieldHandler) RestoreField(c *gin.Context) {
+	fieldID := c.Param("id")
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := fh.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
+
+	field, err := fh.getFieldByID(ctx, fieldID)
+	if err != nil {
+		c.Error(apperrors.Lookup(err, "Field"))
+		return
+	}
+
+	// Check permissions
+	if !user.CanAccess(field.OwnerID, models.PermissionFieldDeleteOwn, models.PermissionFieldDeleteAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
+		return
+	}
+
+	if field.DeletedAt == nil {
+		c.Error(apperrors.Validation("invalid_request", "Field is not deleted"))
+		return
+	}
+
+	// Submissions deleted with the field come back with it, first so a
+	// failure leaves the field deleted for a retry
+	fieldRef := fh.firestoreService.Fields().Doc(fieldID)
+	_, err = restoreCascaded(ctx, fh.firestoreService, fh.firestoreService.Submissions(), cascadeMarker(fieldRef),
+		submissionAggregatesHook(fh.aggregateService, restoredSubmission))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to restore field submissions", err))
+		return
+	}
+
+	_, err = fieldRef.Update(ctx, restoreUpdates())
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to restore field", err))
+		return
+	}
+
+	field.DeletedAt = nil
+	field.DeletedBy = ""
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    field,
+		Message: "Field restored successfully",
+	})
+}
+
 // Helper function
-func (fh *FieldHandler) getFieldByID(fieldID string) (*models.Field, error) {
-	ctx := fh.firestoreService.Context()
+func (fh *FieldHandler) getFieldByID(ctx context.Context, fieldID string) (*models.Field, error) {
 	doc, err := fh.firestoreService.Fields().Doc(fieldID).Get(ctx)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"rice-monitor-api/middleware"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUpdateField(t *testing.T) {
+	fs := newTestFirestore(t)
+	handler := NewFieldHandler(fs, services.NewAggregateService(fs))
+	user := &models.User{ID: "u1", Role: "researcher"}
+
+	field := &models.Field{ID: "f1", Name: "North plot", Location: "Los Baños", OwnerID: user.ID}
+	if _, err := fs.Fields().Doc(field.ID).Set(context.Background(), field); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(middleware.Errors(), func(c *gin.Context) { c.Set("user", user) })
+	router.PUT("/fields/:id", handler.UpdateField)
+
+	recorder := serveJSON(router, http.MethodPut, "/fields/f1", map[string]interface{}{"name": "South plot", "owner_id": "u2"})
+	var body struct {
+		Data models.Field `json:"data"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", recorder.Code, recorder.Body)
+	}
+	if body.Data.Name != "South plot" || body.Data.OwnerID != user.ID {
+		t.Errorf("got name %q, owner %q; want the name changed and the owner kept", body.Data.Name, body.Data.OwnerID)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/firestoretest"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+	"rice-monitor-api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+
+	provider, err := utils.NewHMACKeyProvider("test-secret", false)
+	if err != nil {
+		panic(err)
+	}
+	utils.SetKeyProvider(provider)
+}
+
+// newTestFirestore connects to an empty project on the Firestore emulator
+func newTestFirestore(t *testing.T) *services.FirestoreService {
+	t.Helper()
+
+	firestoreService, err := services.NewFirestoreService(context.Background(), firestoretest.ProjectID(t), config.Default().Datastore)
+	if err != nil {
+		t.Fatalf("connecting to the Firestore emulator: %v", err)
+	}
+	t.Cleanup(func() { firestoreService.Close() })
+
+	return firestoreService
+}
+
+// newTestStorage points a StorageService at a fake Cloud Storage JSON API
+// served by handler
+func newTestStorage(t *testing.T, handler http.Handler) *services.StorageService {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	storageService, err := services.NewStorageService(context.Background(), "test-bucket", config.Default().Datastore)
+	if err != nil {
+		t.Fatalf("creating storage client: %v", err)
+	}
+	t.Cleanup(func() { storageService.Close() })
+
+	return storageService
+}
+
+// serveJSON sends a request with body encoded as JSON, when not nil, to router
+func serveJSON(router http.Handler, method, path string, body interface{}) *httptest.ResponseRecorder {
+	return serveAs(router, "", method, path, body)
+}
+
+// serveAs is serveJSON with token, when not empty, sent as the bearer token
+func serveAs(router http.Handler, token, method, path string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// createUser stores user in Firestore
+func createUser(t *testing.T, fs *services.FirestoreService, user *models.User) *models.User {
+	t.Helper()
+
+	if _, err := fs.Users().Doc(user.ID).Set(context.Background(), user); err != nil {
+		t.Fatalf("creating user %s: %v", user.ID, err)
+	}
+	return user
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	firestoreService *services.FirestoreService
+	storageService   *services.StorageService
+	buildInfo        models.BuildInfo
+	probeTimeout     time.Duration
+}
+
+func NewHealthHandler(firestoreService *services.FirestoreService, storageService *services.StorageService, buildInfo models.BuildInfo, probeTimeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		firestoreService: firestoreService,
+		storageService:   storageService,
+		buildInfo:        buildInfo,
+		probeTimeout:     probeTimeout,
+	}
+}
+
+// @Summary Liveness probe
+// @Description Reports that the server process is running. It does not check dependencies.
+// @Tags health
+// @Produce  json
+// @Success 200 {object} models.HealthStatus
+// @Router /healthz [get]
+func (hh *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, models.HealthStatus{
+		Status:    "ok",
+		Timestamp: time.Now().UTC(),
+	})
+}
+
+// @Summary Readiness probe
+// @Description Probes Firestore and the image bucket, each with a timeout, and reports whether
+// @Description the server can serve traffic
+// @Tags health
+// @Produce  json
+// @Success 200 {object} models.ReadinessStatus
+// @Failure 503 {object} models.ReadinessStatus
+// @Router /readyz [get]
+func (hh *HealthHandler) Readyz(c *gin.Context) {
+	probes := map[string]func(context.Context) error{
+		"firestore": hh.firestoreService.Ping,
+		"storage":   hh.storageService.Ping,
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	checks := make(map[string]models.DependencyCheck, len(probes))
+	for name, probe := range probes {
+		wg.Add(1)
+		go func(name string, probe func(context.Context) error) {
+			defer wg.Done()
+			check := hh.probe(c.Request.Context(), name, probe)
+
+			mu.Lock()
+			checks[name] = check
+			mu.Unlock()
+		}(name, probe)
+	}
+	wg.Wait()
+
+	readiness := models.ReadinessStatus{
+		Status:    "ready",
+		Checks:    checks,
+		Timestamp: time.Now().UTC(),
+	}
+	code := http.StatusOK
+	for _, check := range checks {
+		if check.Status != "ok" {
+			readiness.Status = "not_ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(code, readiness)
+}
+
+// @Summary Build information
+// @Description Version, commit, build time and Go version of the running server
+// @Tags health
+// @Produce  json
+// @Success 200 {object} models.BuildInfo
+// @Router /version [get]
+func (hh *HealthHandler) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, hh.buildInfo)
+}
+
+// probe runs one dependency check. Errors are logged in full but only
+// summarised in the response, which is public.
+func (hh *HealthHandler) probe(ctx context.Context, name string, probe func(context.Context) error) models.DependencyCheck {
+	ctx, cancel := context.WithTimeout(ctx, hh.probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := probe(ctx)
+	check := models.DependencyCheck{
+		Status:    "ok",
+		LatencyMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Readiness probe failed", "dependency", name, "error", err)
+		check.Status = "error"
+		check.Error = "unavailable"
+		if errors.Is(err, context.DeadlineExceeded) || ctx.Err() == context.DeadlineExceeded {
+			check.Error = "timeout"
+		}
+	}
+
+	return check
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHealthzAndVersion(t *testing.T) {
+	buildInfo := models.BuildInfo{Version: "v1.2.3", Commit: "abc123", BuildTime: "2024-06-01T00:00:00Z", GoVersion: "go1.21.0"}
+	handler := NewHealthHandler(nil, nil, buildInfo, time.Second)
+
+	router := gin.New()
+	router.GET("/healthz", handler.Healthz)
+	router.GET("/version", handler.Version)
+
+	recorder := serveJSON(router, http.MethodGet, "/healthz", nil)
+	var health models.HealthStatus
+	json.Unmarshal(recorder.Body.Bytes(), &health)
+	if recorder.Code != http.StatusOK || health.Status != "ok" {
+		t.Errorf("healthz: got status %d, body %s", recorder.Code, recorder.Body)
+	}
+
+	recorder = serveJSON(router, http.MethodGet, "/version", nil)
+	var version models.BuildInfo
+	json.Unmarshal(recorder.Body.Bytes(), &version)
+	if recorder.Code != http.StatusOK || version != buildInfo {
+		t.Errorf("version: got status %d, body %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name         string
+		storage      http.HandlerFunc
+		code         int
+		status       string
+		storageCheck string
+		storageError string
+	}{
+		{
+			name: "all dependencies reachable",
+			storage: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"kind": "storage#objects"}`))
+			},
+			code: http.StatusOK, status: "ready", storageCheck: "ok",
+		},
+		{
+			name: "bucket refuses access",
+			storage: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error": {"code": 403, "message": "forbidden"}}`, http.StatusForbidden)
+			},
+			code: http.StatusServiceUnavailable, status: "not_ready", storageCheck: "error", storageError: "unavailable",
+		},
+		{
+			name: "bucket too slow",
+			storage: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+			code: http.StatusServiceUnavailable, status: "not_ready", storageCheck: "error", storageError: "timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newTestFirestore(t)
+			handler := NewHealthHandler(fs, newTestStorage(t, tt.storage), models.BuildInfo{}, 200*time.Millisecond)
+
+			router := gin.New()
+			router.GET("/readyz", handler.Readyz)
+
+			recorder := serveJSON(router, http.MethodGet, "/readyz", nil)
+			var readiness models.ReadinessStatus
+			json.Unmarshal(recorder.Body.Bytes(), &readiness)
+
+			if recorder.Code != tt.code || readiness.Status != tt.status {
+				t.Errorf("got status %d, body %s; want %d %s", recorder.Code, recorder.Body, tt.code, tt.status)
+			}
+			if readiness.Checks["firestore"].Status != "ok" {
+				t.Errorf("got firestore check %+v, want ok", readiness.Checks["firestore"])
+			}
+			if check := readiness.Checks["storage"]; check.Status != tt.storageCheck || check.Error != tt.storageError {
+				t.Errorf("got storage check %+v, want %s %s", check, tt.storageCheck, tt.storageError)
+			}
+		})
+	}
+}
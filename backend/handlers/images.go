@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/metrics"
 	"rice-monitor-api/models"
 	"rice-monitor-api/services"
 	"rice-monitor-api/utils"
@@ -15,6 +19,8 @@ import (
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type ImageHandler struct {
@@ -38,26 +44,48 @@ func NewImageHandler(storageService *services.StorageService, firestoreService *
 // @Param submission_id formData string true "Submission ID"
 // @Param image formData file true "Image file"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /images/upload [post]
 func (ih *ImageHandler) UploadImage(c *gin.Context) {
 	submissionID := c.PostForm("submission_id")
 	if submissionID == "" {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: "submission_id is required",
-		})
+		c.Error(apperrors.Validation("invalid_request", "submission_id is required"))
 		return
 	}
 
+	// The upload streams the request body, so the whole request gets the
+	// longer upload timeout
+	ctx, cancel := ih.storageService.WithUploadTimeout(c.Request.Context())
+	defer cancel()
+
+	// Images can only be attached to submissions the user may edit
+	isTemp := strings.HasPrefix(submissionID, "temp_")
+	if !isTemp {
+		currentUser, _ := c.Get("user")
+		user := currentUser.(*models.User)
+
+		submission, err := ih.getSubmissionByID(ctx, submissionID)
+		if err != nil {
+			c.Error(apperrors.Lookup(err, "Submission"))
+			return
+		}
+		if submission.DeletedAt != nil {
+			c.Error(apperrors.NotFound("not_found", "Submission not found"))
+			return
+		}
+		if !user.CanAccess(submission.UserID, models.PermissionSubmissionEditOwn, models.PermissionSubmissionEditAll) {
+			c.Error(apperrors.Forbidden("forbidden", "Access denied"))
+			return
+		}
+	}
+
 	// Get uploaded file
 	file, header, err := c.Request.FormFile("image")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: "No file uploaded",
-		})
+		c.Error(apperrors.Validation("invalid_request", "No file uploaded"))
 		return
 	}
 	defer file.Close()
@@ -65,10 +93,7 @@ func (ih *ImageHandler) UploadImage(c *gin.Context) {
 	// Validate file type
 	ext := filepath.Ext(header.Filename)
 	if !utils.ValidateFileType(header.Filename) {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_file_type",
-			Message: "Only JPG, JPEG, PNG, and WebP files are allowed",
-		})
+		c.Error(apperrors.Validation("invalid_file_type", "Only JPG, JPEG, PNG, and WebP files are allowed"))
 		return
 	}
 
@@ -80,46 +105,37 @@ func (ih *ImageHandler) UploadImage(c *gin.Context) {
 		ext)
 
 	// Upload to Google Cloud Storage
-	ctx := ih.storageService.Context()
 	obj := ih.storageService.Bucket().Object(filename)
 
 	wc := obj.NewWriter(ctx)
 	wc.ContentType = header.Header.Get("Content-Type")
 
-	if _, err := io.Copy(wc, file); err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "upload_failed",
-			Message: "Failed to upload file",
-		})
+	size, err := io.Copy(wc, file)
+	if err != nil {
+		c.Error(apperrors.Upstream("upload_failed", "Failed to upload file", err))
 		return
 	}
 
 	if err := wc.Close(); err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "upload_failed",
-			Message: "Failed to finalize upload",
-		})
+		c.Error(apperrors.Upstream("upload_failed", "Failed to finalize upload", err))
 		return
 	}
+	metrics.ImageUploadBytes.Observe(float64(size))
 
 	// Make the object publicly accessible
 	if err := obj.ACL().Set(ctx, storage.AllUsers, storage.RoleReader); err != nil {
 		// Log error but don't fail the request
-		fmt.Printf("Failed to make object public: %v\n", err)
+		slog.WarnContext(c.Request.Context(), "Failed to make object public", "object", filename, "error", err)
 	}
 
 	// Generate public URL
-	imageURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s",
-		ih.storageService.BucketName, filename)
+	imageURL := ih.storageService.PublicURL(filename)
 
 	// Update submission with image URL if it's a real submission
-	if submissionID != "" && submissionID[:5] != "temp_" {
-		err = ih.addImageToSubmission(submissionID, imageURL)
+	if !isTemp {
+		err = ih.addImageToSubmission(ctx, submissionID, imageURL)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error:   "internal_error",
-				Message: "Failed to update submission with image",
-			})
+			c.Error(apperrors.Internal("Failed to update submission with image", err))
 			return
 		}
 	}
@@ -141,11 +157,10 @@ func (ih *ImageHandler) UploadImage(c *gin.Context) {
 // @Success 308 {string} string "Redirects to the image URL"
 // @Router /images/{filename} [get]
 func (ih *ImageHandler) GetImage(c *gin.Context) {
-	filename := c.Param("filename")
+	filename := imageFilename(c)
 
 	// Redirect to Google Cloud Storage public URL
-	imageURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s",
-		ih.storageService.BucketName, filename)
+	imageURL := ih.storageService.PublicURL(filename)
 
 	c.Redirect(http.StatusPermanentRedirect, imageURL)
 }
@@ -155,36 +170,41 @@ func (ih *ImageHandler) GetImage(c *gin.Context) {
 // @Tags images
 // @Produce  json
 // @Security ApiKeyAuth
-// @Param filename path string true "Image filename"
+// @Param filename path string true "Image filename, <submissionID>/<name> as returned by the upload"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 403 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /images/{filename} [delete]
 func (ih *ImageHandler) DeleteImage(c *gin.Context) {
-	filename := c.Param("filename")
+	filename := imageFilename(c)
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
 
-	// Only admin or the owner can delete images
-	if user.Role != "admin" {
-		// TODO: Check if user owns the submission
-		// Extract submission ID from filename (first part before first underscore)
-		// This is a simplified check
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
-		return
+	ctx, cancel := ih.storageService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	// Images are stored under their submission's ID; owners may delete their own
+	if !user.Can(models.PermissionImageDeleteAll) {
+		submissionID, _, found := strings.Cut(filename, "/")
+		if !found || submissionID == "" {
+			c.Error(apperrors.Forbidden("forbidden", "Access denied"))
+			return
+		}
+		submission, err := ih.getSubmissionByID(ctx, submissionID)
+		if err != nil && status.Code(err) != codes.NotFound {
+			c.Error(apperrors.Internal("Failed to load submission", err))
+			return
+		}
+		if err != nil || !user.CanAccess(submission.UserID, models.PermissionImageDeleteOwn, models.PermissionImageDeleteAll) {
+			c.Error(apperrors.Forbidden("forbidden", "Access denied"))
+			return
+		}
 	}
 
-	ctx := ih.storageService.Context()
 	obj := ih.storageService.Bucket().Object(filename)
 
 	if err := obj.Delete(ctx); err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "delete_failed",
-			Message: "Failed to delete image",
-		})
+		c.Error(apperrors.Upstream("delete_failed", "Failed to delete image", err))
 		return
 	}
 
@@ -194,8 +214,13 @@ func (ih *ImageHandler) DeleteImage(c *gin.Context) {
 	})
 }
 
-func (ih *ImageHandler) addImageToSubmission(submissionID, imageURL string) error {
-	ctx := ih.firestoreService.Context()
+// imageFilename returns the object name matched by the route's catch-all
+// parameter, which keeps the leading slash
+func imageFilename(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("filename"), "/")
+}
+
+func (ih *ImageHandler) addImageToSubmission(ctx context.Context, submissionID, imageURL string) error {
 	docRef := ih.firestoreService.Submissions().Doc(submissionID)
 
 	return ih.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
@@ -205,7 +230,12 @@ func (ih *ImageHandler) addImageToSubmission(submissionID, imageURL string) erro
 		}
 
 		var submission models.Submission
-		doc.DataTo(&submission)
+		if err := doc.DataTo(&submission); err != nil {
+			return err
+		}
+		if submission.DeletedAt != nil {
+			return fmt.Errorf("submission %s is deleted", submissionID)
+		}
 
 		submission.Images = append(submission.Images, imageURL)
 		submission.UpdatedAt = time.Now()
@@ -213,3 +243,18 @@ func (ih *ImageHandler) addImageToSubmission(submissionID, imageURL string) erro
 		return tx.Set(docRef, submission)
 	})
 }
+
+func (ih *ImageHandler) getSubmissionByID(ctx context.Context, submissionID string) (*models.Submission, error) {
+	doc, err := ih.firestoreService.Submissions().Doc(submissionID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var submission models.Submission
+	err = doc.DataTo(&submission)
+	if err != nil {
+		return nil, err
+	}
+
+	return &submission, nil
+}
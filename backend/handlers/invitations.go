@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InvitationHandler struct {
+	firestoreService *services.FirestoreService
+	onboarding       *services.OnboardingService
+}
+
+func NewInvitationHandler(firestoreService *services.FirestoreService, onboarding *services.OnboardingService) *InvitationHandler {
+	return &InvitationHandler{
+		firestoreService: firestoreService,
+		onboarding:       onboarding,
+	}
+}
+
+// @Summary Get invitations
+// @Description List all invitations, newest first (admin only)
+// @Tags invitations
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse{data=[]models.Invitation}
+// @Failure 403 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /invitations [get]
+func (ih *InvitationHandler) GetInvitations(c *gin.Context) {
+	ctx, cancel := ih.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	invitations, err := ih.onboarding.ListInvitations(ctx)
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to retrieve invitations", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    invitations,
+	})
+}
+
+// @Summary Create invitation
+// @Description Invite an email address to sign up with a pre-assigned role, regardless of the
+// @Description allowed email domains. Inviting the same address again replaces its invitation.
+// @Tags invitations
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param   invitation  body  models.InvitationRequest  true  "Invitation"
+// @Success 201 {object} models.SuccessResponse{data=models.Invitation}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /invitations [post]
+func (ih *InvitationHandler) CreateInvitation(c *gin.Context) {
+	var req models.InvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+
+	ctx, cancel := ih.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	// Existing accounts are managed through their user record instead
+	docs, err := ih.firestoreService.Users().Where("email", "==", req.Email).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to create invitation", err))
+		return
+	}
+	if len(docs) > 0 {
+		c.Error(apperrors.Conflict("user_exists", "A user with this email already exists"))
+		return
+	}
+
+	currentUser, _ := c.Get("user")
+	currentUserObj := currentUser.(*models.User)
+
+	invitation, err := ih.onboarding.Invite(ctx, req.Email, req.Role, currentUserObj.ID)
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to create invitation", err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.SuccessResponse{
+		Success: true,
+		Data:    invitation,
+		Message: "Invitation created successfully",
+	})
+}
+
+// @Summary Revoke invitation
+// @Description Delete an invitation so it can no longer be used (admin only)
+// @Tags invitations
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "Invitation ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /invitations/{id} [delete]
+func (ih *InvitationHandler) DeleteInvitation(c *gin.Context) {
+	ctx, cancel := ih.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	err := ih.onboarding.RevokeInvitation(ctx, c.Param("id"))
+	if err == services.ErrInvitationNotFound {
+		c.Error(apperrors.NotFound("not_found", "Invitation not found"))
+		return
+	}
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to revoke invitation", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "Invitation revoked successfully",
+	})
+}
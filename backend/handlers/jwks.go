@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JWKSHandler struct {
+	keyProvider utils.KeyProvider
+}
+
+func NewJWKSHandler(keyProvider utils.KeyProvider) *JWKSHandler {
+	return &JWKSHandler{
+		keyProvider: keyProvider,
+	}
+}
+
+// @Summary JSON Web Key Set
+// @Description Public keys that verify tokens issued by this API, for use by other services
+// @Tags auth
+// @Produce  json
+// @Success 200 {object} models.JWKS
+// @Router /.well-known/jwks.json [get]
+func (jh *JWKSHandler) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, models.JWKS{
+		Keys: jh.keyProvider.PublicKeys(),
+	})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+)
+
+// Trend series bucket sizes
+const (
+	bucketDay   = "day"
+	bucketWeek  = "week"
+	bucketMonth = "month"
+)
+
+// maxSeriesBuckets bounds the points of one series, so a long period needs a
+// coarser bucket
+const maxSeriesBuckets = 400
+
+// bucketStart returns the first day of the bucket holding t; weeks start on
+// Monday
+func bucketStart(bucket string, t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch bucket {
+	case bucketWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case bucketMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+func nextBucket(bucket string, start time.Time) time.Time {
+	switch bucket {
+	case bucketWeek:
+		return start.AddDate(0, 0, 7)
+	case bucketMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// seriesBuckets lists the starts of every bucket from the one holding from to
+// the one holding to
+func seriesBuckets(bucket string, from, to time.Time) ([]time.Time, error) {
+	var starts []time.Time
+	for start := bucketStart(bucket, from); !start.After(to); start = nextBucket(bucket, start) {
+		if len(starts) == maxSeriesBuckets {
+			return nil, fmt.Errorf("period spans more than %d %ss; use a coarser bucket or a shorter period", maxSeriesBuckets, bucket)
+		}
+		starts = append(starts, start)
+	}
+	return starts, nil
+}
+
+// seriesSample accumulates what one bucket averages
+type seriesSample struct {
+	n   int
+	sum float64
+}
+
+// buildSeries turns per-bucket samples into gap-filled points with a
+// trailing moving average over up to window buckets that have a value
+func buildSeries(starts []time.Time, samples map[string]*seriesSample, window int) []models.TrendPoint {
+	points := make([]models.TrendPoint, 0, len(starts))
+	for _, start := range starts {
+		point := models.TrendPoint{Bucket: utils.FormatDate(start)}
+		if sample, ok := samples[point.Bucket]; ok && sample.n > 0 {
+			value := sample.sum / float64(sample.n)
+			point.N = sample.n
+			point.Value = &value
+		}
+		points = append(points, point)
+	}
+
+	for i := range points {
+		sum, count := 0.0, 0
+		for j := i; j >= 0 && j > i-window; j-- {
+			if points[j].Value != nil {
+				sum += *points[j].Value
+				count++
+			}
+		}
+		if count > 0 {
+			average := sum / float64(count)
+			points[i].MovingAverage = &average
+		}
+	}
+
+	return points
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"rice-monitor-api/middleware"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSeriesBuckets(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket string
+		from   string
+		to     string
+		want   []string
+	}{
+		{name: "days", bucket: bucketDay, from: "2026-02-27", to: "2026-03-01", want: []string{"2026-02-27", "2026-02-28", "2026-03-01"}},
+		{name: "weeks start on Monday", bucket: bucketWeek, from: "2026-10-14", to: "2026-10-26", want: []string{"2026-10-12", "2026-10-19", "2026-10-26"}},
+		{name: "Sunday belongs to the week before", bucket: bucketWeek, from: "2026-10-18", to: "2026-10-18", want: []string{"2026-10-12"}},
+		{name: "months", bucket: bucketMonth, from: "2026-01-31", to: "2026-03-01", want: []string{"2026-01-01", "2026-02-01", "2026-03-01"}},
+		{name: "too many buckets", bucket: bucketDay, from: "2020-01-01", to: "2026-01-01", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, _ := time.Parse("2006-01-02", tt.from)
+			to, _ := time.Parse("2006-01-02", tt.to)
+
+			starts, err := seriesBuckets(tt.bucket, from, to)
+			if (err != nil) != (tt.want == nil) {
+				t.Fatalf("got error %v, want error: %v", err, tt.want == nil)
+			}
+
+			var got []string
+			for _, start := range starts {
+				got = append(got, start.Format("2006-01-02"))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSeries(t *testing.T) {
+	starts := []time.Time{
+		time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 7, 2, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 7, 3, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 7, 4, 0, 0, 0, 0, time.UTC),
+	}
+	samples := map[string]*seriesSample{
+		"2026-07-01": {n: 2, sum: 20},
+		"2026-07-03": {n: 1, sum: 40},
+		"2026-07-04": {n: 0, sum: 0},
+	}
+
+	tests := []struct {
+		window  int
+		values  []interface{}
+		n       []int
+		average []interface{}
+	}{
+		{window: 1, values: []interface{}{10.0, nil, 40.0, nil}, n: []int{2, 0, 1, 0}, average: []interface{}{10.0, nil, 40.0, nil}},
+		{window: 2, values: []interface{}{10.0, nil, 40.0, nil}, n: []int{2, 0, 1, 0}, average: []interface{}{10.0, 10.0, 40.0, 40.0}},
+		{window: 3, values: []interface{}{10.0, nil, 40.0, nil}, n: []int{2, 0, 1, 0}, average: []interface{}{10.0, 10.0, 25.0, 40.0}},
+	}
+
+	value := func(v *float64) interface{} {
+		if v == nil {
+			return nil
+		}
+		return *v
+	}
+
+	for _, tt := range tests {
+		points := buildSeries(starts, samples, tt.window)
+		for i, point := range points {
+			if value(point.Value) != tt.values[i] || point.N != tt.n[i] || value(point.MovingAverage) != tt.average[i] {
+				t.Errorf("window %d, %s: got value %v, n %d, average %v; want %v, %d, %v",
+					tt.window, point.Bucket, value(point.Value), point.N, value(point.MovingAverage), tt.values[i], tt.n[i], tt.average[i])
+			}
+		}
+	}
+}
+
+func TestGetTrendSeries(t *testing.T) {
+	fs := newTestFirestore(t)
+	handler := NewAnalyticsHandler(fs, services.NewAggregateService(fs))
+	// Observers only see trends in their own submissions
+	user := &models.User{ID: "u1", Role: "observer"}
+
+	submissions := []models.Submission{
+		{ID: "s1", UserID: "u1", FieldID: "f1", Date: time.Date(2026, 7, 1, 8, 0, 0, 0, time.UTC), PlantConditions: []string{"pests"}, TraitMeasurements: models.TraitMeasurements{CulmLength: 80}},
+		{ID: "s2", UserID: "u1", FieldID: "f1", Date: time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC), PlantConditions: []string{"healthy"}, TraitMeasurements: models.TraitMeasurements{CulmLength: 100}},
+		{ID: "s3", UserID: "u1", FieldID: "f1", Date: time.Date(2026, 7, 3, 0, 0, 0, 0, time.UTC), PlantConditions: []string{"pests"}},
+		{ID: "s4", UserID: "u2", FieldID: "f1", Date: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), TraitMeasurements: models.TraitMeasurements{CulmLength: 500}},
+	}
+	deletedAt := time.Now()
+	submissions = append(submissions, models.Submission{ID: "s5", UserID: "u1", FieldID: "f1", Date: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), DeletedAt: &deletedAt, TraitMeasurements: models.TraitMeasurements{CulmLength: 500}})
+	for _, submission := range submissions {
+		if _, err := fs.Submissions().Doc(submission.ID).Set(context.Background(), submission); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	router.Use(middleware.Errors(), func(c *gin.Context) { c.Set("user", user) })
+	router.GET("/analytics/trends/series", handler.GetTrendSeries)
+
+	tests := []struct {
+		name   string
+		query  string
+		code   int
+		values []interface{}
+	}{
+		{name: "trait mean", query: "trait=culm_length&bucket=day&start_date=2026-07-01&end_date=2026-07-03", code: http.StatusOK, values: []interface{}{90.0, nil, nil}},
+		{name: "condition prevalence", query: "condition=pests&bucket=day&start_date=2026-07-01&end_date=2026-07-03", code: http.StatusOK, values: []interface{}{0.5, nil, 1.0}},
+		{name: "trait and condition", query: "trait=culm_length&condition=pests", code: http.StatusBadRequest},
+		{name: "neither", query: "bucket=day", code: http.StatusBadRequest},
+		{name: "unknown trait", query: "trait=height", code: http.StatusBadRequest},
+		{name: "unknown bucket", query: "trait=culm_length&bucket=year", code: http.StatusBadRequest},
+		{name: "zero window", query: "trait=culm_length&window=0", code: http.StatusBadRequest},
+		{name: "reversed period", query: "trait=culm_length&start_date=2026-07-03&end_date=2026-07-01", code: http.StatusBadRequest},
+		{name: "too many buckets", query: "trait=culm_length&bucket=day&start_date=2020-01-01&end_date=2026-01-01", code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveJSON(router, http.MethodGet, "/analytics/trends/series?"+tt.query, nil)
+			if recorder.Code != tt.code {
+				t.Fatalf("got status %d, body %s; want %d", recorder.Code, recorder.Body, tt.code)
+			}
+			if tt.values == nil {
+				return
+			}
+
+			var response struct {
+				Data models.TrendSeries `json:"data"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			var got []interface{}
+			for _, point := range response.Data.Points {
+				if point.Value == nil {
+					got = append(got, nil)
+				} else {
+					got = append(got, *point.Value)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.values) {
+				t.Errorf("got values %v, want %v", got, tt.values)
+			}
+		})
+	}
+}
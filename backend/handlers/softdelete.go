@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// softDeleteUpdates marks a document as deleted without removing it. The
+// purge job removes it permanently once the retention period has passed.
+func softDeleteUpdates(deletedBy string) []firestore.Update {
+	now := time.Now()
+	return []firestore.Update{
+		{Path: "deleted_at", Value: now},
+		{Path: "deleted_by", Value: deletedBy},
+		{Path: "updated_at", Value: now},
+	}
+}
+
+// restoreUpdates clears the soft-delete markers set by softDeleteUpdates and
+// cascadeDeleteUpdates
+func restoreUpdates() []firestore.Update {
+	return []firestore.Update{
+		{Path: "deleted_at", Value: firestore.Delete},
+		{Path: "deleted_by", Value: firestore.Delete},
+		{Path: "deleted_with", Value: firestore.Delete},
+		{Path: "updated_at", Value: time.Now()},
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+
+	"rice-monitor-api/models"
+)
+
+// stageDurationTolerance is the share of a stage's expected duration a field
+// may be early or late by and still be on schedule
+const stageDurationTolerance = 0.25
+
+// Stage span statuses
+const (
+	stageOnSchedule = "on_schedule"
+	stageEarly      = "early"
+	stageDelayed    = "delayed"
+	stageInProgress = "in_progress"
+	stageOverdue    = "overdue"
+	stageComplete   = "complete"
+)
+
+// buildStageTimeline follows a field through the growth stages over its
+// observations, as of the day asOf
+func buildStageTimeline(fieldID string, submissions []models.Submission, asOf time.Time) models.StageTimeline {
+	sort.SliceStable(submissions, func(i, j int) bool {
+		if !submissions[i].Date.Equal(submissions[j].Date) {
+			return submissions[i].Date.Before(submissions[j].Date)
+		}
+		return submissions[i].CreatedAt.Before(submissions[j].CreatedAt)
+	})
+
+	timeline := models.StageTimeline{
+		FieldID:      fieldID,
+		Stages:       []models.StageSpan{},
+		Regressions:  []models.StageRegression{},
+		Observations: len(submissions),
+	}
+
+	for _, submission := range submissions {
+		order := models.GrowthStageIndex(submission.GrowthStage)
+		if order < 0 {
+			timeline.Unrecognized++
+			continue
+		}
+		date := observationDay(submission.Date)
+
+		if len(timeline.Stages) > 0 {
+			current := &timeline.Stages[len(timeline.Stages)-1]
+			switch {
+			case order < current.Order:
+				timeline.Regressions = append(timeline.Regressions, models.StageRegression{
+					SubmissionID: submission.ID,
+					Date:         date,
+					Stage:        submission.GrowthStage,
+					ReachedStage: current.Stage,
+					ReachedOn:    current.FirstObserved,
+				})
+				continue
+			case order == current.Order:
+				current.LastObserved = date
+				current.Observations++
+				continue
+			}
+		}
+
+		timeline.Stages = append(timeline.Stages, models.StageSpan{
+			Stage:         submission.GrowthStage,
+			Order:         order,
+			FirstObserved: date,
+			LastObserved:  date,
+			Observations:  1,
+		})
+	}
+
+	for i := range timeline.Stages {
+		span := &timeline.Stages[i]
+
+		if i+1 < len(timeline.Stages) {
+			next := timeline.Stages[i+1]
+			span.DaysInStage = daysBetween(span.FirstObserved, next.FirstObserved)
+			for _, stage := range models.GrowthStages[span.Order:next.Order] {
+				span.ExpectedDays += stage.ExpectedDays
+			}
+			span.DaysOver = span.DaysInStage - span.ExpectedDays
+			span.Status = finishedStageStatus(span.DaysInStage, span.ExpectedDays)
+			continue
+		}
+
+		// The current stage runs until the as-of day
+		timeline.CurrentStage = span.Stage
+		span.DaysInStage = daysBetween(span.FirstObserved, asOf)
+		if span.Order == len(models.GrowthStages)-1 {
+			span.Status = stageComplete
+			continue
+		}
+		span.ExpectedDays = models.GrowthStages[span.Order].ExpectedDays
+		span.DaysOver = span.DaysInStage - span.ExpectedDays
+		span.Status = stageInProgress
+		if float64(span.DaysInStage) > float64(span.ExpectedDays)*(1+stageDurationTolerance) {
+			span.Status = stageOverdue
+		}
+	}
+
+	return timeline
+}
+
+func finishedStageStatus(days, expected int) string {
+	switch {
+	case float64(days) > float64(expected)*(1+stageDurationTolerance):
+		return stageDelayed
+	case float64(days) < float64(expected)*(1-stageDurationTolerance):
+		return stageEarly
+	default:
+		return stageOnSchedule
+	}
+}
+
+// observationDay returns the UTC day of an observation
+func observationDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// sortStages orders stage names by the growth-stage model, with names outside
+// it last in alphabetical order
+func sortStages(stages []string) {
+	sort.SliceStable(stages, func(i, j int) bool {
+		a, b := models.GrowthStageIndex(stages[i]), models.GrowthStageIndex(stages[j])
+		switch {
+		case a < 0 && b < 0:
+			return stages[i] < stages[j]
+		case a < 0 || b < 0:
+			return b < 0
+		default:
+			return a < b
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+)
+
+func TestBuildStageTimeline(t *testing.T) {
+	type span struct {
+		stage    string
+		days     int
+		expected int
+		status   string
+	}
+
+	tests := []struct {
+		name         string
+		submissions  []models.Submission
+		asOf         time.Time
+		want         []span
+		current      string
+		regressions  []string
+		unrecognized int
+	}{
+		{
+			name: "on schedule and in progress",
+			submissions: []models.Submission{
+				{ID: "s2", GrowthStage: "Tillering", Date: time.Date(2026, 6, 22, 9, 0, 0, 0, time.UTC)},
+				{ID: "s1", GrowthStage: "Seedling", Date: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)},
+				{ID: "s3", GrowthStage: "Tillering", Date: time.Date(2026, 6, 25, 0, 0, 0, 0, time.UTC)},
+			},
+			asOf: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC),
+			want: []span{
+				{stage: "Seedling", days: 21, expected: 21, status: stageOnSchedule},
+				{stage: "Tillering", days: 9, expected: 30, status: stageInProgress},
+			},
+			current: "Tillering",
+		},
+		{
+			name: "skipped stage is expected to take both durations",
+			submissions: []models.Submission{
+				{ID: "s1", GrowthStage: "Seedling", Date: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)},
+				{ID: "s2", GrowthStage: "Panicle Initiation", Date: time.Date(2026, 6, 20, 0, 0, 0, 0, time.UTC)},
+			},
+			asOf: time.Date(2026, 6, 20, 0, 0, 0, 0, time.UTC),
+			want: []span{
+				{stage: "Seedling", days: 19, expected: 51, status: stageEarly},
+				{stage: "Panicle Initiation", days: 0, expected: 25, status: stageInProgress},
+			},
+			current: "Panicle Initiation",
+		},
+		{
+			name: "regression and overdue stage",
+			submissions: []models.Submission{
+				{ID: "s1", GrowthStage: "Seedling", Date: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)},
+				{ID: "s2", GrowthStage: "Tillering", Date: time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)},
+				{ID: "s3", GrowthStage: "Seedling", Date: time.Date(2026, 7, 5, 0, 0, 0, 0, time.UTC)},
+			},
+			asOf: time.Date(2026, 8, 15, 0, 0, 0, 0, time.UTC),
+			want: []span{
+				{stage: "Seedling", days: 30, expected: 21, status: stageDelayed},
+				{stage: "Tillering", days: 45, expected: 30, status: stageOverdue},
+			},
+			current:     "Tillering",
+			regressions: []string{"s3"},
+		},
+		{
+			name: "same day observations in the order they were made",
+			submissions: []models.Submission{
+				{ID: "s2", GrowthStage: "Seedling", Date: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), CreatedAt: time.Date(2026, 6, 1, 10, 0, 0, 0, time.UTC)},
+				{ID: "s1", GrowthStage: "Tillering", Date: time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), CreatedAt: time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)},
+			},
+			asOf: time.Date(2026, 6, 2, 0, 0, 0, 0, time.UTC),
+			want: []span{
+				{stage: "Tillering", days: 1, expected: 30, status: stageInProgress},
+			},
+			current:     "Tillering",
+			regressions: []string{"s2"},
+		},
+		{
+			name: "harvest completes the timeline",
+			submissions: []models.Submission{
+				{ID: "s1", GrowthStage: "Maturity", Date: time.Date(2026, 8, 10, 0, 0, 0, 0, time.UTC)},
+				{ID: "s2", GrowthStage: "Harvested", Date: time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)},
+				{ID: "s3", GrowthStage: "ripening", Date: time.Date(2026, 9, 2, 0, 0, 0, 0, time.UTC)},
+			},
+			asOf: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+			want: []span{
+				{stage: "Maturity", days: 22, expected: 15, status: stageDelayed},
+				{stage: "Harvested", days: 30, expected: 0, status: stageComplete},
+			},
+			current:      "Harvested",
+			unrecognized: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeline := buildStageTimeline("f1", tt.submissions, tt.asOf)
+
+			var got []span
+			for _, s := range timeline.Stages {
+				got = append(got, span{stage: s.Stage, days: s.DaysInStage, expected: s.ExpectedDays, status: s.Status})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got stages %+v, want %+v", got, tt.want)
+			}
+
+			var regressions []string
+			for _, regression := range timeline.Regressions {
+				regressions = append(regressions, regression.SubmissionID)
+			}
+			if !reflect.DeepEqual(regressions, tt.regressions) {
+				t.Errorf("got regressions %v, want %v", regressions, tt.regressions)
+			}
+
+			if timeline.CurrentStage != tt.current || timeline.Unrecognized != tt.unrecognized || timeline.Observations != len(tt.submissions) {
+				t.Errorf("got current stage %q, %d unrecognized of %d observations; want %q, %d of %d",
+					timeline.CurrentStage, timeline.Unrecognized, timeline.Observations, tt.current, tt.unrecognized, len(tt.submissions))
+			}
+		})
+	}
+}
+
+func TestSortStages(t *testing.T) {
+	stages := []string{"unknown", "Flowering", "Seedling", "custom", "Harvested"}
+	sortStages(stages)
+
+	want := []string{"Seedling", "Flowering", "Harvested", "custom", "unknown"}
+	if !reflect.DeepEqual(stages, want) {
+		t.Errorf("got %v, want %v", stages, want)
+	}
+}
@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/metrics"
 	"rice-monitor-api/models"
 	"rice-monitor-api/services"
 	"rice-monitor-api/utils"
@@ -17,11 +23,13 @@ import (
 
 type SubmissionHandler struct {
 	firestoreService *services.FirestoreService
+	aggregateService *services.AggregateService
 }
 
-func NewSubmissionHandler(firestoreService *services.FirestoreService) *SubmissionHandler {
+func NewSubmissionHandler(firestoreService *services.FirestoreService, aggregateService *services.AggregateService) *SubmissionHandler {
 	return &SubmissionHandler{
 		firestoreService: firestoreService,
+		aggregateService: aggregateService,
 	}
 }
 
@@ -34,8 +42,9 @@ func NewSubmissionHandler(firestoreService *services.FirestoreService) *Submissi
 // @Param limit query int false "Number of items per page"
 // @Param status query string false "Filter by submission status"
 // @Param field_id query string false "Filter by field ID"
+// @Param deleted query bool false "List soft-deleted submissions instead"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 500 {object} models.Problem
 // @Router /submissions [get]
 func (sh *SubmissionHandler) GetSubmissions(c *gin.Context) {
 	currentUser, _ := c.Get("user")
@@ -46,12 +55,14 @@ func (sh *SubmissionHandler) GetSubmissions(c *gin.Context) {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	status := c.Query("status")
 	fieldID := c.Query("field_id")
+	showDeleted := c.Query("deleted") == "true"
 
-	ctx := sh.firestoreService.Context()
+	ctx, cancel := sh.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
 	query := sh.firestoreService.Submissions().Query
 
-	// Filter by user (non-admin users can only see their submissions)
-	if user.Role != "admin" {
+	// Filter by user unless they may see everyone's submissions
+	if !user.Can(models.PermissionSubmissionViewAll) {
 		query = query.Where("user_id", "==", user.ID)
 	}
 
@@ -66,27 +77,37 @@ func (sh *SubmissionHandler) GetSubmissions(c *gin.Context) {
 	// Order by creation date (newest first)
 	query = query.OrderBy("created_at", firestore.Desc)
 
-	// Apply pagination
-	if page > 1 {
-		query = query.Offset((page - 1) * limit)
-	}
-	query = query.Limit(limit)
+	// Apply pagination. Soft-deleted documents have no queryable marker on
+	// legacy records, so offset and limit are applied while streaming.
+	offset := (page - 1) * limit
+	skipped := 0
 
-	// Execute query
-	docs, err := query.Documents(ctx).GetAll()
-	if err != nil {
-		fmt.Println(err)
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve submissions",
-		})
-		return
-	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
 
 	var submissions []models.Submission
-	for _, doc := range docs {
+	for len(submissions) < limit {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			c.Error(apperrors.Internal("Failed to retrieve submissions", err))
+			return
+		}
+
 		var submission models.Submission
-		doc.DataTo(&submission)
+		if err := doc.DataTo(&submission); err != nil {
+			c.Error(apperrors.Internal("Failed to decode submission "+doc.Ref.ID, err))
+			return
+		}
+		if (submission.DeletedAt != nil) != showDeleted {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
 		submissions = append(submissions, submission)
 	}
 
@@ -109,16 +130,13 @@ func (sh *SubmissionHandler) GetSubmissions(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Param submission body models.CreateSubmissionRequest true "Submission object that needs to be added"
 // @Success 201 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /submissions [post]
 func (sh *SubmissionHandler) CreateSubmission(c *gin.Context) {
 	var req models.CreateSubmissionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: err.Error(),
-		})
+		c.Error(bindError(err))
 		return
 	}
 
@@ -142,15 +160,21 @@ func (sh *SubmissionHandler) CreateSubmission(c *gin.Context) {
 		UpdatedAt:         time.Now(),
 	}
 
-	ctx := sh.firestoreService.Context()
-	_, err := sh.firestoreService.Submissions().Doc(submission.ID).Set(ctx, submission)
+	ctx, cancel := sh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	err := sh.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Set(sh.firestoreService.Submissions().Doc(submission.ID), submission); err != nil {
+			return err
+		}
+		delta := services.NewAggregateDelta()
+		delta.Add(nil, submission)
+		return sh.aggregateService.Apply(tx, delta)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to create submission",
-		})
+		c.Error(apperrors.Internal("Failed to create submission", err))
 		return
 	}
+	metrics.SubmissionsCreated.WithLabelValues(submission.Status).Inc()
 
 	c.JSON(http.StatusCreated, models.SuccessResponse{
 		Success: true,
@@ -166,33 +190,30 @@ func (sh *SubmissionHandler) CreateSubmission(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Param id path string true "Submission ID"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
 // @Router /submissions/{id} [get]
 func (sh *SubmissionHandler) GetSubmission(c *gin.Context) {
 	submissionID := c.Param("id")
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
 
-	ctx := sh.firestoreService.Context()
-	doc, err := sh.firestoreService.Submissions().Doc(submissionID).Get(ctx)
+	ctx, cancel := sh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	submission, err := sh.getSubmissionByID(ctx, submissionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "not_found",
-			Message: "Submission not found",
-		})
+		c.Error(apperrors.Lookup(err, "Submission"))
 		return
 	}
 
-	var submission models.Submission
-	doc.DataTo(&submission)
+	if submission.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "Submission not found"))
+		return
+	}
 
 	// Check if user can access this submission
-	if user.Role != "admin" && submission.UserID != user.ID {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
+	if submission.UserID != user.ID && !user.Can(models.PermissionSubmissionViewAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
 		return
 	}
 
@@ -211,10 +232,10 @@ func (sh *SubmissionHandler) GetSubmission(c *gin.Context) {
 // @Param id path string true "Submission ID"
 // @Param submission body object true "Submission object that needs to be updated"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /submissions/{id} [put]
 func (sh *SubmissionHandler) UpdateSubmission(c *gin.Context) {
 	submissionID := c.Param("id")
@@ -223,34 +244,40 @@ func (sh *SubmissionHandler) UpdateSubmission(c *gin.Context) {
 
 	var updateData map[string]interface{}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: err.Error(),
-		})
+		c.Error(bindError(err))
 		return
 	}
 
-	ctx := sh.firestoreService.Context()
+	ctx, cancel := sh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
 
 	// Get existing submission
-	doc, err := sh.firestoreService.Submissions().Doc(submissionID).Get(ctx)
+	submission, err := sh.getSubmissionByID(ctx, submissionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "not_found",
-			Message: "Submission not found",
-		})
+		c.Error(apperrors.Lookup(err, "Submission"))
 		return
 	}
 
-	var submission models.Submission
-	doc.DataTo(&submission)
+	if submission.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "Submission not found"))
+		return
+	}
+
+	// Changing the review status needs the approve permission; reviewers may
+	// do that on any submission they can see without being able to edit it
+	newStatus, changesStatus := updateData["status"]
+	changesStatus = changesStatus && newStatus != submission.Status
+	if changesStatus && !user.Can(models.PermissionSubmissionApprove) {
+		c.Error(apperrors.Forbidden("forbidden", "Only reviewers can change the status of a submission"))
+		return
+	}
+
+	reviewOnly := changesStatus && len(updateData) == 1 &&
+		(submission.UserID == user.ID || user.Can(models.PermissionSubmissionViewAll))
 
 	// Check permissions
-	if user.Role != "admin" && submission.UserID != user.ID {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
+	if !reviewOnly && !user.CanAccess(submission.UserID, models.PermissionSubmissionEditOwn, models.PermissionSubmissionEditAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
 		return
 	}
 
@@ -258,35 +285,36 @@ func (sh *SubmissionHandler) UpdateSubmission(c *gin.Context) {
 	delete(updateData, "id")
 	delete(updateData, "user_id")
 	delete(updateData, "created_at")
+	delete(updateData, "deleted_at")
+	delete(updateData, "deleted_by")
+	delete(updateData, "deleted_with")
 	updateData["updated_at"] = time.Now()
 
 	// Update document
-	updates := []firestore.Update{{Path: "updated_at", Value: time.Now()}}
+	updates := make([]firestore.Update, 0, len(updateData))
 	for key, value := range updateData {
 		updates = append(updates, firestore.Update{Path: key, Value: value})
 	}
 
-	_, err = sh.firestoreService.Submissions().Doc(submissionID).Update(ctx, updates)
+	err = sh.updateSubmission(ctx, submissionID, updates, func(before models.Submission) (models.Submission, error) {
+		return applySubmissionUpdates(before, updateData)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to update submission",
-		})
+		var appErr *apperrors.Error
+		if !errors.As(err, &appErr) {
+			appErr = apperrors.Internal("Failed to update submission", err)
+		}
+		c.Error(appErr)
 		return
 	}
 
 	// Get updated submission
-	doc, err = sh.firestoreService.Submissions().Doc(submissionID).Get(ctx)
+	submission, err = sh.getSubmissionByID(ctx, submissionID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve updated submission",
-		})
+		c.Error(apperrors.Internal("Failed to retrieve updated submission", err))
 		return
 	}
 
-	doc.DataTo(&submission)
-
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
 		Data:    submission,
@@ -295,52 +323,48 @@ func (sh *SubmissionHandler) UpdateSubmission(c *gin.Context) {
 }
 
 // @Summary Delete a submission
-// @Description Delete a submission by its ID
+// @Description Soft-delete a submission by its ID; it can be restored until the retention period expires
 // @Tags submissions
 // @Produce  json
 // @Security ApiKeyAuth
 // @Param id path string true "Submission ID"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /submissions/{id} [delete]
 func (sh *SubmissionHandler) DeleteSubmission(c *gin.Context) {
 	submissionID := c.Param("id")
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
 
-	ctx := sh.firestoreService.Context()
+	ctx, cancel := sh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
 
 	// Get existing submission
-	doc, err := sh.firestoreService.Submissions().Doc(submissionID).Get(ctx)
+	submission, err := sh.getSubmissionByID(ctx, submissionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "not_found",
-			Message: "Submission not found",
-		})
+		c.Error(apperrors.Lookup(err, "Submission"))
 		return
 	}
 
-	var submission models.Submission
-	doc.DataTo(&submission)
+	if submission.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "Submission not found"))
+		return
+	}
 
 	// Check permissions
-	if user.Role != "admin" && submission.UserID != user.ID {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
+	if !user.CanAccess(submission.UserID, models.PermissionSubmissionDeleteOwn, models.PermissionSubmissionDeleteAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
 		return
 	}
 
-	// Delete submission
-	_, err = sh.firestoreService.Submissions().Doc(submissionID).Delete(ctx)
+	// Soft-delete submission
+	err = sh.updateSubmission(ctx, submissionID, softDeleteUpdates(user.ID), func(before models.Submission) (models.Submission, error) {
+		return softDeletedSubmission(before), nil
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to delete submission",
-		})
+		c.Error(apperrors.Internal("Failed to delete submission", err))
 		return
 	}
 
@@ -350,23 +374,79 @@ func (sh *SubmissionHandler) DeleteSubmission(c *gin.Context) {
 	})
 }
 
+// @Summary Restore a submission
+// @Description Restore a soft-deleted submission
+// @Tags submissions
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "Submission ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /submissions/{id}/restore [post]
+func (sh *SubmissionHandler) RestoreSubmission(c *gin.Context) {
+	submissionID := c.Param("id")
+	currentUser, _ := c.Get("user")
+	user := currentUser.(*models.User)
+
+	ctx, cancel := sh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	submission, err := sh.getSubmissionByID(ctx, submissionID)
+	if err != nil {
+		c.Error(apperrors.Lookup(err, "Submission"))
+		return
+	}
+
+	// Check permissions
+	if !user.CanAccess(submission.UserID, models.PermissionSubmissionDeleteOwn, models.PermissionSubmissionDeleteAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
+		return
+	}
+
+	if submission.DeletedAt == nil {
+		c.Error(apperrors.Validation("invalid_request", "Submission is not deleted"))
+		return
+	}
+
+	err = sh.updateSubmission(ctx, submissionID, restoreUpdates(), func(before models.Submission) (models.Submission, error) {
+		before.DeletedAt = nil
+		return before, nil
+	})
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to restore submission", err))
+		return
+	}
+
+	submission.DeletedAt = nil
+	submission.DeletedBy = ""
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    submission,
+		Message: "Submission restored successfully",
+	})
+}
+
 // @Summary Export submissions to CSV
 // @Description Export submissions to a CSV file
 // @Tags submissions
 // @Produce  text/csv
 // @Security ApiKeyAuth
 // @Success 200 {string} string "CSV content"
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 500 {object} models.Problem
 // @Router /submissions/export [get]
 func (sh *SubmissionHandler) ExportSubmissions(c *gin.Context) {
 	currentUser, _ := c.Get("user")
 	user := currentUser.(*models.User)
 
-	ctx := sh.firestoreService.Context()
+	ctx, cancel := sh.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
 	query := sh.firestoreService.Submissions().Query
 
-	// Filter by user (non-admin users can only export their submissions)
-	if user.Role != "admin" {
+	// Filter by user unless they may see everyone's submissions
+	if !user.Can(models.PermissionSubmissionViewAll) {
 		query = query.Where("user_id", "==", user.ID)
 	}
 
@@ -380,15 +460,18 @@ func (sh *SubmissionHandler) ExportSubmissions(c *gin.Context) {
 			break
 		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-				Error:   "internal_error",
-				Message: "Failed to retrieve submissions",
-			})
+			c.Error(apperrors.Internal("Failed to retrieve submissions", err))
 			return
 		}
 
 		var submission models.Submission
-		doc.DataTo(&submission)
+		if err := doc.DataTo(&submission); err != nil {
+			c.Error(apperrors.Internal("Failed to decode submission "+doc.Ref.ID, err))
+			return
+		}
+		if submission.DeletedAt != nil {
+			continue
+		}
 		submissions = append(submissions, submission)
 	}
 
@@ -405,3 +488,89 @@ func (sh *SubmissionHandler) ExportSubmissions(c *gin.Context) {
 
 	c.String(http.StatusOK, csvContent)
 }
+
+func (sh *SubmissionHandler) getSubmissionByID(ctx context.Context, submissionID string) (*models.Submission, error) {
+	doc, err := sh.firestoreService.Submissions().Doc(submissionID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var submission models.Submission
+	err = doc.DataTo(&submission)
+	if err != nil {
+		return nil, err
+	}
+
+	return &submission, nil
+}
+
+// updateSubmission applies updates to a submission and moves its counts in
+// the analytics aggregates, in one transaction. change returns the submission
+// as the updates leave it.
+func (sh *SubmissionHandler) updateSubmission(ctx context.Context, submissionID string, updates []firestore.Update, change func(models.Submission) (models.Submission, error)) error {
+	ref := sh.firestoreService.Submissions().Doc(submissionID)
+
+	return sh.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		var before models.Submission
+		if err := doc.DataTo(&before); err != nil {
+			return err
+		}
+		after, err := change(before)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Update(ref, updates); err != nil {
+			return err
+		}
+		delta := services.NewAggregateDelta()
+		delta.Add(&before, &after)
+		return sh.aggregateService.Apply(tx, delta)
+	})
+}
+
+// applySubmissionUpdates returns submission with the values of a partial
+// update applied. Dotted keys update nested fields, as they do in Firestore.
+func applySubmissionUpdates(submission models.Submission, updateData map[string]interface{}) (models.Submission, error) {
+	current, err := json.Marshal(submission)
+	if err != nil {
+		return submission, err
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(current, &data); err != nil {
+		return submission, err
+	}
+
+	for key, value := range updateData {
+		path := strings.Split(key, ".")
+		node := data
+		for _, name := range path[:len(path)-1] {
+			child, ok := node[name].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[name] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = value
+	}
+
+	updated, err := json.Marshal(data)
+	if err != nil {
+		return submission, err
+	}
+	var result models.Submission
+	if err := json.Unmarshal(updated, &result); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return submission, bindError(err)
+		}
+		return submission, apperrors.Validation("invalid_request", "Update has values that are not valid for a submission")
+	}
+	return result, nil
+}
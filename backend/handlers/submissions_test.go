@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"rice-monitor-api/middleware"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSubmissionChangesMoveAggregates(t *testing.T) {
+	fs := newTestFirestore(t)
+	aggregateService := services.NewAggregateService(fs)
+	handler := NewSubmissionHandler(fs, aggregateService)
+	admin := &models.User{ID: "admin", Role: "admin"}
+
+	router := gin.New()
+	router.Use(middleware.Errors(), func(c *gin.Context) { c.Set("user", admin) })
+	router.POST("/submissions", handler.CreateSubmission)
+	router.PUT("/submissions/:id", handler.UpdateSubmission)
+	router.DELETE("/submissions/:id", handler.DeleteSubmission)
+	router.POST("/submissions/:id/restore", handler.RestoreSubmission)
+
+	recorder := serveJSON(router, http.MethodPost, "/submissions", models.CreateSubmissionRequest{
+		FieldID:         "f1",
+		Date:            time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC),
+		Location:        "North plot",
+		GrowthStage:     "tillering",
+		PlantConditions: []string{"healthy"},
+		ObserverName:    "Ana",
+	})
+	var created struct {
+		Data models.Submission `json:"data"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &created)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("creating submission: status %d, body %s", recorder.Code, recorder.Body)
+	}
+	path := "/submissions/" + created.Data.ID
+
+	steps := []struct {
+		name     string
+		method   string
+		path     string
+		body     interface{}
+		total    int
+		byStatus map[string]int
+		byStage  map[string]int
+	}{
+		{
+			name: "status change", method: http.MethodPut, path: path,
+			body:  map[string]interface{}{"status": "approved"},
+			total: 1, byStatus: map[string]int{"submitted": 0, "approved": 1}, byStage: map[string]int{"tillering": 1},
+		},
+		{
+			name: "stage change", method: http.MethodPut, path: path,
+			body:  map[string]interface{}{"growth_stage": "heading"},
+			total: 1, byStatus: map[string]int{"approved": 1}, byStage: map[string]int{"tillering": 0, "heading": 1},
+		},
+		{
+			name: "soft delete", method: http.MethodDelete, path: path,
+			total: 0, byStatus: map[string]int{"approved": 0}, byStage: map[string]int{"heading": 0},
+		},
+		{
+			name: "restore", method: http.MethodPost, path: path + "/restore",
+			total: 1, byStatus: map[string]int{"approved": 1}, byStage: map[string]int{"heading": 1},
+		},
+	}
+
+	for _, step := range steps {
+		recorder := serveJSON(router, step.method, step.path, step.body)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", step.name, recorder.Code, recorder.Body)
+		}
+
+		for _, scope := range []string{services.AggregateScopeAll, services.AggregateScopeUser(admin.ID)} {
+			aggregate, err := aggregateService.Total(context.Background(), scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if aggregate.Total != step.total || aggregate.ByField["f1"].Total != step.total {
+				t.Errorf("%s: %s total is %d (field %d), want %d", step.name, scope, aggregate.Total, aggregate.ByField["f1"].Total, step.total)
+			}
+			for status, want := range step.byStatus {
+				if got := aggregate.ByStatus[status]; got != want {
+					t.Errorf("%s: %s has %d %s submissions, want %d", step.name, scope, got, status, want)
+				}
+			}
+			for stage, want := range step.byStage {
+				if got := aggregate.ByStage[stage]; got != want {
+					t.Errorf("%s: %s has %d submissions at %s, want %d", step.name, scope, got, stage, want)
+				}
+			}
+		}
+	}
+}
@@ -1,26 +1,159 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"rice-monitor-api/apperrors"
 	"rice-monitor-api/models"
 	"rice-monitor-api/services"
 
 	"cloud.google.com/go/firestore"
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+var (
+	errUserNotFound = errors.New("user not found")
+	errLastAdmin    = errors.New("cannot remove the last active admin")
+)
+
+// userListBatchSize is how many users GetUsers reads per query while filling
+// a page
+const userListBatchSize = 100
+
 type UserHandler struct {
 	firestoreService *services.FirestoreService
+	tokenService     *services.TokenService
+	aggregateService *services.AggregateService
 }
 
-func NewUserHandler(firestoreService *services.FirestoreService) *UserHandler {
+func NewUserHandler(firestoreService *services.FirestoreService, tokenService *services.TokenService, aggregateService *services.AggregateService) *UserHandler {
 	return &UserHandler{
 		firestoreService: firestoreService,
+		tokenService:     tokenService,
+		aggregateService: aggregateService,
 	}
 }
 
+// @Summary Get users
+// @Description List users, newest first (admin only). Search matches email and name.
+// @Tags users
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param page query int false "Page number"
+// @Param limit query int false "Number of items per page"
+// @Param search query string false "Case-insensitive email or name search"
+// @Param role query string false "Filter by role"
+// @Param status query string false "Filter by status (pending, approved, suspended)"
+// @Param inactive_days query int false "Only users who have not logged in for this many days"
+// @Param deleted query bool false "List soft-deleted users instead"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 403 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users [get]
+func (uh *UserHandler) GetUsers(c *gin.Context) {
+	// Parse query parameters
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	search := strings.ToLower(strings.TrimSpace(c.Query("search")))
+	role := c.Query("role")
+	status := c.Query("status")
+	inactiveDays, _ := strconv.Atoi(c.Query("inactive_days"))
+	showDeleted := c.Query("deleted") == "true"
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 {
+		limit = 20
+	}
+
+	ctx, cancel := uh.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
+	query := uh.firestoreService.Users().Query
+	if role != "" {
+		query = query.Where("role", "==", role)
+	}
+	query = query.OrderBy("created_at", firestore.Desc)
+
+	// Search, status and deletion can't be queried on legacy records, so the
+	// remaining filters are applied to batches read with a cursor, and offset
+	// and limit are applied to the matches until the page is full
+	var inactiveSince time.Time
+	if inactiveDays > 0 {
+		inactiveSince = time.Now().AddDate(0, 0, -inactiveDays)
+	}
+
+	offset := (page - 1) * limit
+	skipped := 0
+
+	users := []models.User{}
+	var last *firestore.DocumentSnapshot
+	for len(users) < limit {
+		batch := query.Limit(userListBatchSize)
+		if last != nil {
+			batch = batch.StartAfter(last)
+		}
+		docs, err := batch.Documents(ctx).GetAll()
+		if err != nil {
+			c.Error(apperrors.Internal("Failed to retrieve users", err))
+			return
+		}
+
+		for _, doc := range docs {
+			var user models.User
+			if err := doc.DataTo(&user); err != nil {
+				c.Error(apperrors.Internal("Failed to decode user "+doc.Ref.ID, err))
+				return
+			}
+
+			if (user.DeletedAt != nil) != showDeleted {
+				continue
+			}
+			if status != "" && userStatus(&user) != status {
+				continue
+			}
+			if search != "" && !strings.Contains(strings.ToLower(user.Email), search) &&
+				!strings.Contains(strings.ToLower(user.Name), search) {
+				continue
+			}
+			if inactiveDays > 0 && user.LastLoginAt.After(inactiveSince) {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			if users = append(users, user); len(users) == limit {
+				break
+			}
+		}
+
+		if len(docs) < userListBatchSize {
+			break
+		}
+		last = docs[len(docs)-1]
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"users": users,
+			"page":  page,
+			"limit": limit,
+			"total": len(users),
+		},
+	})
+}
+
 // @Summary Get user by ID
 // @Description Get a single user by their ID
 // @Tags users
@@ -28,8 +161,8 @@ func NewUserHandler(firestoreService *services.FirestoreService) *UserHandler {
 // @Security ApiKeyAuth
 // @Param id path string true "User ID"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 404 {object} models.ErrorResponse
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
 // @Router /users/{id} [get]
 func (uh *UserHandler) GetUser(c *gin.Context) {
 	userID := c.Param("id")
@@ -37,20 +170,21 @@ func (uh *UserHandler) GetUser(c *gin.Context) {
 	currentUserObj := currentUser.(*models.User)
 
 	// Check if user can access this user's data
-	if currentUserObj.ID != userID && currentUserObj.Role != "admin" {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
+	if currentUserObj.ID != userID && !currentUserObj.Can(models.PermissionUserViewAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
 		return
 	}
 
-	user, err := uh.getUserByID(userID)
+	ctx, cancel := uh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	user, err := uh.getUserByID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error:   "not_found",
-			Message: "User not found",
-		})
+		c.Error(apperrors.Lookup(err, "User"))
+		return
+	}
+	if user.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "User not found"))
 		return
 	}
 
@@ -69,9 +203,9 @@ func (uh *UserHandler) GetUser(c *gin.Context) {
 // @Param id path string true "User ID"
 // @Param user body object true "User object that needs to be updated"
 // @Success 200 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /users/{id} [put]
 func (uh *UserHandler) UpdateUser(c *gin.Context) {
 	userID := c.Param("id")
@@ -79,20 +213,14 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 	currentUserObj := currentUser.(*models.User)
 
 	// Check if user can update this user's data
-	if currentUserObj.ID != userID && currentUserObj.Role != "admin" {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Access denied",
-		})
+	if currentUserObj.ID != userID && !currentUserObj.Can(models.PermissionUserManage) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
 		return
 	}
 
 	var updateData map[string]interface{}
 	if err := c.ShouldBindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: err.Error(),
-		})
+		c.Error(bindError(err))
 		return
 	}
 
@@ -100,37 +228,41 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 	delete(updateData, "id")
 	delete(updateData, "email")
 	delete(updateData, "created_at")
+	delete(updateData, "deleted_at")
+	delete(updateData, "deleted_by")
+	delete(updateData, "status") // changed through PUT /users/:id/status
+	delete(updateData, "role")   // changed through PUT /users/:id/role
 	updateData["updated_at"] = time.Now()
 
-	// Only admin can change role
-	if currentUserObj.Role != "admin" {
-		delete(updateData, "role")
-	}
+	ctx, cancel := uh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
 
-	ctx := uh.firestoreService.Context()
+	existing, err := uh.getUserByID(ctx, userID)
+	if err != nil {
+		c.Error(apperrors.Lookup(err, "User"))
+		return
+	}
+	if existing.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "User not found"))
+		return
+	}
 
 	// Update document
-	updates := []firestore.Update{{Path: "updated_at", Value: time.Now()}}
+	updates := make([]firestore.Update, 0, len(updateData))
 	for key, value := range updateData {
 		updates = append(updates, firestore.Update{Path: key, Value: value})
 	}
 
-	_, err := uh.firestoreService.Users().Doc(userID).Update(ctx, updates)
+	_, err = uh.firestoreService.Users().Doc(userID).Update(ctx, updates)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to update user",
-		})
+		c.Error(apperrors.Internal("Failed to update user", err))
 		return
 	}
 
 	// Get updated user
-	user, err := uh.getUserByID(userID)
+	user, err := uh.getUserByID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to retrieve updated user",
-		})
+		c.Error(apperrors.Internal("Failed to retrieve updated user", err))
 		return
 	}
 
@@ -142,58 +274,451 @@ func (uh *UserHandler) UpdateUser(c *gin.Context) {
 }
 
 // @Summary Delete user
-// @Description Delete a user by their ID
+// @Description Soft-delete a user by their ID; deleted users can no longer sign in.
+// @Description Deletion is refused while the user owns fields or submissions unless they are
+// @Description cascaded (cascade=submissions,fields) or reassigned to another user (reassign_to).
 // @Tags users
 // @Produce  json
 // @Security ApiKeyAuth
 // @Param id path string true "User ID"
-// @Success 200 {object} models.SuccessResponse
-// @Failure 400 {object} models.ErrorResponse
-// @Failure 403 {object} models.ErrorResponse
-// @Failure 500 {object} models.ErrorResponse
+// @Param cascade query string false "Also soft-delete dependents (submissions, fields)"
+// @Param reassign_to query string false "Transfer dependents to this user ID"
+// @Success 200 {object} models.SuccessResponse{data=models.DeleteSummary}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
 // @Router /users/{id} [delete]
 func (uh *UserHandler) DeleteUser(c *gin.Context) {
 	userID := c.Param("id")
 	currentUser, _ := c.Get("user")
 	currentUserObj := currentUser.(*models.User)
 
-	// Only admin can delete users
-	if currentUserObj.Role != "admin" {
-		c.JSON(http.StatusForbidden, models.ErrorResponse{
-			Error:   "forbidden",
-			Message: "Only administrators can delete users",
-		})
+	// Prevent admin from deleting themselves
+	if currentUserObj.ID == userID {
+		c.Error(apperrors.Validation("invalid_request", "Cannot delete your own account"))
 		return
 	}
 
-	// Prevent admin from deleting themselves
-	if currentUserObj.ID == userID {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error:   "invalid_request",
-			Message: "Cannot delete your own account",
-		})
+	ctx, cancel := uh.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
+
+	user, err := uh.getUserByID(ctx, userID)
+	if err != nil {
+		c.Error(apperrors.Lookup(err, "User"))
+		return
+	}
+	if user.DeletedAt != nil {
+		c.Error(apperrors.NotFound("not_found", "User not found"))
 		return
 	}
 
-	ctx := uh.firestoreService.Context()
-	_, err := uh.firestoreService.Users().Doc(userID).Delete(ctx)
+	policy, err := parseDeletePolicy(c, "submissions", "fields")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error:   "internal_error",
-			Message: "Failed to delete user",
-		})
+		c.Error(apperrors.Validation("invalid_request", err.Error()))
+		return
+	}
+
+	submissions, err := activeDocuments(ctx, uh.firestoreService.Submissions().Where("user_id", "==", userID))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to check user submissions", err))
+		return
+	}
+
+	fields, err := activeDocuments(ctx, uh.firestoreService.Fields().Where("owner_id", "==", userID))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to check user fields", err))
+		return
+	}
+
+	summary := models.DeleteSummary{Policy: policy.name()}
+
+	// Dependents go first so a failure leaves the user in place for a retry
+	if policy.reassignTo != "" {
+		target, err := uh.getUserByID(ctx, policy.reassignTo)
+		if err != nil || target.DeletedAt != nil || target.ID == userID {
+			c.Error(apperrors.Validation("invalid_request", "reassign_to must be another existing user"))
+			return
+		}
+
+		summary.ReassignedTo = target.ID
+		summary.Images = countImages(submissions)
+
+		reassign := func(submission models.Submission) models.Submission {
+			submission.UserID = target.ID
+			return submission
+		}
+		summary.Submissions, err = updateInBatches(ctx, uh.firestoreService, submissions, []firestore.Update{
+			{Path: "user_id", Value: target.ID},
+			{Path: "updated_at", Value: time.Now()},
+		}, submissionAggregatesHook(uh.aggregateService, reassign))
+		if err == nil {
+			summary.Fields, err = updateInBatches(ctx, uh.firestoreService, fields, []firestore.Update{
+				{Path: "owner_id", Value: target.ID},
+				{Path: "updated_at", Value: time.Now()},
+			}, nil)
+		}
+		if err != nil {
+			c.Error(apperrors.Internal("Failed to reassign user records", err))
+			return
+		}
+	} else {
+		// Submissions made by others on the user's fields go with the fields
+		var fieldSubmissions []*firestore.DocumentSnapshot
+		for _, field := range fields {
+			docs, err := activeDocuments(ctx, uh.firestoreService.Submissions().Where("field_id", "==", field.Ref.ID))
+			if err != nil {
+				c.Error(apperrors.Internal("Failed to check field submissions", err))
+				return
+			}
+			fieldSubmissions = append(fieldSubmissions, docs...)
+		}
+		allSubmissions := uniqueDocuments(submissions, fieldSubmissions)
+
+		var blocking []string
+		if len(allSubmissions) > 0 && !policy.cascades("submissions") {
+			blocking = append(blocking, fmt.Sprintf("%d submissions", len(allSubmissions)))
+		}
+		if len(fields) > 0 && !policy.cascades("fields") {
+			blocking = append(blocking, fmt.Sprintf("%d fields", len(fields)))
+		}
+		if len(blocking) > 0 {
+			c.Error(apperrors.Conflict("has_dependents", fmt.Sprintf("User has %s; pass cascade=submissions,fields or reassign_to=<userID>", strings.Join(blocking, " and "))))
+			return
+		}
+
+		summary.Images = countImages(allSubmissions)
+
+		marker := cascadeMarker(uh.firestoreService.Users().Doc(userID))
+		summary.Submissions, err = updateInBatches(ctx, uh.firestoreService, allSubmissions, cascadeDeleteUpdates(currentUserObj.ID, marker),
+			submissionAggregatesHook(uh.aggregateService, softDeletedSubmission))
+		if err == nil {
+			summary.Fields, err = updateInBatches(ctx, uh.firestoreService, fields, cascadeDeleteUpdates(currentUserObj.ID, marker), nil)
+		}
+		if err != nil {
+			c.Error(apperrors.Internal("Failed to delete user records", err))
+			return
+		}
+	}
+
+	// Credentials go before the user too, so restoring the user later does
+	// not bring back old sessions and API keys
+	if err := uh.tokenService.RevokeAllForUser(ctx, userID); err != nil {
+		c.Error(apperrors.Internal("Failed to revoke user sessions", err))
+		return
+	}
+	if err := uh.tokenService.RevokeAPIKeysForUser(ctx, userID); err != nil {
+		c.Error(apperrors.Internal("Failed to revoke user API keys", err))
+		return
+	}
+
+	_, err = uh.firestoreService.Users().Doc(userID).Update(ctx, softDeleteUpdates(currentUserObj.ID))
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to delete user", err))
 		return
 	}
 
 	c.JSON(http.StatusOK, models.SuccessResponse{
 		Success: true,
+		Data:    summary,
 		Message: "User deleted successfully",
 	})
 }
 
+// @Summary Restore user
+// @Description Restore a soft-deleted user, with the fields and submissions its delete cascaded to
+// @Tags users
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users/{id}/restore [post]
+func (uh *UserHandler) RestoreUser(c *gin.Context) {
+	userID := c.Param("id")
+
+	ctx, cancel := uh.firestoreService.WithScanTimeout(c.Request.Context())
+	defer cancel()
+
+	user, err := uh.getUserByID(ctx, userID)
+	if err != nil {
+		c.Error(apperrors.Lookup(err, "User"))
+		return
+	}
+
+	if user.DeletedAt == nil {
+		c.Error(apperrors.Validation("invalid_request", "User is not deleted"))
+		return
+	}
+
+	// Records deleted with the user come back with it, first so a failure
+	// leaves the user deleted for a retry
+	userRef := uh.firestoreService.Users().Doc(userID)
+	marker := cascadeMarker(userRef)
+	_, err = restoreCascaded(ctx, uh.firestoreService, uh.firestoreService.Fields(), marker, nil)
+	if err == nil {
+		_, err = restoreCascaded(ctx, uh.firestoreService, uh.firestoreService.Submissions(), marker,
+			submissionAggregatesHook(uh.aggregateService, restoredSubmission))
+	}
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to restore user records", err))
+		return
+	}
+
+	_, err = userRef.Update(ctx, restoreUpdates())
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to restore user", err))
+		return
+	}
+
+	user.DeletedAt = nil
+	user.DeletedBy = ""
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    user,
+		Message: "User restored successfully",
+	})
+}
+
+// @Summary Update user status
+// @Description Approve, suspend or return a user to pending (admin only). Suspending a user
+// @Description also logs them out of every device.
+// @Tags users
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param status body models.UserStatusRequest true "New status"
+// @Success 200 {object} models.SuccessResponse{data=models.User}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users/{id}/status [put]
+func (uh *UserHandler) UpdateUserStatus(c *gin.Context) {
+	userID := c.Param("id")
+	currentUser, _ := c.Get("user")
+	currentUserObj := currentUser.(*models.User)
+
+	var req models.UserStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+
+	uh.setUserStatus(c, currentUserObj, userID, req.Status)
+}
+
+// @Summary Suspend user
+// @Description Suspend a user and log them out of every device (admin only)
+// @Tags users
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.SuccessResponse{data=models.User}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users/{id}/suspend [post]
+func (uh *UserHandler) SuspendUser(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	uh.setUserStatus(c, currentUser.(*models.User), c.Param("id"), models.UserStatusSuspended)
+}
+
+// @Summary Reactivate user
+// @Description Approve a suspended or pending user (admin only)
+// @Tags users
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.SuccessResponse{data=models.User}
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users/{id}/reactivate [post]
+func (uh *UserHandler) ReactivateUser(c *gin.Context) {
+	currentUser, _ := c.Get("user")
+	uh.setUserStatus(c, currentUser.(*models.User), c.Param("id"), models.UserStatusApproved)
+}
+
+// @Summary Assign user role
+// @Description Change a user's role (admin only). The last active admin cannot be demoted.
+// @Tags users
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param role body models.UserRoleRequest true "New role"
+// @Success 200 {object} models.SuccessResponse{data=models.User}
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users/{id}/role [put]
+func (uh *UserHandler) UpdateUserRole(c *gin.Context) {
+	userID := c.Param("id")
+
+	var req models.UserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+
+	ctx, cancel := uh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	user, err := uh.updateUserGuarded(ctx, userID, func(user *models.User) {
+		user.Role = req.Role
+	})
+	if !uh.respondGuardedUpdate(c, err, "Failed to update user role") {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    user,
+		Message: "User role updated successfully",
+	})
+}
+
+// @Summary Get user stats
+// @Description Get a user's activity: submission counts, fields, sessions and last activity.
+// @Description Users can see their own stats; admins can see anyone's.
+// @Tags users
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.SuccessResponse{data=models.UserStats}
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users/{id}/stats [get]
+func (uh *UserHandler) GetUserStats(c *gin.Context) {
+	userID := c.Param("id")
+	currentUser, _ := c.Get("user")
+	currentUserObj := currentUser.(*models.User)
+
+	if currentUserObj.ID != userID && !currentUserObj.Can(models.PermissionUserViewAll) {
+		c.Error(apperrors.Forbidden("forbidden", "Access denied"))
+		return
+	}
+
+	ctx, cancel := uh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	user, err := uh.getUserByID(ctx, userID)
+	if err != nil {
+		c.Error(apperrors.Lookup(err, "User"))
+		return
+	}
+
+	stats := models.UserStats{
+		UserID:              user.ID,
+		SubmissionsByStatus: make(map[string]int),
+		LastLoginAt:         user.LastLoginAt,
+	}
+
+	submissions, err := activeDocuments(ctx, uh.firestoreService.Submissions().Where("user_id", "==", userID))
+	if err == nil {
+		for _, doc := range submissions {
+			var submission models.Submission
+			if err = doc.DataTo(&submission); err != nil {
+				break
+			}
+
+			stats.Submissions++
+			stats.SubmissionsByStatus[submission.Status]++
+			if stats.LastSubmissionAt == nil || submission.CreatedAt.After(*stats.LastSubmissionAt) {
+				createdAt := submission.CreatedAt
+				stats.LastSubmissionAt = &createdAt
+			}
+		}
+	}
+	if err == nil {
+		var fields []*firestore.DocumentSnapshot
+		fields, err = activeDocuments(ctx, uh.firestoreService.Fields().Where("owner_id", "==", userID))
+		stats.Fields = len(fields)
+	}
+	if err == nil {
+		var sessions []models.Session
+		sessions, err = uh.tokenService.ListSessions(ctx, userID)
+		stats.ActiveSessions = len(sessions)
+	}
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to compute user stats", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    stats,
+	})
+}
+
+// @Summary List user sessions
+// @Description List the active sessions of a user (admin only)
+// @Tags users
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.SuccessResponse{data=[]models.Session}
+// @Failure 403 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users/{id}/sessions [get]
+func (uh *UserHandler) GetUserSessions(c *gin.Context) {
+	userID := c.Param("id")
+
+	ctx, cancel := uh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	sessions, err := uh.tokenService.ListSessions(ctx, userID)
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to retrieve sessions", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    sessions,
+	})
+}
+
+// @Summary Revoke user sessions
+// @Description Log a user out of every device (admin only)
+// @Tags users
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 403 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Router /users/{id}/sessions [delete]
+func (uh *UserHandler) RevokeUserSessions(c *gin.Context) {
+	userID := c.Param("id")
+
+	ctx, cancel := uh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	if err := uh.tokenService.RevokeAllForUser(ctx, userID); err != nil {
+		c.Error(apperrors.Internal("Failed to revoke sessions", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Message: "User sessions revoked successfully",
+	})
+}
+
 // Helper function
-func (uh *UserHandler) getUserByID(userID string) (*models.User, error) {
-	ctx := uh.firestoreService.Context()
+func (uh *UserHandler) getUserByID(ctx context.Context, userID string) (*models.User, error) {
 	doc, err := uh.firestoreService.Users().Doc(userID).Get(ctx)
 	if err != nil {
 		return nil, err
@@ -207,3 +732,126 @@ func (uh *UserHandler) getUserByID(userID string) (*models.User, error) {
 
 	return &user, nil
 }
+
+// setUserStatus changes a user's status, logging them out everywhere unless
+// the new status is approved
+func (uh *UserHandler) setUserStatus(c *gin.Context, currentUser *models.User, userID, status string) {
+	if userID == currentUser.ID && status != models.UserStatusApproved {
+		c.Error(apperrors.Validation("invalid_request", "You cannot change your own status"))
+		return
+	}
+
+	ctx, cancel := uh.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+
+	user, err := uh.updateUserGuarded(ctx, userID, func(user *models.User) {
+		user.Status = status
+	})
+	if !uh.respondGuardedUpdate(c, err, "Failed to update user status") {
+		return
+	}
+
+	// Access tokens already stop working; revoking the sessions ends refreshes
+	// too. API keys are kept: the auth middleware refuses them while the owner
+	// is not approved, and they work again if the user is reinstated.
+	if !user.IsApproved() {
+		if err := uh.tokenService.RevokeAllForUser(ctx, userID); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to revoke sessions", "target_user_id", userID, "error", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, models.SuccessResponse{
+		Success: true,
+		Data:    user,
+		Message: "User status updated successfully",
+	})
+}
+
+// updateUserGuarded applies a role or status change in a transaction,
+// refusing it with errLastAdmin if it would leave no active admin
+func (uh *UserHandler) updateUserGuarded(ctx context.Context, userID string, change func(user *models.User)) (*models.User, error) {
+	userRef := uh.firestoreService.Users().Doc(userID)
+
+	var user models.User
+	err := uh.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(userRef)
+		if status.Code(err) == codes.NotFound {
+			return errUserNotFound
+		}
+		if err != nil {
+			return err
+		}
+		user = models.User{}
+		if err := doc.DataTo(&user); err != nil {
+			return err
+		}
+		if user.DeletedAt != nil {
+			return errUserNotFound
+		}
+
+		wasActiveAdmin := isActiveAdmin(&user)
+		change(&user)
+		user.UpdatedAt = time.Now()
+
+		if wasActiveAdmin && !isActiveAdmin(&user) {
+			admins, err := tx.Documents(uh.firestoreService.Users().Where("role", "==", "admin")).GetAll()
+			if err != nil {
+				return err
+			}
+
+			others := 0
+			for _, adminDoc := range admins {
+				var admin models.User
+				if err := adminDoc.DataTo(&admin); err != nil {
+					return err
+				}
+				if admin.ID != user.ID && isActiveAdmin(&admin) {
+					others++
+				}
+			}
+			if others == 0 {
+				return errLastAdmin
+			}
+		}
+
+		return tx.Update(userRef, []firestore.Update{
+			{Path: "role", Value: user.Role},
+			{Path: "status", Value: user.Status},
+			{Path: "updated_at", Value: user.UpdatedAt},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// respondGuardedUpdate writes the error response for updateUserGuarded and
+// reports whether the update succeeded
+func (uh *UserHandler) respondGuardedUpdate(c *gin.Context, err error, failure string) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == errUserNotFound:
+		c.Error(apperrors.NotFound("not_found", "User not found"))
+	case err == errLastAdmin:
+		c.Error(apperrors.Conflict("last_admin", "At least one active admin must remain"))
+	default:
+		c.Error(apperrors.Internal(failure, err))
+	}
+	return false
+}
+
+// userStatus returns the effective status of a user; legacy users have none
+// and are approved
+func userStatus(user *models.User) string {
+	if user.Status == "" {
+		return models.UserStatusApproved
+	}
+	return user.Status
+}
+
+func isActiveAdmin(user *models.User) bool {
+	return user.Role == "admin" && user.IsApproved() && user.DeletedAt == nil
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"rice-monitor-api/middleware"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetUsers(t *testing.T) {
+	fs := newTestFirestore(t)
+	handler := NewUserHandler(fs, services.NewTokenService(fs), services.NewAggregateService(fs))
+	admin := &models.User{ID: "admin", Role: "admin"}
+
+	// More users than one batch, every third suspended and every tenth
+	// deleted, created a minute apart with u000 the oldest
+	start := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	deletedAt := start
+	for i := 0; i < 2*userListBatchSize+30; i++ {
+		user := &models.User{
+			ID:        fmt.Sprintf("u%03d", i),
+			Email:     fmt.Sprintf("u%03d@example.com", i),
+			Role:      "observer",
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+		}
+		if i%3 == 0 {
+			user.Status = models.UserStatusSuspended
+		}
+		if i%10 == 0 {
+			user.DeletedAt = &deletedAt
+		}
+		createUser(t, fs, user)
+	}
+
+	router := gin.New()
+	router.Use(middleware.Errors(), func(c *gin.Context) { c.Set("user", admin) })
+	router.GET("/users", handler.GetUsers)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "first page", query: "?limit=3", want: []string{"u229", "u228", "u227"}},
+		{name: "later page", query: "?page=2&limit=3", want: []string{"u226", "u225", "u224"}},
+		// Suspended and not deleted: u228, u225, u222, u219, ... so the
+		// eleventh page of five is in the third batch
+		{name: "filtered page across batches", query: "?status=suspended&page=11&limit=5", want: []string{"u063", "u057", "u054", "u051", "u048"}},
+		{name: "deleted", query: "?deleted=true&limit=2", want: []string{"u220", "u210"}},
+		{name: "search", query: "?search=U12", want: []string{"u129", "u128", "u127", "u126", "u125", "u124", "u123", "u122", "u121"}},
+		{name: "past the end", query: "?status=suspended&page=50&limit=5", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveJSON(router, http.MethodGet, "/users"+tt.query, nil)
+			var body struct {
+				Data struct {
+					Users []models.User `json:"users"`
+				} `json:"data"`
+			}
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("got status %d, body %s", recorder.Code, recorder.Body)
+			}
+
+			got := []string{}
+			for _, user := range body.Data.Users {
+				got = append(got, user.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got users %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	fs := newTestFirestore(t)
+	handler := NewUserHandler(fs, services.NewTokenService(fs), services.NewAggregateService(fs))
+	user := createUser(t, fs, &models.User{ID: "u1", Email: "u1@example.com", Name: "Ana", Role: "observer"})
+
+	router := gin.New()
+	router.Use(middleware.Errors(), func(c *gin.Context) { c.Set("user", user) })
+	router.PUT("/users/:id", handler.UpdateUser)
+
+	recorder := serveJSON(router, http.MethodPut, "/users/u1", map[string]interface{}{"name": "Ana Reyes", "role": "admin"})
+	var body struct {
+		Data models.User `json:"data"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d, body %s", recorder.Code, recorder.Body)
+	}
+	if body.Data.Name != "Ana Reyes" || body.Data.Role != "observer" {
+		t.Errorf("got name %q, role %q; want the name changed and the role kept", body.Data.Name, body.Data.Role)
+	}
+}
+
+func TestUpdateUserGuarded(t *testing.T) {
+	fs := newTestFirestore(t)
+	handler := NewUserHandler(fs, services.NewTokenService(fs), services.NewAggregateService(fs))
+	createUser(t, fs, &models.User{ID: "u1", Role: "observer"})
+	suspend := func(user *models.User) { user.Status = models.UserStatusSuspended }
+
+	if _, err := handler.updateUserGuarded(context.Background(), "missing", suspend); err != errUserNotFound {
+		t.Errorf("missing user: got error %v, want errUserNotFound", err)
+	}
+
+	// Other failures are not reported as a missing user
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := handler.updateUserGuarded(ctx, "u1", suspend); err == nil || err == errUserNotFound {
+		t.Errorf("cancelled context: got error %v, want the datastore error", err)
+	}
+
+	user, err := handler.updateUserGuarded(context.Background(), "u1", suspend)
+	if err != nil || user.Status != models.UserStatusSuspended {
+		t.Errorf("got user %+v, error %v; want suspended", user, err)
+	}
+}
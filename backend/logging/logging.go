@@ -0,0 +1,110 @@
+// Package logging configures the structured slog logger. Records logged with
+// a request context carry that request's ID, its trace and span IDs and, once
+// authenticated, the user's ID.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"rice-monitor-api/config"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userIDKey
+)
+
+// Setup installs the default slog logger, which the standard log package
+// also writes through. The JSON format uses the severity and message keys
+// Cloud Logging recognises.
+func Setup(cfg config.LoggingConfig) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(os.Stdout, options)
+	} else {
+		options.ReplaceAttr = cloudLoggingAttr
+		handler = slog.NewJSONHandler(os.Stdout, options)
+	}
+
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+}
+
+// cloudLoggingAttr renames the level and message keys for Cloud Logging
+func cloudLoggingAttr(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return attr
+	}
+
+	switch attr.Key {
+	case slog.LevelKey:
+		level, _ := attr.Value.Any().(slog.Level)
+		severity := strings.ToUpper(level.String())
+		if level == slog.LevelWarn {
+			severity = "WARNING"
+		}
+		return slog.String("severity", severity)
+	case slog.MessageKey:
+		return slog.String("message", attr.Value.String())
+	}
+	return attr
+}
+
+// WithRequestID returns a context whose log records carry the request ID
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// WithUserID returns a context whose log records carry the user ID
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// RequestID returns the request ID stored in ctx, if any
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// contextHandler adds the request, trace and user IDs from the record's
+// context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (ch *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID, ok := ctx.Value(requestIDKey).(string); ok {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+	if userID, ok := ctx.Value(userIDKey).(string); ok {
+		record.AddAttrs(slog.String("user_id", userID))
+	}
+	return ch.Handler.Handle(ctx, record)
+}
+
+func (ch *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: ch.Handler.WithAttrs(attrs)}
+}
+
+func (ch *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: ch.Handler.WithGroup(name)}
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestJSONRecords(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(&contextHandler{Handler: slog.NewJSONHandler(&out, &slog.HandlerOptions{ReplaceAttr: cloudLoggingAttr})})
+
+	ctx := WithUserID(WithRequestID(context.Background(), "req-1"), "u1")
+
+	tests := []struct {
+		name string
+		log  func()
+		want map[string]interface{}
+	}{
+		{
+			name: "request context",
+			log:  func() { logger.WarnContext(ctx, "Slow query", slog.Int("ms", 1200)) },
+			want: map[string]interface{}{"severity": "WARNING", "message": "Slow query", "request_id": "req-1", "user_id": "u1", "ms": 1200.0},
+		},
+		{
+			name: "span context",
+			log: func() {
+				traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+				spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+				spanCtx := trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+				logger.InfoContext(spanCtx, "Traced")
+			},
+			want: map[string]interface{}{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736", "span_id": "00f067aa0ba902b7", "request_id": "req-1"},
+		},
+		{
+			name: "background context",
+			log:  func() { logger.Error("Purge failed") },
+			want: map[string]interface{}{"severity": "ERROR", "message": "Purge failed", "request_id": nil, "user_id": nil, "trace_id": nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			tt.log()
+
+			var record map[string]interface{}
+			if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+				t.Fatalf("log output %q is not JSON: %v", out.String(), err)
+			}
+			for key, want := range tt.want {
+				if got := record[key]; got != want {
+					t.Errorf("got %s %v, want %v in %s", key, got, want, out.String())
+				}
+			}
+		})
+	}
+}
@@ -2,32 +2,44 @@ package main
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"syscall"
+	"time"
 
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/config"
 	_ "rice-monitor-api/docs"
 	"rice-monitor-api/handlers"
+	"rice-monitor-api/logging"
 	"rice-monitor-api/middleware"
+	"rice-monitor-api/models"
 	"rice-monitor-api/services"
+	"rice-monitor-api/tracing"
+	"rice-monitor-api/utils"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-func main() {
-	if _, err := os.Stat(".env"); err == nil {
-		// Only load if file exists (for local dev)
-		err := godotenv.Load(".env")
-		if err != nil {
-			log.Fatalf("Error loading .env file")
-		}
-	} else {
-		log.Println(".env file not found, assuming environment variables are set externally")
-	}
+// traceFlushTimeout bounds how long buffered spans may take to export on exit
+const traceFlushTimeout = 5 * time.Second
+
+// Build information, set at build time with
+// -ldflags "-X main.version=<tag> -X main.commit=<sha> -X main.buildTime=<RFC 3339 time>"
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
 
+func main() {
 	// @title Rice Monitor API
 	// @version 1.0
 	// @description This is a sample server for a rice monitoring application.
@@ -44,142 +56,382 @@ func main() {
 	// @BasePath /api/v1
 	// @schemes http https
 
-	// Initialize services
+	// "rebuild-aggregates" recomputes the analytics aggregates and exits
+	if len(os.Args) > 1 && os.Args[1] == "rebuild-aggregates" {
+		if err := rebuildAggregates(); err != nil {
+			slog.Error("Aggregate rebuild failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// "-check-config [env.yaml]" validates the configuration, after applying
+	// a Cloud Run env-vars file when one is given, and exits
+	if len(os.Args) > 1 && os.Args[1] == "-check-config" {
+		envFile := ""
+		if len(os.Args) > 2 {
+			envFile = os.Args[2]
+		}
+		if err := checkConfig(envFile); err != nil {
+			slog.Error("Configuration check failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := run(); err != nil {
+		slog.Error("Server exited", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the server and blocks until it fails or receives SIGINT or
+// SIGTERM, which Cloud Run sends before stopping an instance. Deferred
+// cleanup runs on every return: background workers are stopped and waited
+// for before the Firestore and Storage clients are closed.
+func run() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	logging.Setup(cfg.Logging)
+	slog.Info("Effective configuration", "config", cfg.Redacted())
+
+	// Service clients use ctx, which is never cancelled so in-flight requests
+	// can finish; requests use their own contexts and background workers stop
+	// with stopCtx
 	ctx := context.Background()
+	stopCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	firestoreService, err := services.NewFirestoreService(ctx)
+	// Tracing is flushed last, after in-flight requests have finished
+	info := buildInfo()
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing, info.Version)
 	if err != nil {
-		log.Fatal("Failed to initialize Firestore service:", err)
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(ctx, traceFlushTimeout)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+	}()
+
+	// Initialize services
+	firestoreService, err := services.NewFirestoreService(ctx, cfg.Google.ProjectID, cfg.Datastore)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Firestore service: %w", err)
 	}
 	defer firestoreService.Close()
 
-	storageService, err := services.NewStorageService(ctx)
+	storageService, err := services.NewStorageService(ctx, cfg.Google.StorageBucket, cfg.Datastore)
 	if err != nil {
-		log.Fatal("Failed to initialize Storage service:", err)
+		return fmt.Errorf("failed to initialize Storage service: %w", err)
 	}
 	defer storageService.Close()
 
+	// Permanently remove soft-deleted records after the retention period
+	purgeService := services.NewPurgeService(firestoreService, storageService, cfg.Purge)
+	purgeService.Start(stopCtx)
+	defer purgeService.Wait()
+
+	// Token signing keys
+	keyProvider, err := newKeyProvider(ctx, firestoreService, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize token signing keys: %w", err)
+	}
+	if keyService, ok := keyProvider.(*services.KeyService); ok {
+		keyService.Start(stopCtx)
+		defer keyService.Wait()
+	}
+	utils.SetKeyProvider(keyProvider)
+
+	// Deferred calls run last-in first-out, so this stops the workers above
+	// before they are waited for, also when run returns with an error
+	defer stop()
+
+	tokenService := services.NewTokenService(firestoreService)
+	aggregateService := services.NewAggregateService(firestoreService)
+	apiKeyService := services.NewAPIKeyService(firestoreService)
+
+	// Identity providers; email login needs a way to send mail
+	mailer := services.NewMailer(cfg.SMTP, cfg.IsDevelopment())
+	onboardingService := services.NewOnboardingService(firestoreService, mailer, cfg.Onboarding)
+	magicLinkService := services.NewMagicLinkService(firestoreService, onboardingService, mailer, cfg.Identity.MagicLinkURL)
+	identityProviders, err := services.NewIdentityProviders(cfg.Google.ClientID, cfg.Identity.OIDCProviders, magicLinkService)
+	if err != nil {
+		return fmt.Errorf("failed to initialize identity providers: %w", err)
+	}
+
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(firestoreService)
-	userHandler := handlers.NewUserHandler(firestoreService)
-	submissionHandler := handlers.NewSubmissionHandler(firestoreService)
+	authHandler := handlers.NewAuthHandler(firestoreService, tokenService, onboardingService, identityProviders, magicLinkService)
+	userHandler := handlers.NewUserHandler(firestoreService, tokenService, aggregateService)
+	invitationHandler := handlers.NewInvitationHandler(firestoreService, onboardingService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(firestoreService, apiKeyService)
+	submissionHandler := handlers.NewSubmissionHandler(firestoreService, aggregateService)
 	imageHandler := handlers.NewImageHandler(storageService, firestoreService)
-	fieldHandler := handlers.NewFieldHandler(firestoreService)
-	analyticsHandler := handlers.NewAnalyticsHandler(firestoreService)
+	fieldHandler := handlers.NewFieldHandler(firestoreService, aggregateService)
+	analyticsHandler := handlers.NewAnalyticsHandler(firestoreService, aggregateService)
+	jwksHandler := handlers.NewJWKSHandler(keyProvider)
+	healthHandler := handlers.NewHealthHandler(firestoreService, storageService, info, cfg.Server.ReadinessTimeout)
 
 	// Initialize middleware
-	authMiddleware := middleware.NewAuthMiddleware(firestoreService)
+	authMiddleware := middleware.NewAuthMiddleware(firestoreService, tokenService, apiKeyService)
+	idempotencyMiddleware := middleware.NewIdempotencyMiddleware(firestoreService, cfg.Idempotency)
+	rateLimiter := middleware.NewRateLimiter(middleware.NewMemoryRateLimitStore())
 
 	// Setup router
-	router := setupRouter(
+	router, err := setupRouter(
+		cfg,
 		authHandler,
 		userHandler,
+		invitationHandler,
+		apiKeyHandler,
 		submissionHandler,
 		imageHandler,
 		fieldHandler,
 		analyticsHandler,
+		jwksHandler,
+		healthHandler,
 		authMiddleware,
+		idempotencyMiddleware,
+		rateLimiter,
 	)
+	if err != nil {
+		return fmt.Errorf("failed to set up router: %w", err)
+	}
 
-	// Get port from environment or use 8080
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8989"
+	server := &http.Server{
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting", "port", cfg.Server.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("server failed: %w", err)
+	case <-stopCtx.Done():
+	}
+
+	// Restore default signal handling so a second signal exits immediately
+	stop()
+	slog.Info("Shutting down, draining in-flight requests", "timeout", cfg.Server.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain in-flight requests: %w", err)
+	}
+
+	slog.Info("Server stopped")
+	return nil
+}
+
+// newKeyProvider selects how tokens are signed. RS256 and EdDSA use rotating
+// key pairs shared through Firestore; HS256 with a shared secret is kept for
+// local development.
+func newKeyProvider(ctx context.Context, firestoreService *services.FirestoreService, cfg *config.Config) (utils.KeyProvider, error) {
+	if cfg.JWT.Algorithm == "HS256" {
+		return utils.NewHMACKeyProvider(cfg.JWT.Secret, cfg.IsDevelopment())
+	}
+
+	encryptionKey, err := cfg.JWT.EncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT_KEY_ENCRYPTION_KEY: %w", err)
+	}
+
+	keyService, err := services.NewKeyService(ctx, firestoreService, cfg.JWT.Algorithm, cfg.JWT.KeyRotation, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return keyService, nil
+}
+
+// buildInfo reports the build variables, falling back to the VCS details the
+// Go toolchain embeds when they were not set
+func buildInfo() models.BuildInfo {
+	info := models.BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range embedded.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+
+	return info
 }
 
 func setupRouter(
+	cfg *config.Config,
 	authHandler *handlers.AuthHandler,
 	userHandler *handlers.UserHandler,
+	invitationHandler *handlers.InvitationHandler,
+	apiKeyHandler *handlers.APIKeyHandler,
 	submissionHandler *handlers.SubmissionHandler,
 	imageHandler *handlers.ImageHandler,
 	fieldHandler *handlers.FieldHandler,
 	analyticsHandler *handlers.AnalyticsHandler,
+	jwksHandler *handlers.JWKSHandler,
+	healthHandler *handlers.HealthHandler,
 	authMiddleware *middleware.AuthMiddleware,
-) *gin.Engine {
+	idempotencyMiddleware *middleware.IdempotencyMiddleware,
+	rateLimiter *middleware.RateLimiter,
+) (*gin.Engine, error) {
 	router := gin.New()
 
-	// 1. Use logger and recovery middlewares first
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	// Client addresses key the rate limits of anonymous callers and are
+	// logged, so only the proxies in front of the server may report them
+	if err := middleware.TrustProxies(router, cfg.Server.TrustedProxies); err != nil {
+		return nil, err
+	}
 
-	// CORS middleware
-	router.Use(middleware.CORSMiddleware())
+	// Request IDs and the trace span first so every later log line carries
+	// them, then one log line per request, metrics, error rendering and
+	// panic recovery
+	router.Use(middleware.RequestID())
+	router.Use(middleware.Tracing())
+	router.Use(middleware.RequestLogger())
+	router.Use(middleware.Metrics())
+	router.Use(middleware.Errors())
+	router.Use(middleware.Recovery())
 
-	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Content-Security-Policy", "connect-src 'self' http://localhost:3000 http://localhost:8989")
-		c.Next()
-	})
+	// CORS and security headers
+	router.Use(middleware.CORSMiddleware(cfg.CORS))
+	router.Use(middleware.SecurityHeaders(cfg.SecurityHeaders))
 
 	router.OPTIONS("/*path", func(c *gin.Context) {
 		c.AbortWithStatus(http.StatusOK)
 	})
-
-	router.Use(func(c *gin.Context) {
-		log.Println("Incoming request from:", c.Request.Header.Get("Origin"))
-		c.Next()
+	router.NoRoute(func(c *gin.Context) {
+		c.Error(apperrors.NotFound("route_not_found", "No route matches "+c.Request.Method+" "+c.Request.URL.Path))
 	})
 
-	// Health check
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":    "healthy",
-			"timestamp": "2024-01-01T00:00:00Z",
-			"version":   "1.0.0",
-		})
-	})
+	// Health checks and build information; /health is kept for existing probes
+	router.GET("/health", healthHandler.Healthz)
+	router.GET("/healthz", healthHandler.Healthz)
+	router.GET("/readyz", healthHandler.Readyz)
+	router.GET("/version", healthHandler.Version)
+
+	// Prometheus metrics
+	if cfg.Metrics.Enabled {
+		router.GET("/metrics", middleware.MetricsHandler(cfg.Metrics.Token))
+	}
+
+	// Public keys for verifying issued tokens
+	router.GET("/.well-known/jwks.json", jwksHandler.GetJWKS)
 
 	// API routes
 	api := router.Group("/api/v1")
 	{
 		// Authentication routes
 		auth := api.Group("/auth")
+		auth.Use(rateLimiter.Limit("auth", cfg.RateLimits.Auth))
 		{
 			auth.POST("/google", authHandler.GoogleLogin)
+			auth.POST("/login/:provider", authHandler.Login)
+			auth.POST("/magic-link", authHandler.RequestMagicLink)
 			auth.POST("/refresh", authHandler.RefreshToken)
 			auth.POST("/logout", authMiddleware.RequireAuth(), authHandler.Logout)
+			auth.POST("/logout-all", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), authHandler.LogoutAll)
 			auth.GET("/me", authMiddleware.RequireAuth(), authHandler.GetCurrentUser)
+			auth.GET("/permissions", authMiddleware.RequireAuth(), authHandler.GetPermissions)
+			auth.GET("/sessions", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), authHandler.GetSessions)
+			auth.DELETE("/sessions/:id", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), authHandler.RevokeSession)
+			auth.GET("/identities", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), authHandler.GetIdentities)
+			auth.POST("/identities", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), authHandler.LinkIdentity)
+			auth.DELETE("/identities/:id", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), authHandler.UnlinkIdentity)
+			auth.GET("/api-keys", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), apiKeyHandler.GetAPIKeys)
+			auth.POST("/api-keys", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), apiKeyHandler.CreateAPIKey)
+			auth.DELETE("/api-keys/:id", authMiddleware.RequireAuth(), authMiddleware.RequireSession(), apiKeyHandler.RevokeAPIKey)
 		}
 
 		// Protected routes
 		protected := api.Group("/")
-		protected.Use(authMiddleware.RequireAuth())
+		protected.Use(authMiddleware.RequireAuth(), rateLimiter.Limit("api", cfg.RateLimits.API))
 		{
 			// Users
 			users := protected.Group("/users")
 			{
+				users.GET("/", authMiddleware.RequirePermission(models.PermissionUserViewAll), userHandler.GetUsers)
 				users.GET("/:id", userHandler.GetUser)
+				users.GET("/:id/stats", userHandler.GetUserStats)
 				users.PUT("/:id", userHandler.UpdateUser)
-				users.DELETE("/:id", userHandler.DeleteUser)
+				users.DELETE("/:id", authMiddleware.RequirePermission(models.PermissionUserManage), userHandler.DeleteUser)
+				users.POST("/:id/restore", authMiddleware.RequirePermission(models.PermissionUserManage), userHandler.RestoreUser)
+				users.PUT("/:id/status", authMiddleware.RequirePermission(models.PermissionUserManage), userHandler.UpdateUserStatus)
+				users.PUT("/:id/role", authMiddleware.RequirePermission(models.PermissionUserManage), userHandler.UpdateUserRole)
+				users.POST("/:id/suspend", authMiddleware.RequirePermission(models.PermissionUserManage), userHandler.SuspendUser)
+				users.POST("/:id/reactivate", authMiddleware.RequirePermission(models.PermissionUserManage), userHandler.ReactivateUser)
+				users.GET("/:id/sessions", authMiddleware.RequirePermission(models.PermissionUserManage), userHandler.GetUserSessions)
+				users.DELETE("/:id/sessions", authMiddleware.RequirePermission(models.PermissionUserManage), userHandler.RevokeUserSessions)
+			}
+
+			// Invitations
+			invitations := protected.Group("/invitations")
+			invitations.Use(authMiddleware.RequirePermission(models.PermissionInvitationManage))
+			{
+				invitations.GET("/", invitationHandler.GetInvitations)
+				invitations.POST("/", invitationHandler.CreateInvitation)
+				invitations.DELETE("/:id", invitationHandler.DeleteInvitation)
 			}
 
 			// Monitoring submissions
 			submissions := protected.Group("/submissions")
 			{
 				submissions.GET("/", submissionHandler.GetSubmissions)
-				submissions.POST("/", submissionHandler.CreateSubmission)
+				submissions.POST("/", authMiddleware.RequirePermission(models.PermissionSubmissionCreate), idempotencyMiddleware.Idempotent(), submissionHandler.CreateSubmission)
 				submissions.GET("/:id", submissionHandler.GetSubmission)
 				submissions.PUT("/:id", submissionHandler.UpdateSubmission)
 				submissions.DELETE("/:id", submissionHandler.DeleteSubmission)
-				submissions.GET("/export", submissionHandler.ExportSubmissions)
+				submissions.POST("/:id/restore", submissionHandler.RestoreSubmission)
+				submissions.GET("/export", authMiddleware.RequirePermission(models.PermissionSubmissionExport), rateLimiter.Limit("expensive", cfg.RateLimits.Expensive), submissionHandler.ExportSubmissions)
 			}
 
 			// Image upload
 			images := protected.Group("/images")
 			{
-				images.POST("/upload", imageHandler.UploadImage)
-				images.GET("/:filename", imageHandler.GetImage)
-				images.DELETE("/:filename", imageHandler.DeleteImage)
+				images.POST("/upload", authMiddleware.RequirePermission(models.PermissionImageUpload), idempotencyMiddleware.Idempotent(), imageHandler.UploadImage)
+				// Filenames contain the submission ID as a directory
+				images.GET("/*filename", imageHandler.GetImage)
+				images.DELETE("/*filename", imageHandler.DeleteImage)
 			}
 
 			// Analytics
 			analytics := protected.Group("/analytics")
+			analytics.Use(rateLimiter.Limit("expensive", cfg.RateLimits.Expensive))
 			{
 				analytics.GET("/dashboard", analyticsHandler.GetDashboardData)
 				analytics.GET("/trends", analyticsHandler.GetTrends)
+				analytics.GET("/trends/series", analyticsHandler.GetTrendSeries)
+				analytics.GET("/stages", analyticsHandler.GetStageProgress)
 				analytics.GET("/reports", analyticsHandler.GetReports)
 			}
 
@@ -187,10 +439,11 @@ func setupRouter(
 			fields := protected.Group("/fields")
 			{
 				fields.GET("/", fieldHandler.GetFields)
-				fields.POST("/", fieldHandler.CreateField)
+				fields.POST("/", authMiddleware.RequirePermission(models.PermissionFieldCreate), idempotencyMiddleware.Idempotent(), fieldHandler.CreateField)
 				fields.GET("/:id", fieldHandler.GetField)
 				fields.PUT("/:id", fieldHandler.UpdateField)
 				fields.DELETE("/:id", fieldHandler.DeleteField)
+				fields.POST("/:id/restore", fieldHandler.RestoreField)
 			}
 		}
 	}
@@ -198,5 +451,5 @@ func setupRouter(
 	// Swagger endpoint
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	return router
+	return router, nil
 }
@@ -0,0 +1,82 @@
+// Package metrics defines the Prometheus metrics served on /metrics. The
+// collectors are registered on Registry, which also reports Go runtime and
+// process metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "rice_monitor"
+
+// Registry holds every metric the server exposes
+var Registry = prometheus.NewRegistry()
+
+// HTTP metrics. Routes are the registered gin patterns, such as
+// /api/v1/submissions/:id, so IDs do not create new series.
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	HTTPRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being handled.",
+	})
+)
+
+// Domain metrics
+var (
+	SubmissionsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "submissions_created_total",
+		Help:      "Monitoring submissions created, by status.",
+	}, []string{"status"})
+
+	ImageUploadBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_upload_bytes",
+		Help:      "Size of uploaded images in bytes.",
+		// 64 KiB to 32 MiB
+		Buckets: prometheus.ExponentialBuckets(64*1024, 2, 10),
+	})
+
+	FirestoreCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "firestore_call_duration_seconds",
+		Help:      "Latency of Firestore RPCs, by method and gRPC status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		HTTPRequestsInFlight,
+		SubmissionsCreated,
+		ImageUploadBytes,
+		FirestoreCallDuration,
+	)
+}
+
+// Handler serves the registered metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -1,104 +1,219 @@
 package middleware
 
 import (
-	"net/http"
+	"context"
+	"log/slog"
 	"strings"
+	"time"
 
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/logging"
 	"rice-monitor-api/models"
 	"rice-monitor-api/services"
 	"rice-monitor-api/utils"
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// sessionTouchInterval limits how often a session's last use is written
+	sessionTouchInterval = 5 * time.Minute
+	// APIKeyHeader carries a personal API key in place of a bearer token
+	APIKeyHeader = "X-API-Key"
 )
 
 type AuthMiddleware struct {
 	firestoreService *services.FirestoreService
+	tokenService     *services.TokenService
+	apiKeyService    *services.APIKeyService
 }
 
-func NewAuthMiddleware(firestoreService *services.FirestoreService) *AuthMiddleware {
+func NewAuthMiddleware(firestoreService *services.FirestoreService, tokenService *services.TokenService, apiKeyService *services.APIKeyService) *AuthMiddleware {
 	return &AuthMiddleware{
 		firestoreService: firestoreService,
+		tokenService:     tokenService,
+		apiKeyService:    apiKeyService,
 	}
 }
 
 func (am *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// Scripts authenticate with a personal API key instead of a session
+		if apiKey := c.GetHeader(APIKeyHeader); apiKey != "" {
+			am.authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error:   "unauthorized",
-				Message: "Authorization header required",
-			})
+			c.Error(apperrors.Unauthorized("unauthorized", "Authorization header required"))
 			c.Abort()
 			return
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error:   "unauthorized",
-				Message: "Bearer token required",
-			})
+			c.Error(apperrors.Unauthorized("unauthorized", "Bearer token required"))
 			c.Abort()
 			return
 		}
 
-		claims, err := utils.ValidateToken(tokenString)
+		// Refresh tokens are only accepted by /auth/refresh
+		claims, err := utils.ValidateAccessToken(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error:   "unauthorized",
-				Message: "Invalid token",
-			})
+			c.Error(apperrors.Unauthorized("unauthorized", "Invalid token"))
 			c.Abort()
 			return
 		}
 
+		ctx, cancel := am.firestoreService.WithTimeout(c.Request.Context())
+		defer cancel()
+
 		// Get user from database
-		user, err := am.getUserByID(claims.UserID)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error:   "unauthorized",
-				Message: "User not found",
-			})
+		user, err := am.getUserByID(ctx, claims.UserID)
+		if err != nil && status.Code(err) != codes.NotFound {
+			c.Error(apperrors.Internal("Failed to load user", err))
+			c.Abort()
+			return
+		}
+		if err != nil || user.DeletedAt != nil {
+			c.Error(apperrors.Unauthorized("unauthorized", "User not found"))
+			c.Abort()
+			return
+		}
+
+		// Pending and suspended users keep their account but not their access
+		if !user.IsApproved() {
+			c.Error(user.ApprovalError())
 			c.Abort()
 			return
 		}
 
+		// Tokens issued before sessions existed have no session to check, so
+		// their holders must sign in again
+		if claims.FamilyID == "" {
+			c.Error(apperrors.Unauthorized("unauthorized", "Session has expired; please sign in again"))
+			c.Abort()
+			return
+		}
+
+		// Revoked sessions stop working immediately, not when the token expires
+		session, err := am.tokenService.GetSession(ctx, claims.FamilyID)
+		if err != nil && status.Code(err) != codes.NotFound {
+			c.Error(apperrors.Internal("Failed to load session", err))
+			c.Abort()
+			return
+		}
+		if err != nil || session.UserID != user.ID || session.RevokedAt != nil {
+			c.Error(apperrors.Unauthorized("unauthorized", "Session has been revoked"))
+			c.Abort()
+			return
+		}
+
+		if time.Since(session.LastUsedAt) > sessionTouchInterval {
+			if err := am.tokenService.TouchSession(ctx, session.ID); err != nil {
+				slog.WarnContext(c.Request.Context(), "Failed to update session last use", "error", err)
+			}
+		}
+
 		c.Set("user", user)
 		c.Set("user_id", user.ID)
 		c.Set("user_role", user.Role)
+		c.Set("session_id", session.ID)
+		c.Request = c.Request.WithContext(logging.WithUserID(c.Request.Context(), user.ID))
 		c.Next()
 	}
 }
 
-func (am *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
+func (am *AuthMiddleware) authenticateAPIKey(c *gin.Context, key string) {
+	ctx, cancel := am.firestoreService.WithTimeout(c.Request.Context())
+	defer cancel()
+	apiKey, err := am.apiKeyService.Authenticate(ctx, key)
+	if err == services.ErrAPIKeyInvalid {
+		c.Error(apperrors.Unauthorized("unauthorized", "Invalid API key"))
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.Error(apperrors.Internal("Failed to authenticate API key", err))
+		c.Abort()
+		return
+	}
+
+	user, err := am.getUserByID(ctx, apiKey.UserID)
+	if err != nil && status.Code(err) != codes.NotFound {
+		c.Error(apperrors.Internal("Failed to load user", err))
+		c.Abort()
+		return
+	}
+	if err != nil || user.DeletedAt != nil {
+		c.Error(apperrors.Unauthorized("unauthorized", "User not found"))
+		c.Abort()
+		return
+	}
+
+	if !user.IsApproved() {
+		c.Error(user.ApprovalError())
+		c.Abort()
+		return
+	}
+
+	// The key can only use the permissions it was scoped to
+	user.Scopes = apiKey.Scopes
+	if user.Scopes == nil {
+		user.Scopes = []models.Permission{}
+	}
+
+	c.Set("user", user)
+	c.Set("user_id", user.ID)
+	c.Set("user_role", user.Role)
+	c.Set("api_key_id", apiKey.ID)
+	c.Request = c.Request.WithContext(logging.WithUserID(c.Request.Context(), user.ID))
+	c.Next()
+}
+
+// RequireSession rejects requests authenticated with an API key. It guards
+// account management, so a leaked key cannot link identities, mint keys or
+// end the owner's sessions.
+func (am *AuthMiddleware) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("api_key_id") != "" {
+			c.Error(apperrors.Forbidden("forbidden", "This endpoint requires a login session, not an API key"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequirePermission allows the request only if the user's role grants every
+// listed permission. Handlers still check ownership for row-level access.
+func (am *AuthMiddleware) RequirePermission(permissions ...models.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user, exists := c.Get("user")
 		if !exists {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{
-				Error:   "unauthorized",
-				Message: "User not found in context",
-			})
+			c.Error(apperrors.Unauthorized("unauthorized", "User not found in context"))
 			c.Abort()
 			return
 		}
 
 		userObj := user.(*models.User)
-		if userObj.Role != "admin" {
-			c.JSON(http.StatusForbidden, models.ErrorResponse{
-				Error:   "forbidden",
-				Message: "Admin access required",
-			})
-			c.Abort()
-			return
+		for _, permission := range permissions {
+			if !userObj.Can(permission) {
+				c.Error(apperrors.Forbidden("forbidden", "Missing permission "+string(permission)))
+				c.Abort()
+				return
+			}
 		}
 
 		c.Next()
 	}
 }
 
-func (am *AuthMiddleware) getUserByID(userID string) (*models.User, error) {
-	ctx := am.firestoreService.Context()
+func (am *AuthMiddleware) getUserByID(ctx context.Context, userID string) (*models.User, error) {
 	doc, err := am.firestoreService.Users().Doc(userID).Get(ctx)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequirePermission(t *testing.T) {
+	am := NewAuthMiddleware(nil, nil, nil)
+
+	tests := []struct {
+		name        string
+		role        string
+		permissions []models.Permission
+		code        int
+	}{
+		{name: "observer creates submissions", role: "observer", permissions: []models.Permission{models.PermissionSubmissionCreate}, code: http.StatusNoContent},
+		{name: "observer views analytics", role: "observer", permissions: []models.Permission{models.PermissionAnalyticsViewAll}, code: http.StatusForbidden},
+		{name: "researcher views analytics", role: "researcher", permissions: []models.Permission{models.PermissionAnalyticsViewAll}, code: http.StatusNoContent},
+		{name: "researcher manages users", role: "researcher", permissions: []models.Permission{models.PermissionUserViewAll, models.PermissionUserManage}, code: http.StatusForbidden},
+		{name: "admin manages users", role: "admin", permissions: []models.Permission{models.PermissionUserViewAll, models.PermissionUserManage}, code: http.StatusNoContent},
+		{name: "unknown role", role: "guest", permissions: []models.Permission{models.PermissionSubmissionCreate}, code: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(Errors())
+			router.GET("/", withUser(&models.User{ID: "u1", Role: tt.role}), am.RequirePermission(tt.permissions...), func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if recorder.Code != tt.code {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestRequirePermissionWithoutUser(t *testing.T) {
+	router := gin.New()
+	router.Use(Errors())
+	router.GET("/", NewAuthMiddleware(nil, nil, nil).RequirePermission(models.PermissionSubmissionCreate), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFirestore(t)
+	apiKeyService := services.NewAPIKeyService(fs)
+	am := NewAuthMiddleware(fs, services.NewTokenService(fs), apiKeyService)
+
+	createUser(t, fs, &models.User{ID: "researcher", Role: "researcher"})
+	createUser(t, fs, &models.User{ID: "suspended", Role: "researcher", Status: models.UserStatusSuspended})
+
+	createKey := func(userID string) (*models.APIKey, string) {
+		apiKey, key, err := apiKeyService.Create(ctx, userID, models.CreateAPIKeyRequest{
+			Name:   "upload script",
+			Scopes: []models.Permission{models.PermissionSubmissionCreate},
+		})
+		if err != nil {
+			t.Fatalf("creating API key: %v", err)
+		}
+		return apiKey, key
+	}
+
+	_, scoped := createKey("researcher")
+	revokedKey, revoked := createKey("researcher")
+	if err := apiKeyService.Revoke(ctx, "researcher", revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	expiredKey, expired := createKey("researcher")
+	if _, err := fs.APIKeys().Doc(expiredKey.ID).Update(ctx, []firestore.Update{{Path: "expires_at", Value: time.Now().Add(-time.Minute)}}); err != nil {
+		t.Fatal(err)
+	}
+	_, suspended := createKey("suspended")
+
+	router := gin.New()
+	router.Use(Errors())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.POST("/submissions", am.RequireAuth(), am.RequirePermission(models.PermissionSubmissionCreate), ok)
+	router.GET("/analytics", am.RequireAuth(), am.RequirePermission(models.PermissionAnalyticsViewAll), ok)
+	router.GET("/sessions", am.RequireAuth(), am.RequireSession(), ok)
+
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		code   int
+	}{
+		{name: "permission in scope", key: scoped, method: http.MethodPost, path: "/submissions", code: http.StatusNoContent},
+		{name: "role permission outside scope", key: scoped, method: http.MethodGet, path: "/analytics", code: http.StatusForbidden},
+		{name: "session-only endpoint", key: scoped, method: http.MethodGet, path: "/sessions", code: http.StatusForbidden},
+		{name: "revoked key", key: revoked, method: http.MethodPost, path: "/submissions", code: http.StatusUnauthorized},
+		{name: "expired key", key: expired, method: http.MethodPost, path: "/submissions", code: http.StatusUnauthorized},
+		{name: "wrong secret", key: scoped + "x", method: http.MethodPost, path: "/submissions", code: http.StatusUnauthorized},
+		{name: "malformed key", key: "not-a-key", method: http.MethodPost, path: "/submissions", code: http.StatusUnauthorized},
+		{name: "suspended owner", key: suspended, method: http.MethodPost, path: "/submissions", code: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(APIKeyHeader, tt.key)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.code {
+				t.Errorf("got status %d, body %s; want %d", recorder.Code, recorder.Body, tt.code)
+			}
+		})
+	}
+}
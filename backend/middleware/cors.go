@@ -2,22 +2,112 @@ package middleware
 
 import (
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"rice-monitor-api/config"
 
 	"github.com/gin-gonic/gin"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
+// corsExposedHeaders are the response headers browser code may read
+var corsExposedHeaders = []string{
+	IdempotencyReplayedHeader, RequestIDHeader, "RateLimit-Policy", "RateLimit-Limit",
+	"RateLimit-Remaining", "RateLimit-Reset", "Retry-After",
+}
+
+type originPattern struct {
+	scheme string
+	host   string // includes the port; a leading "*." matches any subdomain
+}
+
+func (op originPattern) matches(scheme, host string) bool {
+	if scheme != op.scheme {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(op.host, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == op.host
+}
+
+// CORSMiddleware answers preflight requests and allows the configured origins.
+// The Idempotency-Key, X-API-Key, X-Request-ID and W3C trace context headers
+// are always allowed since the API depends on them.
+func CORSMiddleware(cfg config.CORSConfig) gin.HandlerFunc {
+	allowAll := false
+	patterns := []originPattern{}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			allowAll = true
+			continue
+		}
+		if scheme, host, ok := strings.Cut(strings.ToLower(strings.TrimSuffix(origin, "/")), "://"); ok {
+			patterns = append(patterns, originPattern{scheme: scheme, host: host})
+		}
+	}
+
+	allowMethods := strings.Join(cfg.AllowedMethods, ", ")
+	allowedHeaders := append([]string{}, cfg.AllowedHeaders...)
+	allowHeaders := strings.Join(append(allowedHeaders, IdempotencyKeyHeader, APIKeyHeader, RequestIDHeader, "traceparent", "tracestate"), ", ")
+	exposeHeaders := strings.Join(corsExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	originAllowed := func(origin string) bool {
+		u, err := url.Parse(strings.ToLower(origin))
+		if err != nil || u.Host == "" {
+			return false
+		}
+		for _, pattern := range patterns {
+			if pattern.matches(u.Scheme, u.Host) {
+				return true
+			}
+		}
+		return false
+	}
+
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		header := c.Writer.Header()
+		origin := c.Request.Header.Get("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+
+		// The response depends on the Origin, so caches must key on it
+		header.Add("Vary", "Origin")
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if origin != "" {
+			switch {
+			case originAllowed(origin):
+				header.Set("Access-Control-Allow-Origin", origin)
+				if cfg.AllowCredentials {
+					header.Set("Access-Control-Allow-Credentials", "true")
+				}
+			case allowAll:
+				// Credentials are never sent to arbitrary origins
+				header.Set("Access-Control-Allow-Origin", "*")
+			default:
+				origin = ""
+			}
+		}
+
+		if origin != "" {
+			header.Set("Access-Control-Expose-Headers", exposeHeaders)
+			if preflight {
+				header.Set("Access-Control-Allow-Methods", allowMethods)
+				header.Set("Access-Control-Allow-Headers", allowHeaders)
+				header.Set("Access-Control-Max-Age", maxAge)
+			}
+		}
 
-		if c.Request.Method == "OPTIONS" {
+		if preflight {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
 		c.Next()
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rice-monitor-api/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	cfg := config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.preview.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}
+
+	tests := []struct {
+		name        string
+		cfg         config.CORSConfig
+		method      string
+		origin      string
+		preflight   bool
+		code        int
+		allowOrigin string
+		credentials string
+		methods     string
+	}{
+		{
+			name: "preflight from allowed origin", cfg: cfg, method: http.MethodOptions, preflight: true,
+			origin: "https://app.example.com", code: http.StatusNoContent,
+			allowOrigin: "https://app.example.com", credentials: "true", methods: "GET, POST",
+		},
+		{
+			name: "preflight from wildcard subdomain", cfg: cfg, method: http.MethodOptions, preflight: true,
+			origin: "https://pr-12.preview.example.com", code: http.StatusNoContent,
+			allowOrigin: "https://pr-12.preview.example.com", credentials: "true", methods: "GET, POST",
+		},
+		{
+			name: "preflight from disallowed origin", cfg: cfg, method: http.MethodOptions, preflight: true,
+			origin: "https://evil.example.org", code: http.StatusNoContent,
+		},
+		{
+			name: "wildcard does not match the bare domain", cfg: cfg, method: http.MethodOptions, preflight: true,
+			origin: "https://preview.example.com", code: http.StatusNoContent,
+		},
+		{
+			name: "scheme must match", cfg: cfg, method: http.MethodGet,
+			origin: "http://app.example.com", code: http.StatusOK,
+		},
+		{
+			name: "simple request from allowed origin", cfg: cfg, method: http.MethodGet,
+			origin: "https://app.example.com", code: http.StatusOK,
+			allowOrigin: "https://app.example.com", credentials: "true",
+		},
+		{
+			name: "any origin never gets credentials", cfg: config.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			method: http.MethodGet, origin: "https://evil.example.org", code: http.StatusOK, allowOrigin: "*",
+		},
+		{
+			name: "plain OPTIONS is not a preflight", cfg: cfg, method: http.MethodOptions,
+			origin: "https://app.example.com", code: http.StatusOK,
+			allowOrigin: "https://app.example.com", credentials: "true",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(CORSMiddleware(tt.cfg))
+			router.Handle(tt.method, "/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			header := recorder.Header()
+			if recorder.Code != tt.code {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.code)
+			}
+			if got := header.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.allowOrigin)
+			}
+			if got := header.Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+				t.Errorf("got Access-Control-Allow-Credentials %q, want %q", got, tt.credentials)
+			}
+			if got := header.Get("Access-Control-Allow-Methods"); got != tt.methods {
+				t.Errorf("got Access-Control-Allow-Methods %q, want %q", got, tt.methods)
+			}
+			if header.Values("Vary")[0] != "Origin" {
+				t.Errorf("got Vary %q, want it to include Origin", header.Values("Vary"))
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+// problemTypeBase prefixes the error code to form the problem type URI,
+// relative to the API's own address
+const problemTypeBase = "/problems/"
+
+// Errors renders the last error reported with c.Error as problem details,
+// unless a response has already been written. It must run after RequestID
+// and before Recovery; RequestLogger logs the error with its cause.
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		writeErrors(c)
+	}
+}
+
+// writeErrors renders the last reported error, if any, unless a response has
+// already been written
+func writeErrors(c *gin.Context) {
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+	writeProblem(c, apperrors.From(c.Errors.Last().Err))
+}
+
+func writeProblem(c *gin.Context, err *apperrors.Error) {
+	status := err.Status()
+	title := http.StatusText(status)
+	if title == "" {
+		title = "Client Closed Request"
+	}
+
+	c.Header("Content-Type", ProblemContentType)
+	c.JSON(status, models.Problem{
+		Type:      problemTypeBase + err.Code,
+		Title:     title,
+		Status:    status,
+		Detail:    err.Message,
+		Instance:  c.Request.URL.Path,
+		Code:      err.Code,
+		RequestID: c.GetString("request_id"),
+		Errors:    err.Fields,
+	})
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/models"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		detail string
+		fields int
+	}{
+		{
+			name:   "validation",
+			err:    apperrors.Validation("invalid_request", "Request validation failed", apperrors.FieldError{Field: "name", Message: "is required"}),
+			status: http.StatusBadRequest, code: "invalid_request", detail: "Request validation failed", fields: 1,
+		},
+		{
+			name:   "missing document",
+			err:    apperrors.Lookup(status.Error(codes.NotFound, "no such document"), "Field"),
+			status: http.StatusNotFound, code: "not_found", detail: "Field not found",
+		},
+		{
+			name:   "plain error hides its message",
+			err:    errors.New("connection string with secrets"),
+			status: http.StatusInternalServerError, code: "internal_error", detail: "An unexpected error occurred",
+		},
+		{
+			name:   "client disconnected",
+			err:    apperrors.Internal("Failed to load field", context.Canceled),
+			status: apperrors.StatusClientClosedRequest, code: "request_canceled", detail: "The request was canceled",
+		},
+		{
+			name:   "deadline exceeded",
+			err:    apperrors.Lookup(status.Error(codes.DeadlineExceeded, "deadline"), "Field"),
+			status: http.StatusGatewayTimeout, code: "timeout", detail: "The request timed out",
+		},
+		{
+			name:   "dependency unavailable",
+			err:    apperrors.Internal("Failed to load field", status.Error(codes.Unavailable, "unavailable")),
+			status: http.StatusServiceUnavailable, code: "unavailable", detail: "A required service is unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(RequestID(), Errors())
+			router.GET("/fields/:id", func(c *gin.Context) { c.Error(tt.err) })
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/fields/f1", nil))
+
+			if recorder.Code != tt.status {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.status)
+			}
+			if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, ProblemContentType) {
+				t.Errorf("got Content-Type %q, want %s", got, ProblemContentType)
+			}
+
+			var problem models.Problem
+			if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Status != tt.status || problem.Code != tt.code || problem.Detail != tt.detail || len(problem.Errors) != tt.fields {
+				t.Errorf("got %+v; want status %d, code %s, detail %q, %d field errors", problem, tt.status, tt.code, tt.detail, tt.fields)
+			}
+			if problem.Type != "/problems/"+tt.code || problem.Instance != "/fields/f1" || problem.RequestID == "" {
+				t.Errorf("got type %q, instance %q, request ID %q", problem.Type, problem.Instance, problem.RequestID)
+			}
+		})
+	}
+}
+
+func TestErrorsKeepsWrittenResponse(t *testing.T) {
+	router := gin.New()
+	router.Use(Errors())
+	router.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusAccepted)
+		c.Writer.WriteHeaderNow()
+		c.Error(errors.New("cleanup failed"))
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusAccepted || recorder.Body.Len() != 0 {
+		t.Errorf("got status %d, body %q; want the handler's 202 with no body", recorder.Code, recorder.Body)
+	}
+}
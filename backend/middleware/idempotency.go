@@ -0,0 +1,308 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/config"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+
+	"cloud.google.com/go/firestore"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+type idempotencyOutcome int
+
+const (
+	idempotencyProceed idempotencyOutcome = iota
+	idempotencyReplay
+	idempotencyMismatch
+	idempotencyInProgress
+)
+
+type IdempotencyMiddleware struct {
+	firestoreService *services.FirestoreService
+	ttl              time.Duration
+	lockTimeout      time.Duration
+}
+
+func NewIdempotencyMiddleware(firestoreService *services.FirestoreService, cfg config.IdempotencyConfig) *IdempotencyMiddleware {
+	return &IdempotencyMiddleware{
+		firestoreService: firestoreService,
+		ttl:              cfg.TTL,
+		lockTimeout:      cfg.LockTimeout,
+	}
+}
+
+// Idempotent honors the Idempotency-Key header on create endpoints. The first
+// request with a given key is executed and its response stored; retries with
+// the same key and body replay that response, while retries with a different
+// body are rejected. Requests without the header pass through untouched.
+// Must run after RequireAuth so keys are scoped per user.
+func (im *IdempotencyMiddleware) Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			c.Error(apperrors.Validation("invalid_request", "Idempotency-Key must be at most 255 characters"))
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Error(apperrors.Validation("invalid_request", "Failed to read request body"))
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := c.GetString("user_id")
+		requestHash := hashRequest(c.Request.Method, c.FullPath(), c.GetHeader("Content-Type"), body)
+		docRef := im.firestoreService.IdempotencyKeys().Doc(idempotencyDocID(userID, key))
+
+		ctx, cancel := im.firestoreService.WithTimeout(c.Request.Context())
+		defer cancel()
+
+		outcome, record, err := im.reserve(ctx, docRef, &models.IdempotencyRecord{
+			Key:         key,
+			UserID:      userID,
+			Method:      c.Request.Method,
+			Path:        c.FullPath(),
+			RequestHash: requestHash,
+		})
+		if err != nil {
+			c.Error(apperrors.Internal("Failed to process idempotency key", err))
+			c.Abort()
+			return
+		}
+
+		switch outcome {
+		case idempotencyMismatch:
+			c.Error(apperrors.Unprocessable("idempotency_key_mismatch", "Idempotency-Key was already used with a different request body"))
+			c.Abort()
+			return
+		case idempotencyInProgress:
+			c.Error(apperrors.Conflict("idempotency_key_in_progress", "A request with this Idempotency-Key is still being processed"))
+			c.Abort()
+			return
+		case idempotencyReplay:
+			c.Header(IdempotencyReplayedHeader, "true")
+			c.Data(record.StatusCode, record.ContentType, record.Response)
+			c.Abort()
+			return
+		}
+
+		// A panicking handler releases the key on the way out, so a retry runs
+		// the request again instead of waiting for the lock to time out
+		finished := false
+		defer func() {
+			if !finished {
+				im.release(c, docRef)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		finished = true
+		// Render a reported error here rather than in Errors, so it is recorded
+		writeErrors(c)
+
+		// The outcome is recorded even if the client has disconnected, so a
+		// retry gets the stored response instead of running the request again
+		ctx, cancel = im.firestoreService.WithTimeout(context.WithoutCancel(c.Request.Context()))
+		defer cancel()
+
+		// Only deliberate outcomes are stored; the key is released otherwise
+		// so the client can retry
+		if !storesOutcome(recorder.Status()) {
+			im.release(c, docRef)
+			return
+		}
+
+		_, err = docRef.Update(ctx, []firestore.Update{
+			{Path: "completed", Value: true},
+			{Path: "status_code", Value: recorder.Status()},
+			{Path: "content_type", Value: recorder.Header().Get("Content-Type")},
+			{Path: "response", Value: recorder.body.Bytes()},
+		})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+// release deletes the reservation so the key can be used again, even if the
+// client has disconnected
+func (im *IdempotencyMiddleware) release(c *gin.Context, docRef *firestore.DocumentRef) {
+	ctx, cancel := im.firestoreService.WithTimeout(context.WithoutCancel(c.Request.Context()))
+	defer cancel()
+
+	if _, err := docRef.Delete(ctx); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to release idempotency key", "error", err)
+	}
+}
+
+// reserve atomically claims the key for this request or returns the stored
+// record when the key has already been used within the TTL window. A request
+// that has held the key past the lock timeout is presumed lost and the key is
+// claimed again
+func (im *IdempotencyMiddleware) reserve(ctx context.Context, docRef *firestore.DocumentRef, pending *models.IdempotencyRecord) (idempotencyOutcome, *models.IdempotencyRecord, error) {
+	outcome := idempotencyProceed
+	var existing models.IdempotencyRecord
+
+	err := im.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		outcome = idempotencyProceed
+
+		doc, err := tx.Get(docRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		now := time.Now()
+		if err == nil {
+			if err := doc.DataTo(&existing); err != nil {
+				return err
+			}
+
+			held := existing.Completed || existing.LockedUntil.After(now)
+			if existing.ExpiresAt.After(now) && held {
+				switch {
+				case existing.RequestHash != pending.RequestHash:
+					outcome = idempotencyMismatch
+				case !existing.Completed:
+					outcome = idempotencyInProgress
+				default:
+					outcome = idempotencyReplay
+				}
+				return nil
+			}
+		}
+
+		pending.CreatedAt = now
+		pending.LockedUntil = now.Add(im.lockTimeout)
+		pending.ExpiresAt = now.Add(im.ttl)
+		return tx.Set(docRef, pending)
+	})
+	if err != nil {
+		return idempotencyProceed, nil, err
+	}
+
+	return outcome, &existing, nil
+}
+
+// storesOutcome reports whether a response is the request's outcome rather
+// than a failure to complete it: server errors, timeouts, rate limiting and
+// a client that went away before the response are not
+func storesOutcome(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, apperrors.StatusClientClosedRequest:
+		return false
+	}
+	return statusCode >= http.StatusOK && statusCode < http.StatusInternalServerError
+}
+
+func idempotencyDocID(userID, key string) string {
+	sum := sha256.Sum256([]byte(userID + ":" + key))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashRequest(method, path, contentType string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	if parts, ok := hashMultipart(contentType, body); ok {
+		h.Write(parts)
+	} else {
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashMultipart hashes a multipart body by its parts rather than its raw
+// bytes, since clients pick a new random boundary for every attempt. It
+// reports false when the body is not a well-formed multipart body
+func hashMultipart(contentType string, body []byte) ([]byte, bool) {
+	if !strings.HasPrefix(contentType, "multipart/") {
+		return nil, false
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["boundary"] == "" {
+		return nil, false
+	}
+
+	h := sha256.New()
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return h.Sum(nil), true
+		}
+		if err != nil {
+			return nil, false
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, false
+		}
+		writeField(h, []byte(part.FormName()))
+		writeField(h, []byte(part.FileName()))
+		writeField(h, []byte(part.Header.Get("Content-Type")))
+		writeField(h, content)
+	}
+}
+
+// writeField writes a length-prefixed value so adjacent fields can't run
+// into each other
+func writeField(h hash.Hash, value []byte) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+	h.Write(length[:])
+	h.Write(value)
+}
+
+// responseRecorder tees the response body so it can be stored for replay
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	rr.body.Write(data)
+	return rr.ResponseWriter.Write(data)
+}
+
+func (rr *responseRecorder) WriteString(s string) (int, error) {
+	rr.body.WriteString(s)
+	return rr.ResponseWriter.WriteString(s)
+}
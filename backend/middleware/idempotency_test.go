@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/models"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// multipartBody encodes an upload with the given boundary
+func multipartBody(t *testing.T, boundary, content string) (string, []byte) {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.SetBoundary(boundary); err != nil {
+		t.Fatalf("setting boundary: %v", err)
+	}
+	if err := writer.WriteField("submission_id", "s1"); err != nil {
+		t.Fatalf("writing field: %v", err)
+	}
+	part, err := writer.CreateFormFile("image", "leaf.jpg")
+	if err != nil {
+		t.Fatalf("creating file part: %v", err)
+	}
+	part.Write([]byte(content))
+	if err := writer.Close(); err != nil {
+		t.Fatalf("closing multipart body: %v", err)
+	}
+
+	return writer.FormDataContentType(), body.Bytes()
+}
+
+func TestHashRequest(t *testing.T) {
+	contentType, body := multipartBody(t, "boundary-one", "pixels")
+	want := hashRequest(http.MethodPost, "/images/upload", contentType, body)
+
+	otherBoundaryType, otherBoundaryBody := multipartBody(t, "boundary-two", "pixels")
+	if got := hashRequest(http.MethodPost, "/images/upload", otherBoundaryType, otherBoundaryBody); got != want {
+		t.Error("same upload with another boundary hashed differently")
+	}
+
+	otherFileType, otherFileBody := multipartBody(t, "boundary-one", "other pixels")
+	if got := hashRequest(http.MethodPost, "/images/upload", otherFileType, otherFileBody); got == want {
+		t.Error("uploads of different files hashed the same")
+	}
+
+	if got := hashRequest(http.MethodPost, "/fields", contentType, body); got == want {
+		t.Error("same body on another route hashed the same")
+	}
+
+	// A malformed multipart body falls back to its raw bytes
+	malformed := []byte("not multipart")
+	if hashRequest(http.MethodPost, "/images/upload", contentType, malformed) != hashRequest(http.MethodPost, "/images/upload", "", malformed) {
+		t.Error("malformed multipart body not hashed as raw bytes")
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFirestore(t)
+	im := NewIdempotencyMiddleware(fs, config.IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute})
+	user := &models.User{ID: "u1", Role: "researcher"}
+
+	calls := 0
+	router := gin.New()
+	router.Use(gin.Recovery(), Errors(), withUser(user))
+	router.POST("/items", im.Idempotent(), func(c *gin.Context) {
+		calls++
+		if c.Query("panic") != "" {
+			panic("handler failed")
+		}
+		c.JSON(http.StatusCreated, gin.H{"call": calls})
+	})
+
+	post := func(key, contentType string, body []byte, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/items"+query, bytes.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, key)
+		req.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// seedPending stands in for a request still holding key until lockedUntil
+	seedPending := func(key string, body []byte, lockedUntil time.Time) {
+		now := time.Now()
+		_, err := fs.IdempotencyKeys().Doc(idempotencyDocID(user.ID, key)).Set(ctx, &models.IdempotencyRecord{
+			Key:         key,
+			UserID:      user.ID,
+			Method:      http.MethodPost,
+			Path:        "/items",
+			RequestHash: hashRequest(http.MethodPost, "/items", "application/json", body),
+			CreatedAt:   now,
+			LockedUntil: lockedUntil,
+			ExpiresAt:   now.Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("seeding idempotency record: %v", err)
+		}
+	}
+
+	body := []byte(`{"name":"plot"}`)
+
+	t.Run("retry replays the response", func(t *testing.T) {
+		first := post("replay", "application/json", body, "")
+		if first.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want %d", first.Code, http.StatusCreated)
+		}
+
+		retry := post("replay", "application/json", body, "")
+		if retry.Code != http.StatusCreated || retry.Header().Get(IdempotencyReplayedHeader) != "true" {
+			t.Errorf("got status %d replayed %q, want a replayed %d", retry.Code, retry.Header().Get(IdempotencyReplayedHeader), http.StatusCreated)
+		}
+		if retry.Body.String() != first.Body.String() {
+			t.Errorf("got body %s, want %s", retry.Body.String(), first.Body.String())
+		}
+	})
+
+	t.Run("different body is rejected", func(t *testing.T) {
+		recorder := post("replay", "application/json", []byte(`{"name":"other"}`), "")
+		if recorder.Code != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d, want %d", recorder.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("held key is in progress", func(t *testing.T) {
+		seedPending("held", body, time.Now().Add(time.Minute))
+
+		before := calls
+		recorder := post("held", "application/json", body, "")
+		if recorder.Code != http.StatusConflict {
+			t.Errorf("got status %d, want %d", recorder.Code, http.StatusConflict)
+		}
+		if calls != before {
+			t.Error("handler ran while the key was held")
+		}
+	})
+
+	t.Run("key past its lock timeout is taken over", func(t *testing.T) {
+		seedPending("lost", body, time.Now().Add(-time.Second))
+
+		before := calls
+		recorder := post("lost", "application/json", body, "")
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("got status %d, want %d", recorder.Code, http.StatusCreated)
+		}
+		if calls != before+1 {
+			t.Error("handler did not run for the lost request")
+		}
+	})
+
+	t.Run("panic releases the key", func(t *testing.T) {
+		recorder := post("panic", "application/json", body, "?panic=1")
+		if recorder.Code != http.StatusInternalServerError {
+			t.Fatalf("got status %d, want %d", recorder.Code, http.StatusInternalServerError)
+		}
+
+		_, err := fs.IdempotencyKeys().Doc(idempotencyDocID(user.ID, "panic")).Get(ctx)
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("got error %v, want the key released", err)
+		}
+
+		retry := post("panic", "application/json", body, "")
+		if retry.Code != http.StatusCreated || retry.Header().Get(IdempotencyReplayedHeader) != "" {
+			t.Errorf("got status %d replayed %q, want a fresh %d", retry.Code, retry.Header().Get(IdempotencyReplayedHeader), http.StatusCreated)
+		}
+	})
+
+	t.Run("upload retried with a new boundary replays", func(t *testing.T) {
+		contentType, upload := multipartBody(t, "boundary-one", "pixels")
+		if recorder := post("upload", contentType, upload, ""); recorder.Code != http.StatusCreated {
+			t.Fatalf("got status %d, want %d", recorder.Code, http.StatusCreated)
+		}
+
+		contentType, upload = multipartBody(t, "boundary-two", "pixels")
+		retry := post("upload", contentType, upload, "")
+		if retry.Header().Get(IdempotencyReplayedHeader) != "true" {
+			t.Errorf("got status %d, want the upload replayed", retry.Code)
+		}
+
+		contentType, upload = multipartBody(t, "boundary-three", "other pixels")
+		if recorder := post("upload", contentType, upload, ""); recorder.Code != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d, want %d", recorder.Code, http.StatusUnprocessableEntity)
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"rice-monitor-api/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordingHandler keeps the records logged through it
+type recordingHandler struct {
+	mu         sync.Mutex
+	records    []slog.Record
+	requestIDs []string
+}
+
+func (rh *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (rh *recordingHandler) Handle(ctx context.Context, record slog.Record) error {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	rh.records = append(rh.records, record)
+	rh.requestIDs = append(rh.requestIDs, logging.RequestID(ctx))
+	return nil
+}
+
+func (rh *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return rh }
+func (rh *recordingHandler) WithGroup(string) slog.Handler      { return rh }
+
+// recordLogs sends the default logger to a recordingHandler for the rest of
+// the test
+func recordLogs(t *testing.T) *recordingHandler {
+	handler := &recordingHandler{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return handler
+}
+
+func TestRequestID(t *testing.T) {
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("request_id")+" "+logging.RequestID(c.Request.Context()))
+	})
+
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{name: "no incoming ID", incoming: ""},
+		{name: "well-formed incoming ID", incoming: "trace-0123456789abcdef", reused: true},
+		{name: "incoming ID with a line break", incoming: "abc\ninjected"},
+		{name: "incoming ID too long", incoming: strings.Repeat("a", 129)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			requestID := recorder.Header().Get(RequestIDHeader)
+			if requestID == "" || (requestID == tt.incoming) != tt.reused {
+				t.Errorf("got request ID %q for incoming %q, want reused: %v", requestID, tt.incoming, tt.reused)
+			}
+			if body := recorder.Body.String(); body != requestID+" "+requestID {
+				t.Errorf("handler saw %q, want the response ID %q in the context and the request context", body, requestID)
+			}
+		})
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	logs := recordLogs(t)
+
+	router := gin.New()
+	router.Use(RequestID(), RequestLogger(), Errors(), Recovery())
+	router.GET("/fields/:id", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	tests := []struct {
+		path    string
+		code    int
+		level   slog.Level
+		message string
+	}{
+		{path: "/fields/f1", code: http.StatusNotFound, level: slog.LevelWarn, message: "Request handled"},
+		{path: "/panic", code: http.StatusInternalServerError, level: slog.LevelError, message: "Recovered from panic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			logs.records, logs.requestIDs = nil, nil
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if recorder.Code != tt.code {
+				t.Fatalf("got status %d, want %d", recorder.Code, tt.code)
+			}
+
+			requestID := recorder.Header().Get(RequestIDHeader)
+			found := false
+			for i, record := range logs.records {
+				if logs.requestIDs[i] != requestID {
+					t.Errorf("record %q logged with request ID %q, want %q", record.Message, logs.requestIDs[i], requestID)
+				}
+				if record.Message == tt.message && record.Level == tt.level {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("no %s record %q among %d records", tt.level, tt.message, len(logs.records))
+			}
+		})
+	}
+}
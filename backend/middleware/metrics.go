@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"time"
+
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics records the count, status and latency of every request. Requests
+// that match no route are grouped under "unmatched" so scanners cannot create
+// a series per path.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		metrics.HTTPRequestsInFlight.Inc()
+		defer metrics.HTTPRequestsInFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		metrics.HTTPRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// MetricsHandler serves the Prometheus metrics. When token is set, scrapers
+// must send it as a bearer token.
+func MetricsHandler(token string) gin.HandlerFunc {
+	handler := metrics.Handler()
+	expected := []byte("Bearer " + token)
+
+	return func(c *gin.Context) {
+		if token != "" && subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			c.Error(apperrors.Unauthorized("unauthorized", "Invalid metrics token"))
+			c.Abort()
+			return
+		}
+
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
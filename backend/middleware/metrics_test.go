@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		code          int
+	}{
+		{name: "no token configured", code: http.StatusOK},
+		{name: "matching token", token: "secret", authorization: "Bearer secret", code: http.StatusOK},
+		{name: "missing token", token: "secret", code: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", authorization: "Bearer guess", code: http.StatusUnauthorized},
+		{name: "token without scheme", token: "secret", authorization: "secret", code: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(Errors())
+			router.GET("/metrics", MetricsHandler(tt.token))
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != tt.code {
+				t.Errorf("got status %d, want %d", recorder.Code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/firestoretest"
+	"rice-monitor-api/models"
+	"rice-monitor-api/services"
+	"rice-monitor-api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+
+	provider, err := utils.NewHMACKeyProvider("test-secret", false)
+	if err != nil {
+		panic(err)
+	}
+	utils.SetKeyProvider(provider)
+}
+
+// newTestFirestore connects to an empty project on the Firestore emulator
+func newTestFirestore(t *testing.T) *services.FirestoreService {
+	t.Helper()
+
+	firestoreService, err := services.NewFirestoreService(context.Background(), firestoretest.ProjectID(t), config.Default().Datastore)
+	if err != nil {
+		t.Fatalf("connecting to the Firestore emulator: %v", err)
+	}
+	t.Cleanup(func() { firestoreService.Close() })
+
+	return firestoreService
+}
+
+// createUser stores user in Firestore
+func createUser(t *testing.T, fs *services.FirestoreService, user *models.User) *models.User {
+	t.Helper()
+
+	if _, err := fs.Users().Doc(user.ID).Set(context.Background(), user); err != nil {
+		t.Fatalf("creating user %s: %v", user.ID, err)
+	}
+	return user
+}
+
+// withUser stands in for RequireAuth, authenticating every request as user
+func withUser(user *models.User) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("user", user)
+		c.Set("user_id", user.ID)
+		c.Set("user_role", user.Role)
+		c.Next()
+	}
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// TrustProxies makes c.ClientIP take the client address from X-Forwarded-For
+// only when the request comes from one of trustedProxies, given as IPs or
+// CIDR ranges, and then the rightmost address not added by one of them.
+// Addresses the client put in the header itself are ignored, so they cannot
+// move an anonymous caller into another rate limit bucket. With no trusted
+// proxies the connection's address is used.
+func TrustProxies(router *gin.Engine, trustedProxies []string) error {
+	router.ForwardedByClientIP = true
+	router.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	return router.SetTrustedProxies(trustedProxies)
+}
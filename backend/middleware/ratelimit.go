@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"rice-monitor-api/apperrors"
+	"rice-monitor-api/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memoryStoreSweepInterval is how often idle buckets are dropped from memory
+const memoryStoreSweepInterval = time.Minute
+
+// RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, when denied
+}
+
+// RateLimitStore keeps token buckets. The in-memory store works for a single
+// instance; a shared store lets several instances enforce one limit.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit config.RateLimit) (RateLimitResult, error)
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	period    time.Duration
+}
+
+// MemoryRateLimitStore keeps token buckets in process memory
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	sweptAt   time.Time
+	nowFunc   func() time.Time
+	sweepEach time.Duration
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*tokenBucket),
+		sweptAt:   time.Now(),
+		nowFunc:   time.Now,
+		sweepEach: memoryStoreSweepInterval,
+	}
+}
+
+func (ms *MemoryRateLimitStore) Take(ctx context.Context, key string, limit config.RateLimit) (RateLimitResult, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := ms.nowFunc()
+	ms.sweep(now)
+
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Period.Seconds() // tokens per second
+
+	bucket, ok := ms.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updatedAt: now}
+		ms.buckets[key] = bucket
+	}
+	bucket.period = limit.Period
+
+	elapsed := now.Sub(bucket.updatedAt).Seconds()
+	bucket.tokens = math.Min(capacity, bucket.tokens+elapsed*rate)
+	bucket.updatedAt = now
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - bucket.tokens) / rate)
+	}
+
+	result.Remaining = int(math.Floor(bucket.tokens))
+	result.Reset = secondsToDuration((capacity - bucket.tokens) / rate)
+
+	return result, nil
+}
+
+// sweep drops buckets idle long enough to have refilled completely
+func (ms *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(ms.sweptAt) < ms.sweepEach {
+		return
+	}
+
+	for key, bucket := range ms.buckets {
+		if now.Sub(bucket.updatedAt) >= bucket.period {
+			delete(ms.buckets, key)
+		}
+	}
+	ms.sweptAt = now
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// RateLimiter applies token-bucket limits per caller: the API key or user set
+// by RequireAuth, or the client IP for unauthenticated requests
+type RateLimiter struct {
+	store RateLimitStore
+}
+
+func NewRateLimiter(store RateLimitStore) *RateLimiter {
+	return &RateLimiter{
+		store: store,
+	}
+}
+
+// Limit returns a middleware enforcing limit for a named group of routes.
+// Each group has its own buckets, so a caller limited on one group can still
+// use the others.
+func (rl *RateLimiter) Limit(group string, limit config.RateLimit) gin.HandlerFunc {
+	policy := fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Period.Seconds()))
+
+	return func(c *gin.Context) {
+		key := group + ":" + callerKey(c)
+
+		result, err := rl.store.Take(c.Request.Context(), key, limit)
+		if err != nil {
+			// A broken store should not take the API down with it
+			slog.ErrorContext(c.Request.Context(), "Rate limit store failed, allowing request", "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			c.Error(apperrors.RateLimited("Too many requests, please retry later"))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func callerKey(c *gin.Context) string {
+	if keyID := c.GetString("api_key_id"); keyID != "" {
+		return "key:" + keyID
+	}
+	if userID := c.GetString("user_id"); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryRateLimitStore()
+	store.nowFunc = func() time.Time { return now }
+
+	limiter := NewRateLimiter(store)
+	limit := config.RateLimit{Requests: 2, Period: time.Minute}
+
+	router := gin.New()
+	router.Use(Errors())
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.GET("/alice", withUser(&models.User{ID: "alice"}), limiter.Limit("api", limit), ok)
+	router.GET("/bob", withUser(&models.User{ID: "bob"}), limiter.Limit("api", limit), ok)
+	router.GET("/export", withUser(&models.User{ID: "alice"}), limiter.Limit("expensive", limit), ok)
+
+	tests := []struct {
+		name       string
+		path       string
+		advance    time.Duration
+		code       int
+		remaining  string
+		retryAfter string
+	}{
+		{name: "first request", path: "/alice", code: http.StatusNoContent, remaining: "1"},
+		{name: "second request", path: "/alice", code: http.StatusNoContent, remaining: "0"},
+		{name: "over the limit", path: "/alice", code: http.StatusTooManyRequests, remaining: "0", retryAfter: "30"},
+		{name: "other user", path: "/bob", code: http.StatusNoContent, remaining: "1"},
+		{name: "other group", path: "/export", code: http.StatusNoContent, remaining: "1"},
+		{name: "after one token refilled", path: "/alice", advance: 30 * time.Second, code: http.StatusNoContent, remaining: "0"},
+	}
+
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if recorder.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, recorder.Code, tt.code)
+		}
+		if got := recorder.Header().Get("RateLimit-Remaining"); got != tt.remaining {
+			t.Errorf("%s: got RateLimit-Remaining %q, want %q", tt.name, got, tt.remaining)
+		}
+		if got := recorder.Header().Get("Retry-After"); got != tt.retryAfter {
+			t.Errorf("%s: got Retry-After %q, want %q", tt.name, got, tt.retryAfter)
+		}
+	}
+}
+
+func TestRateLimiterIgnoresSpoofedForwardedFor(t *testing.T) {
+	limiter := NewRateLimiter(NewMemoryRateLimitStore())
+
+	router := gin.New()
+	if err := TrustProxies(router, []string{"169.254.0.0/16"}); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(Errors())
+	router.POST("/auth/login", limiter.Limit("auth", config.RateLimit{Requests: 2, Period: time.Minute}), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	// Cases run in order against the same buckets
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		code         int
+	}{
+		{name: "client behind the proxy", remoteAddr: "169.254.1.1:40000", forwardedFor: "203.0.113.7", code: http.StatusNoContent},
+		{name: "spoofed address", remoteAddr: "169.254.1.1:40001", forwardedFor: "198.51.100.1, 203.0.113.7", code: http.StatusNoContent},
+		{name: "another spoofed address", remoteAddr: "169.254.8.9:40002", forwardedFor: "198.51.100.2, 203.0.113.7", code: http.StatusTooManyRequests},
+		{name: "other client behind the proxy", remoteAddr: "169.254.1.1:40003", forwardedFor: "198.51.100.2, 203.0.113.8", code: http.StatusNoContent},
+		{name: "direct client", remoteAddr: "192.0.2.5:50000", forwardedFor: "198.51.100.3", code: http.StatusNoContent},
+		{name: "direct client spoofing", remoteAddr: "192.0.2.5:50001", forwardedFor: "198.51.100.4", code: http.StatusNoContent},
+		{name: "direct client spoofing again", remoteAddr: "192.0.2.5:50002", forwardedFor: "198.51.100.5", code: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+
+		if recorder.Code != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.name, recorder.Code, tt.code)
+		}
+	}
+}
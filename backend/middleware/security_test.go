@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rice-monitor-api/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	cfg := config.SecurityHeadersConfig{
+		ContentSecurityPolicy: "default-src 'self'",
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+		HSTSMaxAge:            24 * time.Hour,
+	}
+
+	tests := []struct {
+		name           string
+		cfg            config.SecurityHeadersConfig
+		forwardedProto string
+		want           map[string]string
+	}{
+		{
+			name: "plain HTTP",
+			cfg:  cfg,
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Content-Security-Policy":   "default-src 'self'",
+				"X-Frame-Options":           "DENY",
+				"Referrer-Policy":           "no-referrer",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name:           "HTTPS behind a proxy",
+			cfg:            cfg,
+			forwardedProto: "https",
+			want:           map[string]string{"Strict-Transport-Security": "max-age=86400; includeSubDomains"},
+		},
+		{
+			name:           "headers turned off",
+			cfg:            config.SecurityHeadersConfig{},
+			forwardedProto: "https",
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Content-Security-Policy":   "",
+				"X-Frame-Options":           "",
+				"Strict-Transport-Security": "",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(SecurityHeaders(tt.cfg))
+			router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+
+			for name, want := range tt.want {
+				if got := recorder.Header().Get(name); got != want {
+					t.Errorf("got %s %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rice-monitor-api/models"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider that keeps ended spans for the rest
+// of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return recorder
+}
+
+func TestTracing(t *testing.T) {
+	spans := recordSpans(t)
+
+	router := gin.New()
+	router.Use(RequestID(), Tracing())
+	router.GET("/fields/:id", withUser(&models.User{ID: "u1"}), func(c *gin.Context) {
+		// Work done with the request context joins the request's trace
+		_, child := otel.Tracer("test").Start(c.Request.Context(), "firestore.Get")
+		child.End()
+		c.Status(http.StatusOK)
+	})
+	router.GET("/broken", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	const remoteTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	tests := []struct {
+		name        string
+		path        string
+		traceparent string
+		spanName    string
+		status      int64
+		errorStatus bool
+		userID      string
+	}{
+		{
+			name: "continues the caller's trace", path: "/fields/f1",
+			traceparent: "00-" + remoteTraceID + "-00f067aa0ba902b7-01",
+			spanName:    "GET /fields/:id", status: http.StatusOK, userID: "u1",
+		},
+		{
+			name: "server errors mark the span", path: "/broken",
+			spanName: "GET /broken", status: http.StatusInternalServerError, errorStatus: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorded := len(spans.Ended())
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			ended := spans.Ended()[recorded:]
+			var server sdktrace.ReadOnlySpan
+			for _, span := range ended {
+				if span.SpanKind() == trace.SpanKindServer {
+					server = span
+				}
+			}
+			if server == nil {
+				t.Fatal("no server span was recorded")
+			}
+
+			if server.Name() != tt.spanName {
+				t.Errorf("got span name %q, want %q", server.Name(), tt.spanName)
+			}
+			if tt.traceparent != "" && server.SpanContext().TraceID().String() != remoteTraceID {
+				t.Errorf("got trace ID %s, want the caller's %s", server.SpanContext().TraceID(), remoteTraceID)
+			}
+			if (server.Status().Code == codes.Error) != tt.errorStatus {
+				t.Errorf("got span status %v, want error: %v", server.Status(), tt.errorStatus)
+			}
+
+			attributes := map[attribute.Key]attribute.Value{}
+			for _, kv := range server.Attributes() {
+				attributes[kv.Key] = kv.Value
+			}
+			if got := attributes["http.response.status_code"].AsInt64(); got != tt.status {
+				t.Errorf("got status code attribute %d, want %d", got, tt.status)
+			}
+			if got := attributes["enduser.id"].AsString(); got != tt.userID {
+				t.Errorf("got enduser.id %q, want %q", got, tt.userID)
+			}
+			if attributes["request_id"].AsString() == "" {
+				t.Error("span has no request_id attribute")
+			}
+
+			for _, span := range ended {
+				if span.SpanKind() != trace.SpanKindServer && span.Parent().SpanID() != server.SpanContext().SpanID() {
+					t.Errorf("span %q is not a child of the request span", span.Name())
+				}
+			}
+		})
+	}
+}
@@ -3,31 +3,231 @@ package models
 import (
 	"time"
 
+	"rice-monitor-api/apperrors"
+
 	"github.com/golang-jwt/jwt/v4"
 )
 
 // User represents a user in the system
 type User struct {
-	ID          string    `json:"id" firestore:"id"`
-	Email       string    `json:"email" firestore:"email"`
-	Name        string    `json:"name" firestore:"name"`
-	Picture     string    `json:"picture" firestore:"picture"`
-	Role        string    `json:"role" firestore:"role"` // admin, researcher, observer
-	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" firestore:"updated_at"`
-	LastLoginAt time.Time `json:"last_login_at" firestore:"last_login_at"`
+	ID          string     `json:"id" firestore:"id"`
+	Email       string     `json:"email" firestore:"email"`
+	Name        string     `json:"name" firestore:"name"`
+	Picture     string     `json:"picture" firestore:"picture"`
+	Role        string     `json:"role" firestore:"role"`     // admin, researcher, observer
+	Status      string     `json:"status" firestore:"status"` // pending, approved, suspended
+	CreatedAt   time.Time  `json:"created_at" firestore:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" firestore:"updated_at"`
+	LastLoginAt time.Time  `json:"last_login_at" firestore:"last_login_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" firestore:"deleted_at,omitempty"`
+	DeletedBy   string     `json:"deleted_by,omitempty" firestore:"deleted_by,omitempty"`
+
+	// Scopes limits the user's permissions when authenticated with an API key
+	Scopes []Permission `json:"-" firestore:"-"`
+}
+
+// User account statuses
+const (
+	UserStatusPending   = "pending"
+	UserStatusApproved  = "approved"
+	UserStatusSuspended = "suspended"
+)
+
+// IsApproved reports whether the user may use the API. Accounts created
+// before statuses existed have none and count as approved.
+func (u *User) IsApproved() bool {
+	return u.Status == "" || u.Status == UserStatusApproved
+}
+
+// ApprovalError is the error reported to a pending or suspended user
+func (u *User) ApprovalError() *apperrors.Error {
+	if u.Status == UserStatusSuspended {
+		return apperrors.Forbidden("account_suspended", "This account has been suspended")
+	}
+	return apperrors.Forbidden("account_pending", "This account is waiting for administrator approval")
+}
+
+// Permission is an action a role may perform. Permissions ending in _own
+// apply to records the user owns; their _all counterparts to any record.
+// Users can always view their own records.
+type Permission string
+
+const (
+	PermissionSubmissionCreate    Permission = "submission.create"
+	PermissionSubmissionViewAll   Permission = "submission.view_all"
+	PermissionSubmissionEditOwn   Permission = "submission.edit_own"
+	PermissionSubmissionEditAll   Permission = "submission.edit_all"
+	PermissionSubmissionDeleteOwn Permission = "submission.delete_own"
+	PermissionSubmissionDeleteAll Permission = "submission.delete_all"
+	PermissionSubmissionApprove   Permission = "submission.approve"
+	PermissionSubmissionExport    Permission = "submission.export"
+
+	PermissionFieldCreate    Permission = "field.create"
+	PermissionFieldViewAll   Permission = "field.view_all"
+	PermissionFieldEditOwn   Permission = "field.edit_own"
+	PermissionFieldEditAll   Permission = "field.edit_all"
+	PermissionFieldDeleteOwn Permission = "field.delete_own"
+	PermissionFieldDeleteAll Permission = "field.delete_all"
+
+	PermissionImageUpload    Permission = "image.upload"
+	PermissionImageDeleteOwn Permission = "image.delete_own"
+	PermissionImageDeleteAll Permission = "image.delete_all"
+
+	PermissionAnalyticsViewAll Permission = "analytics.view_all"
+
+	PermissionUserViewAll      Permission = "user.view_all"
+	PermissionUserManage       Permission = "user.manage"
+	PermissionInvitationManage Permission = "invitation.manage"
+)
+
+// observerPermissions lets a user record observations on their own fields
+var observerPermissions = []Permission{
+	PermissionSubmissionCreate,
+	PermissionSubmissionEditOwn,
+	PermissionSubmissionDeleteOwn,
+	PermissionSubmissionExport,
+	PermissionFieldCreate,
+	PermissionFieldEditOwn,
+	PermissionFieldDeleteOwn,
+	PermissionImageUpload,
+	PermissionImageDeleteOwn,
+}
+
+// researcherPermissions adds reading and reviewing everyone's data
+var researcherPermissions = append([]Permission{
+	PermissionSubmissionViewAll,
+	PermissionSubmissionApprove,
+	PermissionFieldViewAll,
+	PermissionAnalyticsViewAll,
+}, observerPermissions...)
+
+// RolePermissions maps each role to the permissions it grants
+var RolePermissions = map[string][]Permission{
+	"observer":   observerPermissions,
+	"researcher": researcherPermissions,
+	"admin": append([]Permission{
+		PermissionSubmissionEditAll,
+		PermissionSubmissionDeleteAll,
+		PermissionFieldEditAll,
+		PermissionFieldDeleteAll,
+		PermissionImageDeleteAll,
+		PermissionUserViewAll,
+		PermissionUserManage,
+		PermissionInvitationManage,
+	}, researcherPermissions...),
+}
+
+// Can reports whether the user's role grants a permission and, for API key
+// requests, the key is scoped to it
+func (u *User) Can(permission Permission) bool {
+	if u.Scopes != nil && !containsPermission(u.Scopes, permission) {
+		return false
+	}
+	return containsPermission(RolePermissions[u.Role], permission)
+}
+
+func containsPermission(permissions []Permission, permission Permission) bool {
+	for _, granted := range permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess reports whether the user may act on a record owned by ownerID,
+// either through anyPermission or through ownPermission on their own record
+func (u *User) CanAccess(ownerID string, ownPermission, anyPermission Permission) bool {
+	return u.Can(anyPermission) || (ownerID == u.ID && u.Can(ownPermission))
+}
+
+// PermissionsResponse lists what the current user's role allows
+type PermissionsResponse struct {
+	Role        string       `json:"role"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// UserRoleRequest represents a request to change a user's role
+type UserRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin researcher observer"`
+}
+
+// UserStats summarises a user's activity
+type UserStats struct {
+	UserID              string         `json:"user_id"`
+	Submissions         int            `json:"submissions"`
+	SubmissionsByStatus map[string]int `json:"submissions_by_status"`
+	Fields              int            `json:"fields"`
+	ActiveSessions      int            `json:"active_sessions"`
+	LastSubmissionAt    *time.Time     `json:"last_submission_at,omitempty"`
+	LastLoginAt         time.Time      `json:"last_login_at"`
+}
+
+// APIKey is a personal credential for scripts, sent in the X-API-Key header.
+// Only a hash of the key is stored; the key itself is shown once.
+type APIKey struct {
+	ID         string       `json:"id" firestore:"id"`
+	UserID     string       `json:"user_id" firestore:"user_id"`
+	Name       string       `json:"name" firestore:"name"`
+	Prefix     string       `json:"prefix" firestore:"prefix"` // start of the key, to recognise it
+	Scopes     []Permission `json:"scopes" firestore:"scopes"`
+	KeyHash    string       `json:"-" firestore:"key_hash"`
+	CreatedAt  time.Time    `json:"created_at" firestore:"created_at"`
+	ExpiresAt  time.Time    `json:"expires_at" firestore:"expires_at"`
+	LastUsedAt *time.Time   `json:"last_used_at,omitempty" firestore:"last_used_at,omitempty"`
+	RevokedAt  *time.Time   `json:"revoked_at,omitempty" firestore:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyRequest represents a request to create an API key
+type CreateAPIKeyRequest struct {
+	Name          string       `json:"name" binding:"required,max=100"`
+	Scopes        []Permission `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int          `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+// CreateAPIKeyResponse returns a new API key together with its secret
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Invitation lets an email address sign up with a pre-assigned role,
+// regardless of the allowed email domains
+type Invitation struct {
+	ID         string     `json:"id" firestore:"id"`
+	Email      string     `json:"email" firestore:"email"`
+	Role       string     `json:"role" firestore:"role"`
+	InvitedBy  string     `json:"invited_by" firestore:"invited_by"`
+	CreatedAt  time.Time  `json:"created_at" firestore:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at" firestore:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty" firestore:"accepted_at,omitempty"`
+	AcceptedBy string     `json:"accepted_by,omitempty" firestore:"accepted_by,omitempty"`
+}
+
+// InvitationRequest represents a request to invite a user
+type InvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=admin researcher observer"`
+}
+
+// UserStatusRequest represents a request to change a user's status
+type UserStatusRequest struct {
+	Status string `json:"status" binding:"required,oneof=pending approved suspended"`
 }
 
 // Field represents a rice field
 type Field struct {
-	ID          string    `json:"id" firestore:"id"`
-	Name        string    `json:"name" firestore:"name"`
-	Location    string    `json:"location" firestore:"location"`
-	Coordinates Location  `json:"coordinates" firestore:"coordinates"`
-	Area        float64   `json:"area" firestore:"area"` // in hectares
-	OwnerID     string    `json:"owner_id" firestore:"owner_id"`
-	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at" firestore:"updated_at"`
+	ID          string     `json:"id" firestore:"id"`
+	Name        string     `json:"name" firestore:"name"`
+	Location    string     `json:"location" firestore:"location"`
+	Coordinates Location   `json:"coordinates" firestore:"coordinates"`
+	Area        float64    `json:"area" firestore:"area"` // in hectares
+	OwnerID     string     `json:"owner_id" firestore:"owner_id"`
+	CreatedAt   time.Time  `json:"created_at" firestore:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" firestore:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" firestore:"deleted_at,omitempty"`
+	DeletedBy   string     `json:"deleted_by,omitempty" firestore:"deleted_by,omitempty"`
+	DeletedWith string     `json:"deleted_with,omitempty" firestore:"deleted_with,omitempty"` // record whose delete cascaded here
 }
 
 // Location represents GPS coordinates
@@ -52,6 +252,9 @@ type Submission struct {
 	Status            string            `json:"status" firestore:"status"` // submitted, under_review, approved, rejected
 	CreatedAt         time.Time         `json:"created_at" firestore:"created_at"`
 	UpdatedAt         time.Time         `json:"updated_at" firestore:"updated_at"`
+	DeletedAt         *time.Time        `json:"deleted_at,omitempty" firestore:"deleted_at,omitempty"`
+	DeletedBy         string            `json:"deleted_by,omitempty" firestore:"deleted_by,omitempty"`
+	DeletedWith       string            `json:"deleted_with,omitempty" firestore:"deleted_with,omitempty"` // record whose delete cascaded here
 }
 
 // TraitMeasurements represents the measurement data
@@ -62,6 +265,79 @@ type TraitMeasurements struct {
 	HillsObserved   int     `json:"hills_observed" firestore:"hills_observed"`
 }
 
+// GrowthStage is one stage of the rice growth-stage model, with the days a
+// crop typically spends in it. The final stage has no expected duration.
+type GrowthStage struct {
+	Name         string `json:"name"`
+	ExpectedDays int    `json:"expected_days"`
+}
+
+// GrowthStages lists the stages the monitoring form offers, in growth order
+var GrowthStages = []GrowthStage{
+	{Name: "Seedling", ExpectedDays: 21},
+	{Name: "Tillering", ExpectedDays: 30},
+	{Name: "Panicle Initiation", ExpectedDays: 25},
+	{Name: "Flowering", ExpectedDays: 10},
+	{Name: "Milk Stage", ExpectedDays: 10},
+	{Name: "Dough Stage", ExpectedDays: 10},
+	{Name: "Maturity", ExpectedDays: 15},
+	{Name: "Harvested"},
+}
+
+// GrowthStageIndex returns the position of a stage in GrowthStages, or -1
+// for a name outside the model
+func GrowthStageIndex(name string) int {
+	for i, stage := range GrowthStages {
+		if stage.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Names of the traits analytics report on
+const (
+	TraitCulmLength      = "culm_length"
+	TraitPanicleLength   = "panicle_length"
+	TraitPaniclesPerHill = "panicles_per_hill"
+)
+
+// Traits lists the analysed traits in display order
+var Traits = []string{TraitCulmLength, TraitPanicleLength, TraitPaniclesPerHill}
+
+// Values returns the recorded measurements by trait name. A trait left at
+// zero was not measured and is omitted.
+func (tm TraitMeasurements) Values() map[string]float64 {
+	values := make(map[string]float64)
+	if tm.CulmLength > 0 {
+		values[TraitCulmLength] = tm.CulmLength
+	}
+	if tm.PanicleLength > 0 {
+		values[TraitPanicleLength] = tm.PanicleLength
+	}
+	if tm.PaniclesPerHill > 0 {
+		values[TraitPaniclesPerHill] = float64(tm.PaniclesPerHill)
+	}
+	return values
+}
+
+// IdempotencyRecord stores the outcome of a create request so retries with
+// the same Idempotency-Key can be replayed instead of executed again
+type IdempotencyRecord struct {
+	Key         string    `json:"key" firestore:"key"`
+	UserID      string    `json:"user_id" firestore:"user_id"`
+	Method      string    `json:"method" firestore:"method"`
+	Path        string    `json:"path" firestore:"path"`
+	RequestHash string    `json:"request_hash" firestore:"request_hash"`
+	Completed   bool      `json:"completed" firestore:"completed"`
+	StatusCode  int       `json:"status_code" firestore:"status_code"`
+	ContentType string    `json:"content_type" firestore:"content_type"`
+	Response    []byte    `json:"response" firestore:"response"`
+	CreatedAt   time.Time `json:"created_at" firestore:"created_at"`
+	LockedUntil time.Time `json:"locked_until" firestore:"locked_until"`
+	ExpiresAt   time.Time `json:"expires_at" firestore:"expires_at"` // used as Firestore TTL field
+}
+
 // Request/Response DTOs
 
 // CreateSubmissionRequest represents the request payload for creating submissions
@@ -96,7 +372,25 @@ type CreateFieldRequest struct {
 
 // GoogleTokenRequest represents Google OAuth token request
 type GoogleTokenRequest struct {
-	Token string `json:"token" binding:"required"`
+	Token  string `json:"token" binding:"required"`
+	Device string `json:"device"` // optional device name shown in the session list
+}
+
+// LoginRequest represents a login with any identity provider
+type LoginRequest struct {
+	Credential string `json:"credential" binding:"required"` // ID token or magic-link token
+	Device     string `json:"device"`
+}
+
+// MagicLinkRequest represents a request for an email login link
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// LinkIdentityRequest represents a request to link another provider identity
+type LinkIdentityRequest struct {
+	Provider   string `json:"provider" binding:"required"`
+	Credential string `json:"credential" binding:"required"`
 }
 
 // RefreshTokenRequest represents refresh token request
@@ -112,10 +406,17 @@ type AuthResponse struct {
 	ExpiresIn    int64  `json:"expires_in"`
 }
 
-// ErrorResponse represents error response
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
+// Problem is an RFC 7807 problem details response. Code is stable and
+// machine-readable; Detail is meant for people and may change.
+type Problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Detail    string                 `json:"detail,omitempty"`
+	Instance  string                 `json:"instance,omitempty"`
+	Code      string                 `json:"code"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Errors    []apperrors.FieldError `json:"errors,omitempty"`
 }
 
 // SuccessResponse represents success response
@@ -127,12 +428,90 @@ type SuccessResponse struct {
 
 // JWT Claims
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email,omitempty"`
+	Role      string `json:"role,omitempty"`
+	TokenType string `json:"token_type"`    // access, refresh
+	FamilyID  string `json:"fid,omitempty"` // refresh token family the token belongs to
 	jwt.RegisteredClaims
 }
 
+// Session is one login on one device. Its ID is the refresh token family ID
+// carried by every token issued from that login.
+type Session struct {
+	ID         string     `json:"id" firestore:"id"`
+	UserID     string     `json:"user_id" firestore:"user_id"`
+	Device     string     `json:"device" firestore:"device"`
+	IPAddress  string     `json:"ip_address" firestore:"ip_address"`
+	UserAgent  string     `json:"user_agent" firestore:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at" firestore:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at" firestore:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at" firestore:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" firestore:"revoked_at,omitempty"`
+	Current    bool       `json:"current" firestore:"-"`
+}
+
+// SigningKey is a persisted JWT signing key. Keys are shared by all server
+// instances through Firestore and rotated on a schedule; retired keys stay
+// published until every token they signed has expired.
+type SigningKey struct {
+	ID         string    `json:"id" firestore:"id"` // kid header value
+	Algorithm  string    `json:"algorithm" firestore:"algorithm"`
+	PrivateKey string    `json:"-" firestore:"private_key,omitempty"` // PKCS#8 PEM, for keys stored without encryption
+	PublicKey  string    `json:"public_key" firestore:"public_key"`
+	NotBefore  time.Time `json:"not_before" firestore:"not_before"`
+	NotAfter   time.Time `json:"not_after" firestore:"not_after"` // last moment it signs tokens
+	ExpiresAt  time.Time `json:"expires_at" firestore:"expires_at"`
+	CreatedAt  time.Time `json:"created_at" firestore:"created_at"`
+
+	// EncryptedPrivateKey is the PEM private key sealed with AES-256-GCM under
+	// the key encryption key, as nonce followed by ciphertext
+	EncryptedPrivateKey []byte `json:"-" firestore:"encrypted_private_key,omitempty"`
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // OKP/EC curve
+	X   string `json:"x,omitempty"`   // OKP public key or EC x coordinate
+	Y   string `json:"y,omitempty"`   // EC y coordinate
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// RefreshToken is the server-side record of an issued refresh token. Tokens
+// issued from one login share a family; each refresh marks the presented
+// token used and issues its replacement in the same family, so presenting a
+// used token again reveals theft and revokes the whole family.
+type RefreshToken struct {
+	ID         string     `json:"id" firestore:"id"`
+	UserID     string     `json:"user_id" firestore:"user_id"`
+	FamilyID   string     `json:"family_id" firestore:"family_id"`
+	CreatedAt  time.Time  `json:"created_at" firestore:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at" firestore:"expires_at"`
+	UsedAt     *time.Time `json:"used_at,omitempty" firestore:"used_at,omitempty"`
+	ReplacedBy string     `json:"replaced_by,omitempty" firestore:"replaced_by,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" firestore:"revoked_at,omitempty"`
+}
+
+// DeleteSummary reports the records affected by a delete and the dependent
+// policy that was applied (refuse, cascade or reassign)
+type DeleteSummary struct {
+	Policy       string `json:"policy"`
+	Submissions  int    `json:"submissions"`
+	Fields       int    `json:"fields"`
+	Images       int    `json:"images"`
+	ReassignedTo string `json:"reassigned_to,omitempty"`
+}
+
 // PaginationParams represents pagination parameters
 type PaginationParams struct {
 	Page    int    `form:"page,default=1"`
@@ -141,6 +520,28 @@ type PaginationParams struct {
 	FieldID string `form:"field_id"`
 }
 
+// SubmissionAggregate holds precomputed submission counts for one scope,
+// either for the day the submissions were created on or in total
+type SubmissionAggregate struct {
+	Scope       string                    `json:"scope" firestore:"scope"`
+	Day         string                    `json:"day,omitempty" firestore:"day,omitempty"` // YYYY-MM-DD, empty for the total
+	Total       int                       `json:"total" firestore:"total"`
+	ByStatus    map[string]int            `json:"by_status" firestore:"by_status"`
+	ByStage     map[string]int            `json:"by_stage" firestore:"by_stage"`
+	ByCondition map[string]int            `json:"by_condition" firestore:"by_condition"`
+	ByField     map[string]FieldAggregate `json:"by_field" firestore:"by_field"`
+	UpdatedAt   time.Time                 `json:"updated_at" firestore:"updated_at"`
+}
+
+// FieldAggregate holds the submission counts of one field within a
+// SubmissionAggregate
+type FieldAggregate struct {
+	Total       int            `json:"total" firestore:"total"`
+	ByStage     map[string]int `json:"by_stage" firestore:"by_stage"`
+	ByCondition map[string]int `json:"by_condition" firestore:"by_condition"`
+	ByDate      map[string]int `json:"by_date" firestore:"by_date"` // by observation date, YYYY-MM-DD
+}
+
 // DashboardData represents dashboard analytics data
 type DashboardData struct {
 	TotalSubmissions    int            `json:"total_submissions"`
@@ -157,6 +558,103 @@ type TrendsData struct {
 	Period           map[string]interface{} `json:"period"`
 }
 
+// TraitStatistics describes the distribution of one trait's measurements.
+// Quartiles are interpolated between the nearest values and the standard
+// deviation is the sample one, zero for a single measurement.
+type TraitStatistics struct {
+	N      int     `json:"n"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"std_dev"`
+	Min    float64 `json:"min"`
+	Q1     float64 `json:"q1"`
+	Median float64 `json:"median"`
+	Q3     float64 `json:"q3"`
+	Max    float64 `json:"max"`
+}
+
+// TraitsReport holds trait statistics overall and grouped by field, growth
+// stage and observation month (YYYY-MM), each keyed by trait name. Traits
+// without measurements in a group are left out of it.
+type TraitsReport struct {
+	Traits      []string                              `json:"traits"`
+	Overall     map[string]TraitStatistics            `json:"overall"`
+	ByField     map[string]map[string]TraitStatistics `json:"by_field"`
+	ByStage     map[string]map[string]TraitStatistics `json:"by_stage"`
+	ByPeriod    map[string]map[string]TraitStatistics `json:"by_period"`
+	Submissions int                                   `json:"submissions"`
+	Period      map[string]interface{}                `json:"period"`
+	GeneratedAt time.Time                             `json:"generated_at"`
+}
+
+// TrendPoint is one bucket of a trend series. Value and MovingAverage are
+// nil where there is nothing to average, so charts can show the gap.
+type TrendPoint struct {
+	Bucket        string   `json:"bucket"` // first day of the bucket, YYYY-MM-DD
+	N             int      `json:"n"`      // measurements, or submissions for a condition
+	Value         *float64 `json:"value"`
+	MovingAverage *float64 `json:"moving_average"`
+}
+
+// TrendSeries is a trait's mean or a condition's prevalence over time,
+// bucketed by observation date with every bucket in the period present
+type TrendSeries struct {
+	Metric  string                 `json:"metric"` // trait name, or condition:<name>
+	Bucket  string                 `json:"bucket"` // day, week or month
+	Window  int                    `json:"window"` // buckets in the moving average
+	FieldID string                 `json:"field_id,omitempty"`
+	Points  []TrendPoint           `json:"points"`
+	Period  map[string]interface{} `json:"period"`
+}
+
+// StageTimeline follows one field through the growth stages by observation
+// date. Observations of a stage earlier than one already reached are listed
+// as regressions and do not move the timeline back.
+type StageTimeline struct {
+	FieldID      string            `json:"field_id"`
+	CurrentStage string            `json:"current_stage"`
+	Stages       []StageSpan       `json:"stages"`
+	Regressions  []StageRegression `json:"regressions"`
+	Unrecognized int               `json:"unrecognized"` // observations of stages outside the model
+	Observations int               `json:"observations"`
+}
+
+// StageSpan is the time a field spent in a stage, from its first observation
+// to the first observation of a later stage, or to the as-of date for the
+// current stage. ExpectedDays includes any stages skipped on the way to the
+// next one observed. Status is on_schedule, early or delayed for a finished
+// stage, in_progress or overdue for the current one, and complete once the
+// final stage is reached.
+type StageSpan struct {
+	Stage         string    `json:"stage"`
+	Order         int       `json:"order"`
+	FirstObserved time.Time `json:"first_observed"`
+	LastObserved  time.Time `json:"last_observed"`
+	Observations  int       `json:"observations"`
+	DaysInStage   int       `json:"days_in_stage"`
+	ExpectedDays  int       `json:"expected_days"`
+	DaysOver      int       `json:"days_over"` // days past the expected duration; negative when ahead
+	Status        string    `json:"status"`
+}
+
+// StageRegression is an observation reporting a stage earlier than the
+// field had already reached
+type StageRegression struct {
+	SubmissionID string    `json:"submission_id"`
+	Date         time.Time `json:"date"`
+	Stage        string    `json:"stage"`
+	ReachedStage string    `json:"reached_stage"`
+	ReachedOn    time.Time `json:"reached_on"`
+}
+
+// StageProgress holds the stage timelines of the fields analysed
+type StageProgress struct {
+	Stages      []GrowthStage          `json:"stages"`
+	Fields      []StageTimeline        `json:"fields"`
+	AsOf        string                 `json:"as_of"`
+	Period      map[string]interface{} `json:"period"`
+	GeneratedAt time.Time              `json:"generated_at"`
+}
+
 // ReportData represents report data
 type ReportData struct {
 	Type        string      `json:"type"`
@@ -164,8 +662,60 @@ type ReportData struct {
 	GeneratedAt time.Time   `json:"generated_at"`
 }
 
-type GoogleUserInfo struct {
-	Email   string
-	Name    string
-	Picture string
+// ExternalIdentity is an identity verified by an identity provider
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+// UserIdentity links a provider identity to a user; one user may sign in
+// through several providers
+type UserIdentity struct {
+	ID         string    `json:"id" firestore:"id"`
+	UserID     string    `json:"user_id" firestore:"user_id"`
+	Provider   string    `json:"provider" firestore:"provider"`
+	Subject    string    `json:"subject" firestore:"subject"`
+	Email      string    `json:"email" firestore:"email"`
+	CreatedAt  time.Time `json:"created_at" firestore:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at" firestore:"last_used_at"`
+}
+
+// MagicLink is a single-use email login token, stored by hash
+type MagicLink struct {
+	Email     string     `firestore:"email"`
+	CreatedAt time.Time  `firestore:"created_at"`
+	ExpiresAt time.Time  `firestore:"expires_at"`
+	UsedAt    *time.Time `firestore:"used_at,omitempty"`
+}
+
+// HealthStatus reports that the server process is alive
+type HealthStatus struct {
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DependencyCheck is the result of probing one dependency
+type DependencyCheck struct {
+	Status    string `json:"status"` // ok or error
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReadinessStatus reports whether the server can serve traffic
+type ReadinessStatus struct {
+	Status    string                     `json:"status"` // ready or not_ready
+	Checks    map[string]DependencyCheck `json:"checks"`
+	Timestamp time.Time                  `json:"timestamp"`
+}
+
+// BuildInfo identifies the running build
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/logging"
+	"rice-monitor-api/services"
+)
+
+// rebuildAggregates recomputes the analytics aggregates from the stored
+// submissions. Run it once after deploying aggregates over existing data, or
+// whenever the counts are suspected to have drifted.
+func rebuildAggregates() error {
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	logging.Setup(cfg.Logging)
+
+	ctx := context.Background()
+	firestoreService, err := services.NewFirestoreService(ctx, cfg.Google.ProjectID, cfg.Datastore)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Firestore service: %w", err)
+	}
+	defer firestoreService.Close()
+
+	started := time.Now()
+	result, err := services.NewAggregateService(firestoreService).Rebuild(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Rebuilt analytics aggregates",
+		"submissions", result.Submissions,
+		"aggregates", result.Aggregates,
+		"removed", result.Removed,
+		"duration", time.Since(started),
+	)
+	return nil
+}
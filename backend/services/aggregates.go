@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"rice-monitor-api/models"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// AggregateScopeAll holds the counts over every user's submissions
+	AggregateScopeAll = "all"
+
+	// AggregateDayLayout formats the day a daily aggregate covers
+	AggregateDayLayout = "2006-01-02"
+
+	aggregateTotalID = "total"
+	// aggregateUnknownKey replaces empty values, which cannot be map keys
+	aggregateUnknownKey = "unknown"
+)
+
+// AggregateScopeUser returns the scope holding the counts of one user's
+// submissions
+func AggregateScopeUser(userID string) string {
+	return "user_" + userID
+}
+
+// AggregateService maintains precomputed submission counts, so analytics
+// read a few documents instead of scanning every submission. Each scope has
+// one document per day submissions were created on and a running total.
+// Counts change in the same transaction as the submissions they count.
+type AggregateService struct {
+	firestoreService *FirestoreService
+}
+
+func NewAggregateService(firestoreService *FirestoreService) *AggregateService {
+	return &AggregateService{
+		firestoreService: firestoreService,
+	}
+}
+
+// AggregateDelta accumulates counter changes for any number of submission
+// changes, so a batch costs one write per aggregate document
+type AggregateDelta struct {
+	docs map[string]*aggregateChange
+}
+
+type aggregateChange struct {
+	scope    string
+	day      string
+	counters map[string]*aggregateCounter
+}
+
+type aggregateCounter struct {
+	path  firestore.FieldPath
+	delta int
+}
+
+func NewAggregateDelta() *AggregateDelta {
+	return &AggregateDelta{docs: make(map[string]*aggregateChange)}
+}
+
+// Add records a submission changing from before to after. Either may be nil
+// for a submission that is created or removed; soft-deleted submissions are
+// not counted.
+func (d *AggregateDelta) Add(before, after *models.Submission) {
+	d.count(before, -1)
+	d.count(after, 1)
+}
+
+func (d *AggregateDelta) count(submission *models.Submission, sign int) {
+	if submission == nil || submission.DeletedAt != nil {
+		return
+	}
+
+	counters := submissionCounters(submission)
+	day := submission.CreatedAt.UTC().Format(AggregateDayLayout)
+	for _, scope := range []string{AggregateScopeAll, AggregateScopeUser(submission.UserID)} {
+		for _, bucket := range []string{"", day} {
+			change := d.change(scope, bucket)
+			for _, path := range counters {
+				key := strings.Join(path, "\x00")
+				counter, ok := change.counters[key]
+				if !ok {
+					counter = &aggregateCounter{path: path}
+					change.counters[key] = counter
+				}
+				counter.delta += sign
+			}
+		}
+	}
+}
+
+func (d *AggregateDelta) change(scope, day string) *aggregateChange {
+	id := aggregateDocID(scope, day)
+	change, ok := d.docs[id]
+	if !ok {
+		change = &aggregateChange{scope: scope, day: day, counters: make(map[string]*aggregateCounter)}
+		d.docs[id] = change
+	}
+	return change
+}
+
+// data returns the document fields for the change, with each counter given
+// by value, or nil when no counter changes
+func (ch *aggregateChange) data(value func(delta int) interface{}) map[string]interface{} {
+	data := map[string]interface{}{
+		"scope":      ch.scope,
+		"updated_at": time.Now(),
+	}
+	if ch.day != "" {
+		data["day"] = ch.day
+	}
+
+	changed := false
+	for _, counter := range ch.counters {
+		if counter.delta == 0 {
+			continue
+		}
+		changed = true
+
+		node := data
+		for _, name := range counter.path[:len(counter.path)-1] {
+			child, ok := node[name].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[name] = child
+			}
+			node = child
+		}
+		node[counter.path[len(counter.path)-1]] = value(counter.delta)
+	}
+	if !changed {
+		return nil
+	}
+	return data
+}
+
+// submissionCounters lists the counters a submission adds one to. Each
+// plant condition is counted as often as it is listed.
+func submissionCounters(submission *models.Submission) []firestore.FieldPath {
+	statusKey := aggregateKey(submission.Status)
+	stage := aggregateKey(submission.GrowthStage)
+	fieldID := aggregateKey(submission.FieldID)
+
+	counters := []firestore.FieldPath{
+		{"total"},
+		{"by_status", statusKey},
+		{"by_stage", stage},
+		{"by_field", fieldID, "total"},
+		{"by_field", fieldID, "by_stage", stage},
+		{"by_field", fieldID, "by_date", submission.Date.UTC().Format(AggregateDayLayout)},
+	}
+	for _, condition := range submission.PlantConditions {
+		condition = aggregateKey(condition)
+		counters = append(counters,
+			firestore.FieldPath{"by_condition", condition},
+			firestore.FieldPath{"by_field", fieldID, "by_condition", condition},
+		)
+	}
+	return counters
+}
+
+func aggregateKey(value string) string {
+	if value == "" {
+		return aggregateUnknownKey
+	}
+	return value
+}
+
+func aggregateDocID(scope, day string) string {
+	if day == "" {
+		return scope + "_" + aggregateTotalID
+	}
+	return scope + "_" + day
+}
+
+// Apply writes the changes accumulated in delta as part of tx
+func (as *AggregateService) Apply(tx *firestore.Transaction, delta *AggregateDelta) error {
+	for id, change := range delta.docs {
+		data := change.data(func(delta int) interface{} { return firestore.Increment(delta) })
+		if data == nil {
+			continue
+		}
+		if err := tx.Set(as.firestoreService.Aggregates().Doc(id), data, firestore.MergeAll); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Total returns the running totals of scope
+func (as *AggregateService) Total(ctx context.Context, scope string) (*models.SubmissionAggregate, error) {
+	doc, err := as.firestoreService.Aggregates().Doc(aggregateDocID(scope, "")).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		// No submissions have been counted in this scope yet
+		return &models.SubmissionAggregate{Scope: scope}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var aggregate models.SubmissionAggregate
+	if err := doc.DataTo(&aggregate); err != nil {
+		return nil, err
+	}
+	return &aggregate, nil
+}
+
+// Days returns the daily aggregates of scope from the day of from to the day
+// of to, inclusive, oldest first. Days without submissions are left out.
+func (as *AggregateService) Days(ctx context.Context, scope string, from, to time.Time) ([]models.SubmissionAggregate, error) {
+	// Daily document IDs sort by day within a scope, so a range over the ID
+	// needs no composite index
+	aggregates := as.firestoreService.Aggregates()
+	iter := aggregates.
+		Where(firestore.DocumentID, ">=", aggregates.Doc(aggregateDocID(scope, from.UTC().Format(AggregateDayLayout)))).
+		Where(firestore.DocumentID, "<=", aggregates.Doc(aggregateDocID(scope, to.UTC().Format(AggregateDayLayout)))).
+		OrderBy(firestore.DocumentID, firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	var days []models.SubmissionAggregate
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return days, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var aggregate models.SubmissionAggregate
+		if err := doc.DataTo(&aggregate); err != nil {
+			return nil, err
+		}
+		// Another scope's IDs can sort into the range when a user ID has
+		// this one as its prefix
+		if aggregate.Scope != scope {
+			continue
+		}
+		days = append(days, aggregate)
+	}
+}
+
+// AggregateRebuildResult counts what a rebuild read and wrote
+type AggregateRebuildResult struct {
+	Submissions int `json:"submissions"`
+	Aggregates  int `json:"aggregates"`
+	Removed     int `json:"removed"`
+}
+
+// Rebuild recomputes every aggregate from the submissions and replaces the
+// stored ones, removing aggregates that no longer count anything.
+// Submissions written while it runs may be counted wrongly, so run it when
+// writes are quiet; running it again is safe.
+func (as *AggregateService) Rebuild(ctx context.Context) (AggregateRebuildResult, error) {
+	var result AggregateRebuildResult
+	delta := NewAggregateDelta()
+
+	iter := as.firestoreService.Submissions().Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		var submission models.Submission
+		if err := doc.DataTo(&submission); err != nil {
+			return result, err
+		}
+		if submission.DeletedAt == nil {
+			result.Submissions++
+		}
+		delta.Add(nil, &submission)
+	}
+
+	for id, change := range delta.docs {
+		data := change.data(func(delta int) interface{} { return delta })
+		if data == nil {
+			continue
+		}
+		if _, err := as.firestoreService.Aggregates().Doc(id).Set(ctx, data); err != nil {
+			return result, err
+		}
+		result.Aggregates++
+	}
+
+	stale := as.firestoreService.Aggregates().Documents(ctx)
+	defer stale.Stop()
+	for {
+		doc, err := stale.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		if _, ok := delta.docs[doc.Ref.ID]; ok {
+			continue
+		}
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+)
+
+func TestAggregateDelta(t *testing.T) {
+	created := time.Date(2026, 7, 2, 15, 0, 0, 0, time.UTC)
+	observed := time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC)
+	deletedAt := time.Date(2026, 7, 3, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		changes [][2]*models.Submission
+		// want lists the non-zero counters of the total aggregate of the
+		// "all" scope, keyed by their dotted path
+		want map[string]int
+	}{
+		{
+			name: "create",
+			changes: [][2]*models.Submission{
+				{nil, &models.Submission{UserID: "u1", FieldID: "f1", Status: "submitted", GrowthStage: "tillering", PlantConditions: []string{"healthy"}, Date: observed, CreatedAt: created}},
+			},
+			want: map[string]int{
+				"total": 1, "by_status.submitted": 1, "by_stage.tillering": 1, "by_condition.healthy": 1,
+				"by_field.f1.total": 1, "by_field.f1.by_stage.tillering": 1, "by_field.f1.by_condition.healthy": 1, "by_field.f1.by_date.2026-07-01": 1,
+			},
+		},
+		{
+			name: "status change moves one counter",
+			changes: [][2]*models.Submission{
+				{
+					&models.Submission{UserID: "u1", FieldID: "f1", Status: "submitted", GrowthStage: "tillering", Date: observed, CreatedAt: created},
+					&models.Submission{UserID: "u1", FieldID: "f1", Status: "approved", GrowthStage: "tillering", Date: observed, CreatedAt: created},
+				},
+			},
+			want: map[string]int{"by_status.submitted": -1, "by_status.approved": 1},
+		},
+		{
+			name: "soft delete",
+			changes: [][2]*models.Submission{
+				{
+					&models.Submission{UserID: "u1", FieldID: "f1", Status: "approved", GrowthStage: "heading", Date: observed, CreatedAt: created},
+					&models.Submission{UserID: "u1", FieldID: "f1", Status: "approved", GrowthStage: "heading", Date: observed, CreatedAt: created, DeletedAt: &deletedAt},
+				},
+			},
+			want: map[string]int{
+				"total": -1, "by_status.approved": -1, "by_stage.heading": -1,
+				"by_field.f1.total": -1, "by_field.f1.by_stage.heading": -1, "by_field.f1.by_date.2026-07-01": -1,
+			},
+		},
+		{
+			name: "empty values and repeated conditions",
+			changes: [][2]*models.Submission{
+				{nil, &models.Submission{UserID: "u1", PlantConditions: []string{"pests", "pests"}, Date: observed, CreatedAt: created}},
+			},
+			want: map[string]int{
+				"total": 1, "by_status.unknown": 1, "by_stage.unknown": 1, "by_condition.pests": 2,
+				"by_field.unknown.total": 1, "by_field.unknown.by_stage.unknown": 1, "by_field.unknown.by_condition.pests": 2, "by_field.unknown.by_date.2026-07-01": 1,
+			},
+		},
+		{
+			name: "changes in one batch cancel out",
+			changes: [][2]*models.Submission{
+				{nil, &models.Submission{UserID: "u1", FieldID: "f1", Status: "submitted", Date: observed, CreatedAt: created}},
+				{&models.Submission{UserID: "u1", FieldID: "f1", Status: "submitted", Date: observed, CreatedAt: created}, nil},
+			},
+			want: map[string]int{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := NewAggregateDelta()
+			for _, change := range tt.changes {
+				delta.Add(change[0], change[1])
+			}
+
+			got := map[string]int{}
+			if change, ok := delta.docs[aggregateDocID(AggregateScopeAll, "")]; ok {
+				for _, counter := range change.counters {
+					if counter.delta != 0 {
+						got[strings.Join(counter.path, ".")] = counter.delta
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got counters %v, want %v", got, tt.want)
+			}
+
+			// The user scope and the day of creation move the same way
+			for _, id := range []string{aggregateDocID(AggregateScopeUser("u1"), ""), aggregateDocID(AggregateScopeAll, "2026-07-02")} {
+				change, ok := delta.docs[id]
+				if !ok {
+					if len(tt.want) != 0 {
+						t.Errorf("no change to %s", id)
+					}
+					continue
+				}
+				for _, counter := range change.counters {
+					if counter.delta != tt.want[strings.Join(counter.path, ".")] {
+						t.Errorf("%s: %v moved by %d, want %d", id, counter.path, counter.delta, tt.want[strings.Join(counter.path, ".")])
+					}
+				}
+			}
+		})
+	}
+}
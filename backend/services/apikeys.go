@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// apiKeyPrefix marks API keys so they are recognisable in logs and scanners
+	apiKeyPrefix = "rmk_"
+	// defaultAPIKeyLifetime applies when no expiry is requested
+	defaultAPIKeyLifetime = 90 * 24 * time.Hour
+	// maxAPIKeysPerUser caps the active keys a user may hold
+	maxAPIKeysPerUser = 25
+	// apiKeyTouchInterval limits how often a key's last use is written
+	apiKeyTouchInterval = 5 * time.Minute
+)
+
+var (
+	// ErrAPIKeyInvalid is returned for unknown, expired or revoked API keys
+	ErrAPIKeyInvalid = errors.New("API key is invalid")
+	// ErrAPIKeyNotFound is returned when revoking a key the user doesn't own
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrAPIKeyLimit is returned when a user already holds the maximum number of keys
+	ErrAPIKeyLimit = errors.New("API key limit reached")
+)
+
+// APIKeyService manages personal API keys. A key has the form
+// rmk_<id>.<secret>; the document is looked up by id and the whole key is
+// compared against the stored hash.
+type APIKeyService struct {
+	firestoreService *FirestoreService
+}
+
+func NewAPIKeyService(firestoreService *FirestoreService) *APIKeyService {
+	return &APIKeyService{
+		firestoreService: firestoreService,
+	}
+}
+
+// Create issues a new key and returns it with the secret, which is not stored
+func (as *APIKeyService) Create(ctx context.Context, userID string, req models.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	active, err := as.List(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(active) >= maxAPIKeysPerUser {
+		return nil, "", ErrAPIKeyLimit
+	}
+
+	secret, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	id := strings.ReplaceAll(utils.GenerateID(), "-", "")
+	key := apiKeyPrefix + id + "." + secret
+
+	lifetime := defaultAPIKeyLifetime
+	if req.ExpiresInDays > 0 {
+		lifetime = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+
+	now := time.Now()
+	apiKey := &models.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    apiKeyPrefix + id[:8],
+		Scopes:    req.Scopes,
+		KeyHash:   utils.HashToken(key),
+		CreatedAt: now,
+		ExpiresAt: now.Add(lifetime),
+	}
+
+	if _, err := as.firestoreService.APIKeys().Doc(id).Create(ctx, apiKey); err != nil {
+		return nil, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// List returns a user's keys that are neither revoked nor expired, newest first
+func (as *APIKeyService) List(ctx context.Context, userID string) ([]models.APIKey, error) {
+	docs, err := as.firestoreService.APIKeys().Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	keys := []models.APIKey{}
+	for _, doc := range docs {
+		var apiKey models.APIKey
+		if err := doc.DataTo(&apiKey); err != nil {
+			return nil, err
+		}
+		if apiKey.RevokedAt != nil || now.After(apiKey.ExpiresAt) {
+			continue
+		}
+		keys = append(keys, apiKey)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// Revoke disables one of a user's keys
+func (as *APIKeyService) Revoke(ctx context.Context, userID, keyID string) error {
+	docRef := as.firestoreService.APIKeys().Doc(keyID)
+
+	return as.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(docRef)
+		if status.Code(err) == codes.NotFound {
+			return ErrAPIKeyNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var apiKey models.APIKey
+		if err := doc.DataTo(&apiKey); err != nil {
+			return err
+		}
+		if apiKey.UserID != userID || apiKey.RevokedAt != nil {
+			return ErrAPIKeyNotFound
+		}
+
+		return tx.Update(docRef, []firestore.Update{{Path: "revoked_at", Value: time.Now()}})
+	})
+}
+
+// Authenticate returns the key record for a presented API key and records
+// that it was used
+func (as *APIKeyService) Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	id, _, ok := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), ".")
+	if !ok || !strings.HasPrefix(key, apiKeyPrefix) || id == "" || strings.Contains(id, "/") {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	doc, err := as.firestoreService.APIKeys().Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKey models.APIKey
+	if err := doc.DataTo(&apiKey); err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(utils.HashToken(key)), []byte(apiKey.KeyHash)) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+	if apiKey.RevokedAt != nil || time.Now().After(apiKey.ExpiresAt) {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	if apiKey.LastUsedAt == nil || time.Since(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		now := time.Now()
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "last_used_at", Value: now}}); err != nil {
+			slog.WarnContext(ctx, "Failed to update API key last use", "api_key_id", apiKey.ID, "error", err)
+		} else {
+			apiKey.LastUsedAt = &now
+		}
+	}
+
+	return &apiKey, nil
+}
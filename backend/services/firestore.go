@@ -2,30 +2,41 @@ package services
 
 import (
 	"context"
-	"os"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/metrics"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type FirestoreService struct {
-	Client *firestore.Client
-	ctx    context.Context
+	Client   *firestore.Client
+	timeouts config.DatastoreConfig
 }
 
-func NewFirestoreService(ctx context.Context) (*FirestoreService, error) {
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		projectID = "rice-monitor-dev" // fallback for development
-	}
-
-	client, err := firestore.NewClient(ctx, projectID)
+// NewFirestoreService connects to Firestore and records the latency of every
+// RPC. With FIRESTORE_EMULATOR_HOST set the client library dials the emulator
+// itself and ignores the interceptors, so no latencies are recorded there.
+func NewFirestoreService(ctx context.Context, projectID string, timeouts config.DatastoreConfig) (*FirestoreService, error) {
+	client, err := firestore.NewClient(ctx, projectID,
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(observeUnaryCall)),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(observeStreamCall)),
+	)
 	if err != nil {
 		return nil, err
 	}
 
 	return &FirestoreService{
-		Client: client,
-		ctx:    ctx,
+		Client:   client,
+		timeouts: timeouts,
 	}, nil
 }
 
@@ -33,6 +44,64 @@ func (fs *FirestoreService) Close() error {
 	return fs.Client.Close()
 }
 
+// observeUnaryCall records the latency of a unary Firestore RPC
+func observeUnaryCall(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	observeCall(method, start, err)
+	return err
+}
+
+// observeStreamCall records the latency of a streaming Firestore RPC, such as
+// a query, from its start until the last response has been received. Streams
+// the caller abandons early are not recorded.
+func observeStreamCall(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		observeCall(method, start, err)
+		return nil, err
+	}
+	return &observedStream{ClientStream: stream, method: method, start: start}, nil
+}
+
+type observedStream struct {
+	grpc.ClientStream
+	method string
+	start  time.Time
+	once   sync.Once
+}
+
+func (s *observedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(func() {
+			if err == io.EOF {
+				observeCall(s.method, s.start, nil)
+			} else {
+				observeCall(s.method, s.start, err)
+			}
+		})
+	}
+	return err
+}
+
+func observeCall(method string, start time.Time, err error) {
+	metrics.FirestoreCallDuration.
+		WithLabelValues(path.Base(method), status.Code(err).String()).
+		Observe(time.Since(start).Seconds())
+}
+
+// Ping checks that Firestore is reachable by reading a document that does
+// not need to exist
+func (fs *FirestoreService) Ping(ctx context.Context) error {
+	_, err := fs.Client.Collection("_health").Doc("probe").Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
 // Collection helpers
 func (fs *FirestoreService) Users() *firestore.CollectionRef {
 	return fs.Client.Collection("users")
@@ -46,7 +115,51 @@ func (fs *FirestoreService) Fields() *firestore.CollectionRef {
 	return fs.Client.Collection("fields")
 }
 
+func (fs *FirestoreService) IdempotencyKeys() *firestore.CollectionRef {
+	return fs.Client.Collection("idempotency_keys")
+}
+
+func (fs *FirestoreService) RefreshTokens() *firestore.CollectionRef {
+	return fs.Client.Collection("refresh_tokens")
+}
+
+func (fs *FirestoreService) Sessions() *firestore.CollectionRef {
+	return fs.Client.Collection("sessions")
+}
+
+func (fs *FirestoreService) SigningKeys() *firestore.CollectionRef {
+	return fs.Client.Collection("signing_keys")
+}
+
+func (fs *FirestoreService) Identities() *firestore.CollectionRef {
+	return fs.Client.Collection("identities")
+}
+
+func (fs *FirestoreService) MagicLinks() *firestore.CollectionRef {
+	return fs.Client.Collection("magic_links")
+}
+
+func (fs *FirestoreService) Invitations() *firestore.CollectionRef {
+	return fs.Client.Collection("invitations")
+}
+
+func (fs *FirestoreService) APIKeys() *firestore.CollectionRef {
+	return fs.Client.Collection("api_keys")
+}
+
+func (fs *FirestoreService) Aggregates() *firestore.CollectionRef {
+	return fs.Client.Collection("analytics_aggregates")
+}
+
 // Context getter
-func (fs *FirestoreService) Context() context.Context {
-	return fs.ctx
+// WithTimeout bounds the Firestore work done for parent, usually a request
+// context, so it stops when the client disconnects or the timeout passes
+func (fs *FirestoreService) WithTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, fs.timeouts.OperationTimeout)
+}
+
+// WithScanTimeout is WithTimeout for work that reads whole collections,
+// such as exports, analytics and cascading deletes
+func (fs *FirestoreService) WithScanTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, fs.timeouts.ScanTimeout)
 }
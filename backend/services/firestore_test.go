@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/firestoretest"
+	"rice-monitor-api/utils"
+)
+
+func init() {
+	// Tests sign tokens with a shared secret unless they set up keys of their own
+	provider, err := utils.NewHMACKeyProvider("test-secret", false)
+	if err != nil {
+		panic(err)
+	}
+	utils.SetKeyProvider(provider)
+}
+
+// newTestFirestore connects to an empty project on the Firestore emulator
+func newTestFirestore(t *testing.T) *FirestoreService {
+	t.Helper()
+
+	firestoreService, err := NewFirestoreService(context.Background(), firestoretest.ProjectID(t), config.Default().Datastore)
+	if err != nil {
+		t.Fatalf("connecting to the Firestore emulator: %v", err)
+	}
+	t.Cleanup(func() { firestoreService.Close() })
+
+	return firestoreService
+}
+
+func TestWithTimeout(t *testing.T) {
+	timeouts := config.DatastoreConfig{
+		OperationTimeout: time.Second,
+		ScanTimeout:      time.Minute,
+		UploadTimeout:    time.Hour,
+	}
+	firestoreService := &FirestoreService{timeouts: timeouts}
+	storageService := &StorageService{timeouts: timeouts}
+
+	tests := []struct {
+		name        string
+		withTimeout func(context.Context) (context.Context, context.CancelFunc)
+		want        time.Duration
+	}{
+		{name: "firestore operation", withTimeout: firestoreService.WithTimeout, want: time.Second},
+		{name: "firestore scan", withTimeout: firestoreService.WithScanTimeout, want: time.Minute},
+		{name: "storage operation", withTimeout: storageService.WithTimeout, want: time.Second},
+		{name: "storage upload", withTimeout: storageService.WithUploadTimeout, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.withTimeout(context.Background())
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("context has no deadline")
+			}
+			if got := time.Until(deadline); got < tt.want-time.Second/10 || got > tt.want {
+				t.Errorf("got a deadline %v away, want %v", got, tt.want)
+			}
+
+			// A client disconnect cancels the work before the deadline
+			parent, disconnect := context.WithCancel(context.Background())
+			ctx, cancel = tt.withTimeout(parent)
+			defer cancel()
+			disconnect()
+			if ctx.Err() != context.Canceled {
+				t.Errorf("got %v after the parent was cancelled, want context.Canceled", ctx.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+
+	"google.golang.org/api/idtoken"
+)
+
+// ErrInvalidCredential is returned when a provider rejects a credential
+var ErrInvalidCredential = errors.New("invalid credential")
+
+// IdentityProvider verifies a credential presented at login, such as an ID
+// token or a magic-link token, and returns the identity it proves.
+// LinksByEmail reports whether the provider's verified emails are trusted to
+// claim the existing account with the same address on a first login.
+type IdentityProvider interface {
+	Name() string
+	Authenticate(ctx context.Context, credential string) (*models.ExternalIdentity, error)
+	LinksByEmail() bool
+}
+
+// GoogleIdentityProvider verifies Google Sign-In ID tokens
+type GoogleIdentityProvider struct {
+	clientID string
+}
+
+func NewGoogleIdentityProvider(clientID string) *GoogleIdentityProvider {
+	return &GoogleIdentityProvider{
+		clientID: clientID,
+	}
+}
+
+func (gp *GoogleIdentityProvider) Name() string {
+	return "google"
+}
+
+// LinksByEmail is true for Google, which accounts created before identities
+// existed signed in with
+func (gp *GoogleIdentityProvider) LinksByEmail() bool {
+	return true
+}
+
+func (gp *GoogleIdentityProvider) Authenticate(ctx context.Context, credential string) (*models.ExternalIdentity, error) {
+	payload, err := idtoken.Validate(ctx, credential, gp.clientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+
+	email, _ := payload.Claims["email"].(string)
+	emailVerified, _ := payload.Claims["email_verified"].(bool)
+	name, _ := payload.Claims["name"].(string)
+	picture, _ := payload.Claims["picture"].(string)
+
+	return &models.ExternalIdentity{
+		Provider:      gp.Name(),
+		Subject:       payload.Subject,
+		Email:         email,
+		EmailVerified: emailVerified,
+		Name:          name,
+		Picture:       picture,
+	}, nil
+}
+
+// NewIdentityProviders builds the enabled identity providers. Google is
+// always enabled; each configured OIDC provider adds a generic OIDC provider;
+// email magic links are enabled when magicLinks is not nil.
+func NewIdentityProviders(googleClientID string, oidcProviders []config.OIDCProviderConfig, magicLinks *MagicLinkService) (map[string]IdentityProvider, error) {
+	providers := make(map[string]IdentityProvider)
+
+	google := NewGoogleIdentityProvider(googleClientID)
+	providers[google.Name()] = google
+
+	for _, provider := range oidcProviders {
+		if _, exists := providers[provider.Name]; exists {
+			return nil, fmt.Errorf("identity provider %q is configured twice", provider.Name)
+		}
+
+		providers[provider.Name] = NewOIDCIdentityProvider(OIDCConfig{
+			Name:        provider.Name,
+			Issuer:      provider.Issuer,
+			ClientID:    provider.ClientID,
+			JWKSURL:     provider.JWKSURL,
+			LinkByEmail: provider.LinkByEmail,
+		})
+	}
+
+	if magicLinks != nil {
+		providers[magicLinks.Name()] = magicLinks
+	}
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	slog.Info("Identity providers enabled", "providers", names)
+
+	return providers, nil
+}
+
+// IdentityDocID returns the identities document ID for a provider subject
+func IdentityDocID(provider, subject string) string {
+	return utils.HashToken(provider + ":" + subject)
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// keyRefreshInterval is how often the key set is reloaded and rotated
+	keyRefreshInterval = 5 * time.Minute
+	// unknownKidRefreshInterval rate-limits reloads triggered by unknown kids
+	unknownKidRefreshInterval = 30 * time.Second
+)
+
+// loadedKey is a parsed signing key
+type loadedKey struct {
+	record     models.SigningKey
+	method     jwt.SigningMethod
+	privateKey crypto.Signer
+	publicKey  crypto.PublicKey
+}
+
+// KeyService manages asymmetric JWT signing keys. Time is divided into
+// rotation periods and each period has its own key, stored in Firestore under
+// a deterministic kid so every instance agrees on it. The next period's key
+// is created ahead of time, so verifiers that cache the JWKS already know it
+// when it starts signing. With an encryption key, private keys are stored
+// encrypted so reading Firestore or its backups is not enough to sign
+// tokens. It implements utils.KeyProvider.
+type KeyService struct {
+	firestoreService *FirestoreService
+	algorithm        string
+	rotation         time.Duration
+	sealer           cipher.AEAD
+
+	mu          sync.RWMutex
+	keys        map[string]*loadedKey
+	refreshedAt time.Time
+
+	wg sync.WaitGroup
+}
+
+// NewKeyService loads the signing keys. encryptionKey is a 32-byte AES key;
+// without one, new private keys are stored unencrypted.
+func NewKeyService(ctx context.Context, firestoreService *FirestoreService, algorithm string, rotation time.Duration, encryptionKey []byte) (*KeyService, error) {
+	if algorithm != jwt.SigningMethodRS256.Alg() && algorithm != jwt.SigningMethodEdDSA.Alg() {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q (use RS256 or EdDSA)", algorithm)
+	}
+	if rotation <= 0 {
+		return nil, fmt.Errorf("JWT key rotation interval must be positive")
+	}
+
+	ks := &KeyService{
+		firestoreService: firestoreService,
+		algorithm:        algorithm,
+		rotation:         rotation,
+		keys:             make(map[string]*loadedKey),
+	}
+
+	if encryptionKey != nil {
+		block, err := aes.NewCipher(encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key encryption key: %w", err)
+		}
+		if ks.sealer, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ks.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Start rotates and reloads keys in the background until ctx is cancelled
+func (ks *KeyService) Start(ctx context.Context) {
+	ks.wg.Add(1)
+	go func() {
+		defer ks.wg.Done()
+
+		ticker := time.NewTicker(keyRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ks.refresh(ctx); err != nil {
+					slog.ErrorContext(ctx, "Failed to refresh signing keys", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Wait blocks until the background refresh started by Start has stopped
+func (ks *KeyService) Wait() {
+	ks.wg.Wait()
+}
+
+func (ks *KeyService) SigningKey() (*utils.SigningKey, error) {
+	kid := ks.keyID(ks.period(time.Now()))
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+
+	if !ok {
+		// A new rotation period started since the last refresh. The keys are
+		// shared by all requests, so the refresh does not use any one
+		// request's context.
+		ctx, cancel := ks.firestoreService.WithTimeout(context.Background())
+		defer cancel()
+		if err := ks.refresh(ctx); err != nil {
+			return nil, err
+		}
+
+		ks.mu.RLock()
+		key, ok = ks.keys[kid]
+		ks.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("signing key %s is not available", kid)
+		}
+	}
+
+	return &utils.SigningKey{ID: kid, Method: key.method, Key: key.privateKey}, nil
+}
+
+func (ks *KeyService) VerificationKey(kid string) (jwt.SigningMethod, interface{}, error) {
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	stale := time.Since(ks.refreshedAt) > unknownKidRefreshInterval
+	ks.mu.RUnlock()
+
+	if !ok && stale {
+		// Another instance may have created a key this one hasn't loaded yet
+		ctx, cancel := ks.firestoreService.WithTimeout(context.Background())
+		defer cancel()
+		if err := ks.refresh(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		ks.mu.RLock()
+		key, ok = ks.keys[kid]
+		ks.mu.RUnlock()
+	}
+
+	if !ok || time.Now().After(key.record.ExpiresAt) {
+		return nil, nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	return key.method, key.publicKey, nil
+}
+
+func (ks *KeyService) PublicKeys() []models.JWK {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	now := time.Now()
+	jwks := []models.JWK{}
+	for _, key := range ks.keys {
+		if now.After(key.record.ExpiresAt) {
+			continue
+		}
+
+		jwk := models.JWK{
+			Kid: key.record.ID,
+			Alg: key.record.Algorithm,
+			Use: "sig",
+		}
+
+		switch publicKey := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		default:
+			continue
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
+
+// refresh makes sure the current and next period keys exist, then reloads
+// every key that can still verify tokens and deletes expired ones
+func (ks *KeyService) refresh(ctx context.Context) error {
+	period := ks.period(time.Now())
+	for _, p := range []int64{period, period + 1} {
+		if err := ks.ensureKey(ctx, p); err != nil {
+			return err
+		}
+	}
+
+	docs, err := ks.firestoreService.SigningKeys().Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	keys := make(map[string]*loadedKey)
+	for _, doc := range docs {
+		var record models.SigningKey
+		if err := doc.DataTo(&record); err != nil {
+			return err
+		}
+
+		if now.After(record.ExpiresAt) {
+			if _, err := doc.Ref.Delete(ctx); err != nil {
+				slog.WarnContext(ctx, "Failed to delete expired signing key", "kid", record.ID, "error", err)
+			}
+			continue
+		}
+
+		key, err := ks.parseSigningKey(record)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", record.ID, err)
+		}
+		keys[record.ID] = key
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.refreshedAt = now
+	ks.mu.Unlock()
+
+	return nil
+}
+
+// ensureKey creates the key for a rotation period unless another instance
+// already has
+func (ks *KeyService) ensureKey(ctx context.Context, period int64) error {
+	kid := ks.keyID(period)
+	docRef := ks.firestoreService.SigningKeys().Doc(kid)
+
+	if _, err := docRef.Get(ctx); err == nil {
+		return nil
+	} else if status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	record, err := ks.generateKey(kid, period)
+	if err != nil {
+		return err
+	}
+
+	if _, err := docRef.Create(ctx, record); err != nil && status.Code(err) != codes.AlreadyExists {
+		return err
+	}
+
+	return nil
+}
+
+func (ks *KeyService) generateKey(kid string, period int64) (*models.SigningKey, error) {
+	var privateKey crypto.Signer
+	var err error
+
+	switch ks.algorithm {
+	case jwt.SigningMethodEdDSA.Alg():
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Unix(0, 0).Add(time.Duration(period) * ks.rotation)
+	notAfter := notBefore.Add(ks.rotation)
+
+	record := &models.SigningKey{
+		ID:        kid,
+		Algorithm: ks.algorithm,
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+		// Tokens signed at the last moment must stay verifiable until they expire
+		ExpiresAt: notAfter.Add(utils.RefreshTokenTTL),
+		CreatedAt: time.Now(),
+	}
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	if ks.sealer == nil {
+		record.PrivateKey = string(privatePEM)
+		return record, nil
+	}
+
+	// The kid is authenticated with the key, so a sealed key cannot be copied
+	// into another record
+	nonce := make([]byte, ks.sealer.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	record.EncryptedPrivateKey = ks.sealer.Seal(nonce, nonce, privatePEM, []byte(kid))
+	return record, nil
+}
+
+// privateKeyPEM returns the PEM private key of record, decrypting it if it
+// was stored encrypted. Keys stored before encryption was configured load
+// as they are until they expire.
+func (ks *KeyService) privateKeyPEM(record models.SigningKey) ([]byte, error) {
+	if len(record.EncryptedPrivateKey) == 0 {
+		return []byte(record.PrivateKey), nil
+	}
+	if ks.sealer == nil {
+		return nil, fmt.Errorf("private key is encrypted but no key encryption key is configured")
+	}
+
+	nonceSize := ks.sealer.NonceSize()
+	if len(record.EncryptedPrivateKey) < nonceSize {
+		return nil, fmt.Errorf("encrypted private key is truncated")
+	}
+	nonce, sealed := record.EncryptedPrivateKey[:nonceSize], record.EncryptedPrivateKey[nonceSize:]
+	privatePEM, err := ks.sealer.Open(nil, nonce, sealed, []byte(record.ID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting private key: %w", err)
+	}
+	return privatePEM, nil
+}
+
+func (ks *KeyService) period(t time.Time) int64 {
+	return t.UnixNano() / int64(ks.rotation)
+}
+
+func (ks *KeyService) keyID(period int64) string {
+	return fmt.Sprintf("%s-%d", strings.ToLower(ks.algorithm), period)
+}
+
+func (ks *KeyService) parseSigningKey(record models.SigningKey) (*loadedKey, error) {
+	privatePEM, err := ks.privateKeyPEM(record)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(privatePEM)
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key PEM")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+
+	var method jwt.SigningMethod
+	switch signer.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", signer)
+	}
+
+	if method.Alg() != record.Algorithm {
+		return nil, fmt.Errorf("key type does not match algorithm %s", record.Algorithm)
+	}
+
+	return &loadedKey{
+		record:     record,
+		method:     method,
+		privateKey: signer,
+		publicKey:  signer.Public(),
+	}, nil
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testKeyEncryptionKey seals the private keys the tests store
+var testKeyEncryptionKey = bytes.Repeat([]byte{7}, 32)
+
+func TestKeyServiceRotation(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		kty       string
+	}{
+		{algorithm: "RS256", kty: "RSA"},
+		{algorithm: "EdDSA", kty: "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			fs := newTestFirestore(t)
+			ks, err := NewKeyService(context.Background(), fs, tt.algorithm, time.Hour, testKeyEncryptionKey)
+			if err != nil {
+				t.Fatalf("NewKeyService: %v", err)
+			}
+
+			// The current and the next period's key are published
+			jwks := ks.PublicKeys()
+			if len(jwks) != 2 {
+				t.Fatalf("got %d public keys, want 2", len(jwks))
+			}
+			for _, jwk := range jwks {
+				if jwk.Kty != tt.kty || jwk.Alg != tt.algorithm || jwk.Use != "sig" {
+					t.Errorf("unexpected JWK %+v", jwk)
+				}
+			}
+
+			signingKey, err := ks.SigningKey()
+			if err != nil {
+				t.Fatalf("SigningKey: %v", err)
+			}
+			if want := ks.keyID(ks.period(time.Now())); signingKey.ID != want {
+				t.Errorf("signing with %s, want the current period's key %s", signingKey.ID, want)
+			}
+
+			// Another instance sharing the key collection signs with the same key
+			other, err := NewKeyService(context.Background(), fs, tt.algorithm, time.Hour, testKeyEncryptionKey)
+			if err != nil {
+				t.Fatalf("NewKeyService: %v", err)
+			}
+			token := jwt.NewWithClaims(signingKey.Method, jwt.RegisteredClaims{Subject: "u1"})
+			token.Header["kid"] = signingKey.ID
+			signed, err := token.SignedString(signingKey.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+				_, publicKey, err := other.VerificationKey(token.Header["kid"].(string))
+				return publicKey, err
+			})
+			if err != nil {
+				t.Errorf("token signed by one instance does not verify on another: %v", err)
+			}
+
+			if _, _, err := ks.VerificationKey("unknown"); err == nil {
+				t.Error("unknown kid was accepted")
+			}
+		})
+	}
+}
+
+func TestKeyServiceSignsTokens(t *testing.T) {
+	fs := newTestFirestore(t)
+	ks, err := NewKeyService(context.Background(), fs, "EdDSA", time.Hour, testKeyEncryptionKey)
+	if err != nil {
+		t.Fatalf("NewKeyService: %v", err)
+	}
+
+	utils.SetKeyProvider(ks)
+	t.Cleanup(func() {
+		provider, _ := utils.NewHMACKeyProvider("test-secret", false)
+		utils.SetKeyProvider(provider)
+	})
+
+	token, err := utils.GenerateAccessToken(&models.User{ID: "u1", Role: "researcher"}, "s1")
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	claims, err := utils.ValidateAccessToken(token)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken: %v", err)
+	}
+	if claims.UserID != "u1" || claims.FamilyID != "s1" {
+		t.Errorf("got claims %+v", claims)
+	}
+}
+
+func TestKeyServiceStopsOnCancel(t *testing.T) {
+	fs := newTestFirestore(t)
+	ks, err := NewKeyService(context.Background(), fs, "EdDSA", time.Hour, testKeyEncryptionKey)
+	if err != nil {
+		t.Fatalf("NewKeyService: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ks.Start(ctx)
+	cancel()
+
+	waitStopped(t, ks.Wait)
+}
+
+func TestKeyServiceEncryptsKeys(t *testing.T) {
+	fs := newTestFirestore(t)
+	if _, err := NewKeyService(context.Background(), fs, "EdDSA", time.Hour, testKeyEncryptionKey); err != nil {
+		t.Fatalf("NewKeyService: %v", err)
+	}
+
+	docs, err := fs.SigningKeys().Documents(context.Background()).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range docs {
+		var record models.SigningKey
+		if err := doc.DataTo(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record.PrivateKey != "" || len(record.EncryptedPrivateKey) == 0 || bytes.Contains(record.EncryptedPrivateKey, []byte("PRIVATE KEY")) {
+			t.Errorf("key %s is stored unencrypted", record.ID)
+		}
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+	}{
+		{name: "another encryption key", key: bytes.Repeat([]byte{8}, 32)},
+		{name: "no encryption key", key: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyService(context.Background(), fs, "EdDSA", time.Hour, tt.key); err == nil {
+				t.Error("loaded keys sealed under a different encryption key")
+			}
+		})
+	}
+}
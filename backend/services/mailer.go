@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/smtp"
+	"strings"
+
+	"rice-monitor-api/config"
+)
+
+// Mailer sends plain-text email
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// NewMailer returns an SMTP mailer when an SMTP host is configured, a mailer
+// that only logs messages in development, and nil otherwise
+func NewMailer(cfg config.SMTPConfig, development bool) Mailer {
+	if cfg.Host != "" {
+		return &SMTPMailer{
+			host:     cfg.Host,
+			port:     cfg.Port,
+			username: cfg.Username,
+			password: cfg.Password,
+			from:     cfg.From,
+		}
+	}
+
+	if development {
+		return &LogMailer{}
+	}
+
+	return nil
+}
+
+// SMTPMailer sends email through an SMTP relay
+type SMTPMailer struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func (sm *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	var auth smtp.Auth
+	if sm.username != "" {
+		auth = smtp.PlainAuth("", sm.username, sm.password, sm.host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + sm.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(sm.host+":"+sm.port, auth, sm.from, []string{to}, []byte(message)); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+
+	return nil
+}
+
+// LogMailer writes messages to the log instead of sending them. It is only
+// used in development.
+type LogMailer struct{}
+
+func (lm *LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	slog.InfoContext(ctx, "Email not sent, logged for development", "to", to, "subject", subject, "body", body)
+	return nil
+}
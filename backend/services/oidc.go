@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"rice-monitor-api/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwksRefetchInterval rate-limits JWKS fetches triggered by unknown kids
+const jwksRefetchInterval = 30 * time.Second
+
+// OIDCConfig configures a generic OpenID Connect identity provider. When
+// JWKSURL is empty it is discovered from the issuer's
+// /.well-known/openid-configuration document.
+type OIDCConfig struct {
+	Name        string
+	Issuer      string
+	ClientID    string
+	JWKSURL     string
+	LinkByEmail bool
+}
+
+// OIDCIdentityProvider verifies ID tokens issued by any OpenID Connect
+// provider, including a local stub issuer during development
+type OIDCIdentityProvider struct {
+	config     OIDCConfig
+	httpClient *http.Client
+
+	mu        sync.RWMutex
+	jwksURL   string
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func NewOIDCIdentityProvider(config OIDCConfig) *OIDCIdentityProvider {
+	return &OIDCIdentityProvider{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		jwksURL:    config.JWKSURL,
+		keys:       make(map[string]interface{}),
+	}
+}
+
+func (op *OIDCIdentityProvider) Name() string {
+	return op.config.Name
+}
+
+// LinksByEmail is opt-in, since any OIDC issuer can vouch for any address
+func (op *OIDCIdentityProvider) LinksByEmail() bool {
+	return op.config.LinkByEmail
+}
+
+func (op *OIDCIdentityProvider) Authenticate(ctx context.Context, credential string) (*models.ExternalIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(credential, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		return op.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+
+	// MapClaims only checks exp when present; ID tokens must always have one
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: missing or expired exp", ErrInvalidCredential)
+	}
+	if !claims.VerifyIssuer(op.config.Issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidCredential)
+	}
+	if !claims.VerifyAudience(op.config.ClientID, true) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidCredential)
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidCredential)
+	}
+
+	email, _ := claims["email"].(string)
+	name, _ := claims["name"].(string)
+	picture, _ := claims["picture"].(string)
+
+	// Some providers encode email_verified as a string
+	emailVerified := false
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		emailVerified = verified
+	case string:
+		emailVerified = verified == "true"
+	}
+
+	return &models.ExternalIdentity{
+		Provider:      op.config.Name,
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: emailVerified,
+		Name:          name,
+		Picture:       picture,
+	}, nil
+}
+
+// key returns the verification key for kid, refetching the JWKS when the
+// provider may have rotated its keys
+func (op *OIDCIdentityProvider) key(ctx context.Context, kid string) (interface{}, error) {
+	op.mu.RLock()
+	key, ok := op.keys[kid]
+	stale := time.Since(op.fetchedAt) > jwksRefetchInterval
+	op.mu.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	if err := op.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	op.mu.RLock()
+	key, ok = op.keys[kid]
+	op.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	return key, nil
+}
+
+func (op *OIDCIdentityProvider) fetchKeys(ctx context.Context) error {
+	jwksURL, err := op.discoverJWKSURL(ctx)
+	if err != nil {
+		return err
+	}
+
+	var jwks models.JWKS
+	if err := op.getJSON(ctx, jwksURL, &jwks); err != nil {
+		return fmt.Errorf("fetching JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	op.mu.Lock()
+	op.keys = keys
+	op.fetchedAt = time.Now()
+	op.mu.Unlock()
+
+	return nil
+}
+
+func (op *OIDCIdentityProvider) discoverJWKSURL(ctx context.Context) (string, error) {
+	op.mu.RLock()
+	jwksURL := op.jwksURL
+	op.mu.RUnlock()
+	if jwksURL != "" {
+		return jwksURL, nil
+	}
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	discoveryURL := strings.TrimSuffix(op.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := op.getJSON(ctx, discoveryURL, &discovery); err != nil {
+		return "", fmt.Errorf("fetching OIDC discovery document: %w", err)
+	}
+	// The document must describe the configured issuer, or its keys could
+	// vouch for tokens from another one
+	if discovery.Issuer != op.config.Issuer {
+		return "", fmt.Errorf("OIDC discovery document for %s names issuer %q", op.config.Issuer, discovery.Issuer)
+	}
+	if discovery.JWKSURI == "" {
+		return "", fmt.Errorf("OIDC discovery document for %s has no jwks_uri", op.config.Issuer)
+	}
+
+	op.mu.Lock()
+	op.jwksURL = discovery.JWKSURI
+	op.mu.Unlock()
+
+	return discovery.JWKSURI, nil
+}
+
+func (op *OIDCIdentityProvider) getJSON(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := op.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// parseJWK converts an RSA or EC JSON Web Key into a public key
+func parseJWK(jwk models.JWK) (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
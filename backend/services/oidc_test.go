@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// oidcStub is an OpenID Connect issuer serving discovery and a JWKS. The
+// discovery document names discoveryIssuer when set, or the stub itself.
+type oidcStub struct {
+	server          *httptest.Server
+	key             *rsa.PrivateKey
+	discoveryIssuer string
+}
+
+func newOIDCStub(t *testing.T) *oidcStub {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &oidcStub{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := stub.server.URL
+		if stub.discoveryIssuer != "" {
+			issuer = stub.discoveryIssuer
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer,
+			"jwks_uri": stub.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.JWKS{Keys: []models.JWK{{
+			Kty: "RSA",
+			Kid: "stub-key",
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+
+	return stub
+}
+
+// idToken signs claims with the stub's key
+func (s *oidcStub) idToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "stub-key"
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	stub := newOIDCStub(t)
+	provider := NewOIDCIdentityProvider(OIDCConfig{Name: "stub", Issuer: stub.server.URL, ClientID: "rice-monitor"})
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			claims: jwt.MapClaims{
+				"iss": stub.server.URL, "aud": "rice-monitor", "sub": "s1", "exp": now.Add(time.Hour).Unix(),
+				"email": "farmer@example.com", "email_verified": true,
+			},
+		},
+		{
+			name:    "expired",
+			claims:  jwt.MapClaims{"iss": stub.server.URL, "aud": "rice-monitor", "sub": "s1", "exp": now.Add(-time.Minute).Unix()},
+			wantErr: true,
+		},
+		{
+			name:    "missing exp",
+			claims:  jwt.MapClaims{"iss": stub.server.URL, "aud": "rice-monitor", "sub": "s1"},
+			wantErr: true,
+		},
+		{
+			name:    "wrong audience",
+			claims:  jwt.MapClaims{"iss": stub.server.URL, "aud": "another-app", "sub": "s1", "exp": now.Add(time.Hour).Unix()},
+			wantErr: true,
+		},
+		{
+			name:    "wrong issuer",
+			claims:  jwt.MapClaims{"iss": "https://attacker.example.com", "aud": "rice-monitor", "sub": "s1", "exp": now.Add(time.Hour).Unix()},
+			wantErr: true,
+		},
+		{
+			name:    "missing subject",
+			claims:  jwt.MapClaims{"iss": stub.server.URL, "aud": "rice-monitor", "exp": now.Add(time.Hour).Unix()},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := provider.Authenticate(context.Background(), stub.idToken(t, tt.claims))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCredential) {
+					t.Errorf("got identity %+v, error %v; want ErrInvalidCredential", identity, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+
+			want := models.ExternalIdentity{Provider: "stub", Subject: "s1", Email: "farmer@example.com", EmailVerified: true}
+			if *identity != want {
+				t.Errorf("got identity %+v, want %+v", *identity, want)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	stub := newOIDCStub(t)
+	stub.discoveryIssuer = "https://attacker.example.com"
+	provider := NewOIDCIdentityProvider(OIDCConfig{Name: "stub", Issuer: stub.server.URL, ClientID: "rice-monitor"})
+
+	token := stub.idToken(t, jwt.MapClaims{
+		"iss": stub.server.URL, "aud": "rice-monitor", "sub": "s1", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	identity, err := provider.Authenticate(context.Background(), token)
+	if !errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("got identity %+v, error %v; want ErrInvalidCredential", identity, err)
+	}
+}
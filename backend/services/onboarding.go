@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrDomainNotAllowed is returned when an uninvited email address outside
+	// the allowed domains tries to sign up
+	ErrDomainNotAllowed = errors.New("email domain is not allowed")
+	// ErrInvitationNotFound is returned for unknown invitations
+	ErrInvitationNotFound = errors.New("invitation not found")
+)
+
+// OnboardingService decides who may sign up and with which role. Invited
+// addresses are approved with the role of their invitation. Otherwise, when
+// allowed email domains are configured only those domains may sign up and
+// are approved as observers; otherwise anyone may sign up but waits as
+// pending until an administrator approves the account.
+type OnboardingService struct {
+	firestoreService *FirestoreService
+	mailer           Mailer
+	allowedDomains   map[string]bool
+	invitationTTL    time.Duration
+	appURL           string
+}
+
+func NewOnboardingService(firestoreService *FirestoreService, mailer Mailer, cfg config.OnboardingConfig) *OnboardingService {
+	allowedDomains := make(map[string]bool)
+	for _, domain := range cfg.AllowedEmailDomains {
+		allowedDomains[strings.ToLower(strings.TrimSpace(domain))] = true
+	}
+
+	return &OnboardingService{
+		firestoreService: firestoreService,
+		mailer:           mailer,
+		allowedDomains:   allowedDomains,
+		invitationTTL:    cfg.InvitationTTL,
+		appURL:           cfg.AppURL,
+	}
+}
+
+// DomainAllowed reports whether an email address belongs to an allowed domain
+func (ob *OnboardingService) DomainAllowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	return ob.allowedDomains[strings.ToLower(email[at+1:])]
+}
+
+// MaySignIn reports whether an email address has an account that is not
+// deleted, or can sign up without waiting for approval: it has an open
+// invitation or belongs to an allowed domain
+func (ob *OnboardingService) MaySignIn(ctx context.Context, email string) (bool, error) {
+	if ob.DomainAllowed(email) {
+		return true, nil
+	}
+
+	docs, err := ob.firestoreService.Users().Where("email", "==", email).Documents(ctx).GetAll()
+	if err != nil {
+		return false, err
+	}
+	for _, doc := range docs {
+		var user models.User
+		if err := doc.DataTo(&user); err != nil {
+			return false, err
+		}
+		if user.DeletedAt == nil {
+			return true, nil
+		}
+	}
+
+	var invitation *models.Invitation
+	invitationRef := ob.firestoreService.Invitations().Doc(InvitationID(email))
+	err = ob.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		invitation, err = ob.openInvitation(tx, invitationRef)
+		return err
+	}, firestore.ReadOnly)
+	if err != nil {
+		return false, err
+	}
+
+	return invitation != nil, nil
+}
+
+// CreateUser creates the account for a first login, consuming the
+// invitation for its email address if there is one
+func (ob *OnboardingService) CreateUser(ctx context.Context, identity *models.ExternalIdentity) (*models.User, error) {
+	now := time.Now()
+	user := &models.User{
+		ID:          utils.GenerateID(),
+		Email:       identity.Email,
+		Name:        identity.Name,
+		Picture:     identity.Picture,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		LastLoginAt: now,
+	}
+
+	invitationRef := ob.firestoreService.Invitations().Doc(InvitationID(identity.Email))
+	err := ob.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		invitation, err := ob.openInvitation(tx, invitationRef)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case invitation != nil:
+			user.Role = invitation.Role
+			user.Status = models.UserStatusApproved
+			if err := tx.Update(invitationRef, acceptInvitationUpdates(user.ID)); err != nil {
+				return err
+			}
+		case len(ob.allowedDomains) == 0:
+			user.Role = "observer"
+			user.Status = models.UserStatusPending
+		case ob.DomainAllowed(identity.Email):
+			user.Role = "observer"
+			user.Status = models.UserStatusApproved
+		default:
+			return ErrDomainNotAllowed
+		}
+
+		return tx.Create(ob.firestoreService.Users().Doc(user.ID), user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ApplyInvitation approves a pending user who has since been invited, with
+// the role of the invitation
+func (ob *OnboardingService) ApplyInvitation(ctx context.Context, user *models.User) (*models.User, error) {
+	if user.Status != models.UserStatusPending {
+		return user, nil
+	}
+
+	updated := *user
+	invitationRef := ob.firestoreService.Invitations().Doc(InvitationID(user.Email))
+	userRef := ob.firestoreService.Users().Doc(user.ID)
+	err := ob.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		updated = *user
+
+		invitation, err := ob.openInvitation(tx, invitationRef)
+		if err != nil || invitation == nil {
+			return err
+		}
+
+		updated.Role = invitation.Role
+		updated.Status = models.UserStatusApproved
+		updated.UpdatedAt = time.Now()
+		if err := tx.Update(userRef, []firestore.Update{
+			{Path: "role", Value: updated.Role},
+			{Path: "status", Value: updated.Status},
+			{Path: "updated_at", Value: updated.UpdatedAt},
+		}); err != nil {
+			return err
+		}
+
+		return tx.Update(invitationRef, acceptInvitationUpdates(user.ID))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// Invite creates or replaces the invitation for an email address and emails
+// it when a mailer is configured
+func (ob *OnboardingService) Invite(ctx context.Context, email, role, invitedBy string) (*models.Invitation, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	now := time.Now()
+	invitation := &models.Invitation{
+		ID:        InvitationID(email),
+		Email:     email,
+		Role:      role,
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ob.invitationTTL),
+	}
+
+	if _, err := ob.firestoreService.Invitations().Doc(invitation.ID).Set(ctx, invitation); err != nil {
+		return nil, err
+	}
+
+	if ob.mailer != nil {
+		body := fmt.Sprintf(
+			"You have been invited to Rice Monitor as %s.\n\nSign in with %s at %s before %s to accept the invitation.",
+			role, email, ob.appURL, invitation.ExpiresAt.Format("January 2, 2006"),
+		)
+		if err := ob.mailer.Send(ctx, email, "You're invited to Rice Monitor", body); err != nil {
+			// The invitation works without the email; the admin can share the link
+			slog.WarnContext(ctx, "Failed to send invitation email", "error", err)
+		}
+	}
+
+	return invitation, nil
+}
+
+// ListInvitations returns every invitation, newest first
+func (ob *OnboardingService) ListInvitations(ctx context.Context) ([]models.Invitation, error) {
+	docs, err := ob.firestoreService.Invitations().OrderBy("created_at", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	invitations := []models.Invitation{}
+	for _, doc := range docs {
+		var invitation models.Invitation
+		if err := doc.DataTo(&invitation); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation deletes an invitation
+func (ob *OnboardingService) RevokeInvitation(ctx context.Context, invitationID string) error {
+	docRef := ob.firestoreService.Invitations().Doc(invitationID)
+	if _, err := docRef.Get(ctx); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return ErrInvitationNotFound
+		}
+		return err
+	}
+
+	_, err := docRef.Delete(ctx)
+	return err
+}
+
+// openInvitation returns the invitation if it exists, is unused and has not
+// expired, or nil otherwise
+func (ob *OnboardingService) openInvitation(tx *firestore.Transaction, docRef *firestore.DocumentRef) (*models.Invitation, error) {
+	doc, err := tx.Get(docRef)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var invitation models.Invitation
+	if err := doc.DataTo(&invitation); err != nil {
+		return nil, err
+	}
+	if invitation.AcceptedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return nil, nil
+	}
+
+	return &invitation, nil
+}
+
+func acceptInvitationUpdates(userID string) []firestore.Update {
+	return []firestore.Update{
+		{Path: "accepted_at", Value: time.Now()},
+		{Path: "accepted_by", Value: userID},
+	}
+}
+
+// InvitationID returns the invitations document ID for an email address, so
+// an address has at most one invitation
+func InvitationID(email string) string {
+	return utils.HashToken(strings.ToLower(strings.TrimSpace(email)))
+}
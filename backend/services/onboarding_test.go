@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/models"
+)
+
+func TestCreateUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedDomains []string
+		invitation     *models.Invitation
+		email          string
+		wantRole       string
+		wantStatus     string
+		wantErr        error
+	}{
+		{
+			name:       "open sign-up waits for approval",
+			email:      "farmer@example.com",
+			wantRole:   "observer",
+			wantStatus: models.UserStatusPending,
+		},
+		{
+			name:           "allowed domain",
+			allowedDomains: []string{"irri.org", "Example.com"},
+			email:          "Farmer@EXAMPLE.com",
+			wantRole:       "observer",
+			wantStatus:     models.UserStatusApproved,
+		},
+		{
+			name:           "other domain",
+			allowedDomains: []string{"irri.org"},
+			email:          "farmer@example.com",
+			wantErr:        ErrDomainNotAllowed,
+		},
+		{
+			name:           "invited outside the allowed domains",
+			allowedDomains: []string{"irri.org"},
+			invitation:     &models.Invitation{Email: "farmer@example.com", Role: "researcher", ExpiresAt: time.Now().Add(time.Hour)},
+			email:          "farmer@example.com",
+			wantRole:       "researcher",
+			wantStatus:     models.UserStatusApproved,
+		},
+		{
+			name:           "expired invitation",
+			allowedDomains: []string{"irri.org"},
+			invitation:     &models.Invitation{Email: "farmer@example.com", Role: "researcher", ExpiresAt: time.Now().Add(-time.Hour)},
+			email:          "farmer@example.com",
+			wantErr:        ErrDomainNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fs := newTestFirestore(t)
+			ob := NewOnboardingService(fs, nil, config.OnboardingConfig{
+				AllowedEmailDomains: tt.allowedDomains,
+				InvitationTTL:       time.Hour,
+			})
+
+			if tt.invitation != nil {
+				tt.invitation.ID = InvitationID(tt.invitation.Email)
+				if _, err := fs.Invitations().Doc(tt.invitation.ID).Set(ctx, tt.invitation); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			user, err := ob.CreateUser(ctx, &models.ExternalIdentity{Email: tt.email})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got user %+v, error %v; want %v", user, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateUser: %v", err)
+			}
+			if user.Role != tt.wantRole || user.Status != tt.wantStatus {
+				t.Errorf("got role %s, status %s; want %s, %s", user.Role, user.Status, tt.wantRole, tt.wantStatus)
+			}
+
+			if tt.invitation != nil {
+				doc, err := fs.Invitations().Doc(tt.invitation.ID).Get(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if acceptedBy, _ := doc.DataAt("accepted_by"); acceptedBy != user.ID {
+					t.Errorf("invitation accepted by %v, want %s", acceptedBy, user.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyInvitation(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFirestore(t)
+	ob := NewOnboardingService(fs, nil, config.OnboardingConfig{InvitationTTL: time.Hour})
+
+	pending := createTestUser(t, fs, models.User{ID: "u1", Email: "farmer@example.com", Role: "observer", Status: models.UserStatusPending})
+
+	user, err := ob.ApplyInvitation(ctx, pending)
+	if err != nil {
+		t.Fatalf("ApplyInvitation without an invitation: %v", err)
+	}
+	if user.Status != models.UserStatusPending {
+		t.Errorf("uninvited user became %s", user.Status)
+	}
+
+	if _, err := ob.Invite(ctx, "Farmer@example.com", "researcher", "admin1"); err != nil {
+		t.Fatalf("Invite: %v", err)
+	}
+	user, err = ob.ApplyInvitation(ctx, pending)
+	if err != nil {
+		t.Fatalf("ApplyInvitation: %v", err)
+	}
+	if user.Status != models.UserStatusApproved || user.Role != "researcher" {
+		t.Errorf("got status %s, role %s; want approved researcher", user.Status, user.Role)
+	}
+
+	var stored models.User
+	doc, err := fs.Users().Doc(pending.ID).Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.DataTo(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.UserStatusApproved || stored.Role != "researcher" {
+		t.Errorf("stored status %s, role %s; want approved researcher", stored.Status, stored.Role)
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/models"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// PurgeService permanently removes soft-deleted records, the images of
+// deleted submissions and the sessions, refresh tokens, identities and API
+// keys of deleted users, once they are older than the retention period
+type PurgeService struct {
+	firestoreService *FirestoreService
+	storageService   *StorageService
+	retention        time.Duration
+	interval         time.Duration
+	wg               sync.WaitGroup
+}
+
+// PurgeResult counts what a single purge run removed
+type PurgeResult struct {
+	Submissions int `json:"submissions"`
+	Fields      int `json:"fields"`
+	Users       int `json:"users"`
+	Images      int `json:"images"`
+}
+
+func NewPurgeService(firestoreService *FirestoreService, storageService *StorageService, cfg config.PurgeConfig) *PurgeService {
+	return &PurgeService{
+		firestoreService: firestoreService,
+		storageService:   storageService,
+		retention:        time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		interval:         cfg.Interval,
+	}
+}
+
+// Start runs the purge job immediately and then on every interval until ctx
+// is cancelled
+func (ps *PurgeService) Start(ctx context.Context) {
+	ps.wg.Add(1)
+	go func() {
+		defer ps.wg.Done()
+
+		ticker := time.NewTicker(ps.interval)
+		defer ticker.Stop()
+
+		for {
+			result, err := ps.Purge(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Purge of soft-deleted records failed", "error", err)
+			} else {
+				slog.InfoContext(ctx, "Purged soft-deleted records", "submissions", result.Submissions, "fields", result.Fields, "users", result.Users, "images", result.Images)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Wait blocks until the job started by Start has stopped
+func (ps *PurgeService) Wait() {
+	ps.wg.Wait()
+}
+
+// Purge removes every record soft-deleted before the retention cutoff
+func (ps *PurgeService) Purge(ctx context.Context) (PurgeResult, error) {
+	var result PurgeResult
+	cutoff := time.Now().Add(-ps.retention)
+
+	err := ps.purgeCollection(ctx, ps.firestoreService.Submissions(), cutoff, func(doc *firestore.DocumentSnapshot) error {
+		var submission models.Submission
+		if err := doc.DataTo(&submission); err != nil {
+			return err
+		}
+
+		for _, url := range submission.Images {
+			objectName, ok := ps.storageService.ObjectName(url)
+			if !ok {
+				continue
+			}
+			err := ps.storageService.Bucket().Object(objectName).Delete(ctx)
+			if err == storage.ErrObjectNotExist {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			result.Images++
+		}
+
+		result.Submissions++
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = ps.purgeCollection(ctx, ps.firestoreService.Fields(), cutoff, func(*firestore.DocumentSnapshot) error {
+		result.Fields++
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = ps.purgeCollection(ctx, ps.firestoreService.Users(), cutoff, func(doc *firestore.DocumentSnapshot) error {
+		// Everything that could sign in as the user goes with the account
+		for _, collection := range []*firestore.CollectionRef{
+			ps.firestoreService.Sessions(),
+			ps.firestoreService.RefreshTokens(),
+			ps.firestoreService.Identities(),
+			ps.firestoreService.APIKeys(),
+		} {
+			if err := ps.deleteAll(ctx, collection.Where("user_id", "==", doc.Ref.ID)); err != nil {
+				return err
+			}
+		}
+
+		result.Users++
+		return nil
+	})
+
+	return result, err
+}
+
+// deleteAll deletes every document matching query
+func (ps *PurgeService) deleteAll(ctx context.Context, query firestore.Query) error {
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// purgeCollection deletes documents soft-deleted before cutoff, calling
+// beforeDelete first so dependent resources can be cleaned up
+func (ps *PurgeService) purgeCollection(ctx context.Context, collection *firestore.CollectionRef, cutoff time.Time, beforeDelete func(*firestore.DocumentSnapshot) error) error {
+	iter := collection.Where("deleted_at", "<=", cutoff).Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := beforeDelete(doc); err != nil {
+			return err
+		}
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			return err
+		}
+	}
+}
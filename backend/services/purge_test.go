@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"rice-monitor-api/config"
+	"rice-monitor-api/models"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// waitStopped fails t unless wait returns soon
+func waitStopped(t *testing.T, wait func()) {
+	t.Helper()
+
+	stopped := make(chan struct{})
+	go func() {
+		wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background job did not stop after its context was cancelled")
+	}
+}
+
+func TestPurgeServiceStopsOnCancel(t *testing.T) {
+	fs := newTestFirestore(t)
+	ps := NewPurgeService(fs, nil, config.PurgeConfig{RetentionDays: 30, Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ps.Start(ctx)
+	cancel()
+
+	waitStopped(t, ps.Wait)
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFirestore(t)
+
+	// A fake Cloud Storage JSON API holding one of the two images
+	objects := map[string]bool{"images/leaf.jpg": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutPrefix(r.URL.Path, "/storage/v1/b/test-bucket/o/")
+		if !ok || r.Method != http.MethodDelete {
+			http.NotFound(w, r)
+			return
+		}
+		if !objects[name] {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"No such object"}}`))
+			return
+		}
+		delete(objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	storageService, err := NewStorageService(ctx, "test-bucket", config.Default().Datastore)
+	if err != nil {
+		t.Fatalf("creating storage client: %v", err)
+	}
+	t.Cleanup(func() { storageService.Close() })
+
+	expired := time.Now().AddDate(0, 0, -31)
+	recent := time.Now().AddDate(0, 0, -1)
+	set := func(collection *firestore.CollectionRef, id string, data interface{}) {
+		t.Helper()
+		if _, err := collection.Doc(id).Set(ctx, data); err != nil {
+			t.Fatalf("creating %s/%s: %v", collection.ID, id, err)
+		}
+	}
+
+	set(fs.Submissions(), "expired", &models.Submission{
+		ID:        "expired",
+		Images:    []string{storageService.PublicURL("images/leaf.jpg"), storageService.PublicURL("images/gone.jpg")},
+		DeletedAt: &expired,
+	})
+	set(fs.Fields(), "expired", &models.Field{ID: "expired", DeletedAt: &expired})
+	set(fs.Fields(), "recent", &models.Field{ID: "recent", DeletedAt: &recent})
+	set(fs.Users(), "gone", &models.User{ID: "gone", DeletedAt: &expired})
+	set(fs.Users(), "active", &models.User{ID: "active"})
+	for _, userID := range []string{"gone", "active"} {
+		for _, collection := range []*firestore.CollectionRef{fs.Sessions(), fs.RefreshTokens(), fs.Identities(), fs.APIKeys()} {
+			set(collection, userID, map[string]interface{}{"user_id": userID})
+		}
+	}
+
+	ps := NewPurgeService(fs, storageService, config.PurgeConfig{RetentionDays: 30, Interval: time.Hour})
+	result, err := ps.Purge(ctx)
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+
+	// The image that was already gone is not counted
+	want := PurgeResult{Submissions: 1, Fields: 1, Users: 1, Images: 1}
+	if result != want {
+		t.Errorf("got result %+v, want %+v", result, want)
+	}
+	if len(objects) != 0 {
+		t.Errorf("images left in storage: %v", objects)
+	}
+
+	exists := func(collection *firestore.CollectionRef, id string) bool {
+		_, err := collection.Doc(id).Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			t.Fatalf("reading %s/%s: %v", collection.ID, id, err)
+		}
+		return err == nil
+	}
+
+	tests := []struct {
+		collection *firestore.CollectionRef
+		id         string
+		want       bool
+	}{
+		{collection: fs.Submissions(), id: "expired", want: false},
+		{collection: fs.Fields(), id: "expired", want: false},
+		{collection: fs.Fields(), id: "recent", want: true},
+		{collection: fs.Users(), id: "gone", want: false},
+		{collection: fs.Users(), id: "active", want: true},
+		{collection: fs.Sessions(), id: "gone", want: false},
+		{collection: fs.RefreshTokens(), id: "gone", want: false},
+		{collection: fs.Identities(), id: "gone", want: false},
+		{collection: fs.APIKeys(), id: "gone", want: false},
+		{collection: fs.Sessions(), id: "active", want: true},
+		{collection: fs.RefreshTokens(), id: "active", want: true},
+		{collection: fs.Identities(), id: "active", want: true},
+		{collection: fs.APIKeys(), id: "active", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := exists(tt.collection, tt.id); got != tt.want {
+			t.Errorf("%s/%s exists: got %v, want %v", tt.collection.ID, tt.id, got, tt.want)
+		}
+	}
+}
@@ -2,32 +2,31 @@ package services
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"strings"
+
+	"rice-monitor-api/config"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
 type StorageService struct {
 	Client     *storage.Client
 	BucketName string
-	ctx        context.Context
+	timeouts   config.DatastoreConfig
 }
 
-func NewStorageService(ctx context.Context) (*StorageService, error) {
+func NewStorageService(ctx context.Context, bucketName string, timeouts config.DatastoreConfig) (*StorageService, error) {
 	client, err := storage.NewClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	bucketName := os.Getenv("STORAGE_BUCKET")
-	if bucketName == "" {
-		bucketName = "rice-monitor-images-dev" // fallback for development
-	}
-
 	return &StorageService{
 		Client:     client,
 		BucketName: bucketName,
-		ctx:        ctx,
+		timeouts:   timeouts,
 	}, nil
 }
 
@@ -39,6 +38,37 @@ func (ss *StorageService) Bucket() *storage.BucketHandle {
 	return ss.Client.Bucket(ss.BucketName)
 }
 
-func (ss *StorageService) Context() context.Context {
-	return ss.ctx
+// Ping checks that the bucket is reachable by listing at most one object
+func (ss *StorageService) Ping(ctx context.Context) error {
+	_, err := ss.Bucket().Objects(ctx, &storage.Query{Prefix: "_health/"}).Next()
+	if err == iterator.Done {
+		return nil
+	}
+	return err
+}
+
+// WithTimeout bounds the storage work done for parent, usually a request
+// context, so it stops when the client disconnects or the timeout passes
+func (ss *StorageService) WithTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, ss.timeouts.OperationTimeout)
+}
+
+// WithUploadTimeout is WithTimeout for uploads, which stream the client's
+// request body and can take longer on slow connections
+func (ss *StorageService) WithUploadTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, ss.timeouts.UploadTimeout)
+}
+
+// PublicURL returns the public URL of an object in the bucket
+func (ss *StorageService) PublicURL(objectName string) string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", ss.BucketName, objectName)
+}
+
+// ObjectName extracts the object name from a public URL produced by PublicURL
+func (ss *StorageService) ObjectName(publicURL string) (string, bool) {
+	prefix := ss.PublicURL("")
+	if !strings.HasPrefix(publicURL, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(publicURL, prefix), true
 }
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token is
+	// presented again; the whole session is revoked when this happens
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+// ClientInfo describes the device a session was created or last used from
+type ClientInfo struct {
+	Device    string
+	IPAddress string
+	UserAgent string
+}
+
+// TokenPair is a freshly issued access and refresh token
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    string
+	ExpiresIn    int64
+}
+
+// TokenService issues JWTs and keeps the server-side session and refresh
+// token records that make rotation, reuse detection and revocation possible
+type TokenService struct {
+	firestoreService *FirestoreService
+}
+
+func NewTokenService(firestoreService *FirestoreService) *TokenService {
+	return &TokenService{
+		firestoreService: firestoreService,
+	}
+}
+
+// IssueTokens starts a new session, and with it a new refresh token family,
+// for a fresh login
+func (ts *TokenService) IssueTokens(ctx context.Context, user *models.User, client ClientInfo) (*TokenPair, error) {
+	record := ts.newRefreshToken(user.ID, utils.GenerateID())
+
+	session := &models.Session{
+		ID:         record.FamilyID,
+		UserID:     user.ID,
+		Device:     client.Device,
+		IPAddress:  client.IPAddress,
+		UserAgent:  client.UserAgent,
+		CreatedAt:  record.CreatedAt,
+		LastUsedAt: record.CreatedAt,
+		ExpiresAt:  record.ExpiresAt,
+	}
+
+	err := ts.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := tx.Create(ts.firestoreService.Sessions().Doc(session.ID), session); err != nil {
+			return err
+		}
+		return tx.Create(ts.firestoreService.RefreshTokens().Doc(record.ID), record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ts.tokenPair(user, record)
+}
+
+// RotateRefreshToken exchanges a refresh token for a new pair in the same
+// session. The presented token is marked used; presenting it again revokes
+// the whole session.
+func (ts *TokenService) RotateRefreshToken(ctx context.Context, refreshToken string, client ClientInfo) (*models.User, *TokenPair, error) {
+	claims, err := utils.ValidateRefreshToken(refreshToken)
+	// Tokens issued before sessions existed carry no family and cannot rotate
+	if err != nil || claims.ID == "" || claims.FamilyID == "" {
+		return nil, nil, ErrRefreshTokenInvalid
+	}
+
+	var user models.User
+	var next *models.RefreshToken
+	reused := false
+
+	currentRef := ts.firestoreService.RefreshTokens().Doc(claims.ID)
+	sessionRef := ts.firestoreService.Sessions().Doc(claims.FamilyID)
+	userRef := ts.firestoreService.Users().Doc(claims.UserID)
+
+	err = ts.firestoreService.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		reused = false
+
+		doc, err := tx.Get(currentRef)
+		if err != nil {
+			return ErrRefreshTokenInvalid
+		}
+
+		var current models.RefreshToken
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+
+		if current.UserID != claims.UserID || current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+			return ErrRefreshTokenInvalid
+		}
+		if current.UsedAt != nil {
+			reused = true
+			return nil
+		}
+
+		sessionDoc, err := tx.Get(sessionRef)
+		if err != nil {
+			return ErrRefreshTokenInvalid
+		}
+		var session models.Session
+		if err := sessionDoc.DataTo(&session); err != nil {
+			return err
+		}
+		if session.RevokedAt != nil {
+			return ErrRefreshTokenInvalid
+		}
+
+		userDoc, err := tx.Get(userRef)
+		if err != nil {
+			return ErrRefreshTokenInvalid
+		}
+		if err := userDoc.DataTo(&user); err != nil {
+			return err
+		}
+		if user.DeletedAt != nil || !user.IsApproved() {
+			return ErrRefreshTokenInvalid
+		}
+
+		next = ts.newRefreshToken(current.UserID, current.FamilyID)
+		if err := tx.Update(currentRef, []firestore.Update{
+			{Path: "used_at", Value: next.CreatedAt},
+			{Path: "replaced_by", Value: next.ID},
+		}); err != nil {
+			return err
+		}
+
+		if err := tx.Update(sessionRef, []firestore.Update{
+			{Path: "ip_address", Value: client.IPAddress},
+			{Path: "user_agent", Value: client.UserAgent},
+			{Path: "last_used_at", Value: next.CreatedAt},
+			{Path: "expires_at", Value: next.ExpiresAt},
+		}); err != nil {
+			return err
+		}
+
+		return tx.Create(ts.firestoreService.RefreshTokens().Doc(next.ID), next)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if reused {
+		if err := ts.RevokeSession(ctx, claims.FamilyID); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	pair, err := ts.tokenPair(&user, next)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &user, pair, nil
+}
+
+// GetSession returns a single session
+func (ts *TokenService) GetSession(ctx context.Context, sessionID string) (*models.Session, error) {
+	doc, err := ts.firestoreService.Sessions().Doc(sessionID).Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var session models.Session
+	if err := doc.DataTo(&session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func (ts *TokenService) ListSessions(ctx context.Context, userID string) ([]models.Session, error) {
+	docs, err := ts.firestoreService.Sessions().Where("user_id", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sessions := []models.Session{}
+	for _, doc := range docs {
+		var session models.Session
+		if err := doc.DataTo(&session); err != nil {
+			return nil, err
+		}
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// TouchSession records that a session was just used
+func (ts *TokenService) TouchSession(ctx context.Context, sessionID string) error {
+	_, err := ts.firestoreService.Sessions().Doc(sessionID).Update(ctx, []firestore.Update{
+		{Path: "last_used_at", Value: time.Now()},
+	})
+	return err
+}
+
+// RevokeSession ends one session and revokes every refresh token issued in it
+func (ts *TokenService) RevokeSession(ctx context.Context, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	_, err := ts.firestoreService.Sessions().Doc(sessionID).Update(ctx, []firestore.Update{
+		{Path: "revoked_at", Value: time.Now()},
+	})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	return ts.revoke(ctx, ts.firestoreService.RefreshTokens().Where("family_id", "==", sessionID))
+}
+
+// RevokeAllForUser ends every session of a user. API keys are separate
+// credentials and stay active; see RevokeAPIKeysForUser.
+func (ts *TokenService) RevokeAllForUser(ctx context.Context, userID string) error {
+	if err := ts.revoke(ctx, ts.firestoreService.Sessions().Where("user_id", "==", userID)); err != nil {
+		return err
+	}
+	return ts.revoke(ctx, ts.firestoreService.RefreshTokens().Where("user_id", "==", userID))
+}
+
+// RevokeAPIKeysForUser revokes every API key of a user, for when the account
+// itself goes away
+func (ts *TokenService) RevokeAPIKeysForUser(ctx context.Context, userID string) error {
+	return ts.revoke(ctx, ts.firestoreService.APIKeys().Where("user_id", "==", userID))
+}
+
+// revoke sets revoked_at on every matching document not yet revoked
+func (ts *TokenService) revoke(ctx context.Context, query firestore.Query) error {
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	now := time.Now()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if revokedAt, err := doc.DataAt("revoked_at"); err == nil && revokedAt != nil {
+			continue
+		}
+
+		_, err = doc.Ref.Update(ctx, []firestore.Update{{Path: "revoked_at", Value: now}})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ts *TokenService) newRefreshToken(userID, familyID string) *models.RefreshToken {
+	now := time.Now()
+	return &models.RefreshToken{
+		ID:        utils.GenerateID(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(utils.RefreshTokenTTL),
+	}
+}
+
+func (ts *TokenService) tokenPair(user *models.User, record *models.RefreshToken) (*TokenPair, error) {
+	accessToken, err := utils.GenerateAccessToken(user, record.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.GenerateRefreshToken(record.UserID, record.ID, record.FamilyID, record.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		SessionID:    record.FamilyID,
+		ExpiresIn:    int64(utils.AccessTokenTTL.Seconds()),
+	}, nil
+}
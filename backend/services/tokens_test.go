@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+	"rice-monitor-api/utils"
+)
+
+func createTestUser(t *testing.T, fs *FirestoreService, user models.User) *models.User {
+	t.Helper()
+
+	if _, err := fs.Users().Doc(user.ID).Set(context.Background(), user); err != nil {
+		t.Fatalf("creating user %s: %v", user.ID, err)
+	}
+	return &user
+}
+
+func TestRotateRefreshTokenDetectsReuse(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFirestore(t)
+	ts := NewTokenService(fs)
+	user := createTestUser(t, fs, models.User{ID: "u1", Email: "u1@example.com", Role: "researcher"})
+
+	first, err := ts.IssueTokens(ctx, user, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+
+	_, second, err := ts.RotateRefreshToken(ctx, first.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("first rotation: %v", err)
+	}
+	if second.SessionID != first.SessionID {
+		t.Errorf("rotation moved the token to session %s, want %s", second.SessionID, first.SessionID)
+	}
+
+	// Replaying the rotated token revokes the session, including the token
+	// that replaced it
+	if _, _, err := ts.RotateRefreshToken(ctx, first.RefreshToken, ClientInfo{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("replayed token: got %v, want ErrRefreshTokenReused", err)
+	}
+	if _, _, err := ts.RotateRefreshToken(ctx, second.RefreshToken, ClientInfo{}); !errors.Is(err, ErrRefreshTokenInvalid) {
+		t.Fatalf("token from the revoked session: got %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	session, err := ts.GetSession(ctx, first.SessionID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if session.RevokedAt == nil {
+		t.Error("session is still active after refresh token reuse")
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFirestore(t)
+	ts := NewTokenService(fs)
+	user := createTestUser(t, fs, models.User{ID: "u1", Email: "u1@example.com", Role: "researcher"})
+	other := createTestUser(t, fs, models.User{ID: "u2", Email: "u2@example.com", Role: "researcher"})
+
+	laptop, err := ts.IssueTokens(ctx, user, ClientInfo{Device: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, err := ts.IssueTokens(ctx, user, ClientInfo{Device: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.IssueTokens(ctx, other, ClientInfo{Device: "tablet"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.RevokeSession(ctx, laptop.SessionID); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := ts.ListSessions(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != phone.SessionID || sessions[0].Device != "phone" {
+		t.Errorf("got sessions %+v, want only the phone session %s", sessions, phone.SessionID)
+	}
+}
+
+func TestRotateRefreshTokenRejects(t *testing.T) {
+	ctx := context.Background()
+	fs := newTestFirestore(t)
+	ts := NewTokenService(fs)
+	user := createTestUser(t, fs, models.User{ID: "u1", Email: "u1@example.com", Role: "researcher"})
+	deletedAt := time.Now()
+	deleted := createTestUser(t, fs, models.User{ID: "u2", Email: "u2@example.com", Role: "researcher", DeletedAt: &deletedAt})
+
+	issued, err := ts.IssueTokens(ctx, user, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	issuedToDeleted, err := ts.IssueTokens(ctx, deleted, ClientInfo{})
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	unknown, err := utils.GenerateRefreshToken(user.ID, "no-such-token", issued.SessionID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "malformed", token: "not-a-jwt"},
+		{name: "access token", token: issued.AccessToken},
+		{name: "unknown token ID", token: unknown},
+		{name: "deleted user", token: issuedToDeleted.RefreshToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ts.RotateRefreshToken(ctx, tt.token, ClientInfo{}); !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("got %v, want ErrRefreshTokenInvalid", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"rice-monitor-api/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// defaultJWTSecret is the placeholder secret older deployments fell back to
+const defaultJWTSecret = "your-secret-key"
+
+// SigningKey is the key new tokens are signed with
+type SigningKey struct {
+	ID     string // kid header; empty for the shared-secret provider
+	Method jwt.SigningMethod
+	Key    interface{}
+}
+
+// KeyProvider supplies the keys used to sign and verify JWTs
+type KeyProvider interface {
+	// SigningKey returns the key new tokens are signed with
+	SigningKey() (*SigningKey, error)
+	// VerificationKey returns the key that verifies tokens carrying kid
+	VerificationKey(kid string) (jwt.SigningMethod, interface{}, error)
+	// PublicKeys returns the verification keys other services may use
+	PublicKeys() []models.JWK
+}
+
+var keyProvider KeyProvider
+
+// SetKeyProvider installs the provider used by token generation and validation
+func SetKeyProvider(provider KeyProvider) {
+	keyProvider = provider
+}
+
+// hmacKeyProvider signs with a single shared HS256 secret. It cannot be
+// published as a JWKS and is meant for local development.
+type hmacKeyProvider struct {
+	secret []byte
+}
+
+// NewHMACKeyProvider returns an HS256 provider, refusing a missing or
+// placeholder secret outside development
+func NewHMACKeyProvider(secret string, development bool) (KeyProvider, error) {
+	if secret == "" || secret == defaultJWTSecret {
+		if !development {
+			return nil, errors.New("JWT_SECRET must be set to a non-default value outside development")
+		}
+		slog.Warn("Using the default JWT secret; do not use this outside development")
+		secret = defaultJWTSecret
+	}
+
+	return &hmacKeyProvider{secret: []byte(secret)}, nil
+}
+
+func (hp *hmacKeyProvider) SigningKey() (*SigningKey, error) {
+	return &SigningKey{Method: jwt.SigningMethodHS256, Key: hp.secret}, nil
+}
+
+func (hp *hmacKeyProvider) VerificationKey(kid string) (jwt.SigningMethod, interface{}, error) {
+	if kid != "" {
+		return nil, nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return jwt.SigningMethodHS256, hp.secret, nil
+}
+
+func (hp *hmacKeyProvider) PublicKeys() []models.JWK {
+	return []models.JWK{}
+}
+
+func signToken(claims jwt.Claims) (string, error) {
+	if keyProvider == nil {
+		return "", errors.New("token signing keys are not configured")
+	}
+
+	signingKey, err := keyProvider.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(signingKey.Method, claims)
+	if signingKey.ID != "" {
+		token.Header["kid"] = signingKey.ID
+	}
+
+	return token.SignedString(signingKey.Key)
+}
+
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if keyProvider == nil {
+		return nil, errors.New("token signing keys are not configured")
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	method, key, err := keyProvider.VerificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Method.Alg() != method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key, nil
+}
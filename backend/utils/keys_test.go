@@ -0,0 +1,26 @@
+package utils
+
+import "testing"
+
+func TestNewHMACKeyProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		development bool
+		secret      string
+		wantErr     bool
+	}{
+		{name: "secret outside development", secret: "s3cr3t"},
+		{name: "missing secret outside development", secret: "", wantErr: true},
+		{name: "default secret outside development", secret: "your-secret-key", wantErr: true},
+		{name: "missing secret in development", development: true, secret: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHMACKeyProvider(tt.secret, tt.development)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
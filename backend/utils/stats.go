@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"math"
+	"sort"
+
+	"rice-monitor-api/models"
+)
+
+// DescribeValues computes the summary statistics of values, which it sorts
+// in place. No values give zero statistics.
+func DescribeValues(values []float64) models.TraitStatistics {
+	n := len(values)
+	if n == 0 {
+		return models.TraitStatistics{}
+	}
+	sort.Float64s(values)
+
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(n)
+
+	stdDev := 0.0
+	if n > 1 {
+		squares := 0.0
+		for _, value := range values {
+			squares += (value - mean) * (value - mean)
+		}
+		stdDev = math.Sqrt(squares / float64(n-1))
+	}
+
+	return models.TraitStatistics{
+		N:      n,
+		Mean:   mean,
+		StdDev: stdDev,
+		Min:    values[0],
+		Q1:     Quantile(values, 0.25),
+		Median: Quantile(values, 0.5),
+		Q3:     Quantile(values, 0.75),
+		Max:    values[n-1],
+	}
+}
+
+// Quantile returns the q-th quantile of sorted values, interpolating
+// linearly between the two nearest ones
+func Quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := q * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"math"
+	"testing"
+
+	"rice-monitor-api/models"
+)
+
+func TestDescribeValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   models.TraitStatistics
+	}{
+		{name: "no values", values: nil, want: models.TraitStatistics{}},
+		{
+			name:   "single value",
+			values: []float64{80},
+			want:   models.TraitStatistics{N: 1, Mean: 80, Min: 80, Q1: 80, Median: 80, Q3: 80, Max: 80},
+		},
+		{
+			name:   "unsorted values",
+			values: []float64{4, 1, 3, 2},
+			want:   models.TraitStatistics{N: 4, Mean: 2.5, StdDev: 1.2909944, Min: 1, Q1: 1.75, Median: 2.5, Q3: 3.25, Max: 4},
+		},
+		{
+			name:   "odd count",
+			values: []float64{90, 100, 110, 95, 105},
+			want:   models.TraitStatistics{N: 5, Mean: 100, StdDev: 7.9056942, Min: 90, Q1: 95, Median: 100, Q3: 105, Max: 110},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DescribeValues(tt.values)
+
+			near := func(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+			if got.N != tt.want.N || !near(got.Mean, tt.want.Mean) || !near(got.StdDev, tt.want.StdDev) ||
+				got.Min != tt.want.Min || !near(got.Q1, tt.want.Q1) || !near(got.Median, tt.want.Median) ||
+				!near(got.Q3, tt.want.Q3) || got.Max != tt.want.Max {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
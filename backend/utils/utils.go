@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"os"
 	"time"
 
 	"rice-monitor-api/models"
@@ -11,82 +14,130 @@ import (
 	"github.com/google/uuid"
 )
 
-var jwtSecret = []byte(getEnvOrDefault("JWT_SECRET", "your-secret-key"))
-
 // GenerateID generates a new UUID
 func GenerateID() string {
 	return uuid.New().String()
 }
 
-// GetEnvOrDefault gets environment variable or returns default value
-func GetEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// GenerateSecureToken returns a URL-safe random token with n bytes of entropy
+func GenerateSecureToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return defaultValue
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	return GetEnvOrDefault(key, defaultValue)
+// HashToken returns the hex SHA-256 of a secret so it can be stored and
+// looked up without keeping the secret itself
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-// GenerateTokens generates JWT access and refresh tokens
-func GenerateTokens(user *models.User) (string, string, error) {
-	// Access token (1 hour)
-	accessClaims := &models.Claims{
-		UserID: user.ID,
-		Email:  user.Email,
-		Role:   user.Role,
+// Token types carried in the token_type claim so an access token can never
+// be used as a refresh token and vice versa
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+)
+
+// TokenIssuer and TokenAudience fill the iss and aud claims of the tokens
+// this API issues. Both are checked on parse, so tokens minted for another
+// service with a shared key are refused.
+const (
+	TokenIssuer   = "rice-monitor-api"
+	TokenAudience = "rice-monitor-api"
+)
+
+// Token lifetimes
+const (
+	AccessTokenTTL  = time.Hour
+	RefreshTokenTTL = time.Hour * 24 * 7
+)
+
+// GenerateAccessToken generates a short-lived JWT access token bound to a
+// refresh token family
+func GenerateAccessToken(user *models.User, familyID string) (string, error) {
+	claims := &models.Claims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Role:      user.Role,
+		TokenType: AccessTokenType,
+		FamilyID:  familyID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Issuer:    TokenIssuer,
+			Audience:  jwt.ClaimStrings{TokenAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessTokenString, err := accessToken.SignedString(jwtSecret)
-	if err != nil {
-		return "", "", err
-	}
+	return signToken(claims)
+}
 
-	// Refresh token (7 days)
-	refreshClaims := &models.Claims{
-		UserID: user.ID,
-		Email:  user.Email,
-		Role:   user.Role,
+// GenerateRefreshToken generates a JWT refresh token whose ID (jti) refers to
+// the server-side record that tracks its rotation and revocation
+func GenerateRefreshToken(userID, tokenID, familyID string, expiresAt time.Time) (string, error) {
+	claims := &models.Claims{
+		UserID:    userID,
+		TokenType: RefreshTokenType,
+		FamilyID:  familyID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 24 * 7)),
+			ID:        tokenID,
+			Issuer:    TokenIssuer,
+			Audience:  jwt.ClaimStrings{TokenAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshTokenString, err := refreshToken.SignedString(jwtSecret)
+	return signToken(claims)
+}
+
+// ValidateToken validates a JWT token and returns claims
+func ValidateToken(tokenString string) (*models.Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, verificationKey)
+
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*models.Claims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if !claims.VerifyIssuer(TokenIssuer, true) {
+		return nil, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if !claims.VerifyAudience(TokenAudience, true) {
+		return nil, fmt.Errorf("unexpected token audience %v", claims.Audience)
 	}
 
-	return accessTokenString, refreshTokenString, nil
+	return claims, nil
 }
 
-// ValidateToken validates a JWT token and returns claims
-func ValidateToken(tokenString string) (*models.Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSecret, nil
-	})
+// ValidateAccessToken validates a JWT and requires it to be an access token
+func ValidateAccessToken(tokenString string) (*models.Claims, error) {
+	return validateTokenType(tokenString, AccessTokenType)
+}
+
+// ValidateRefreshToken validates a JWT and requires it to be a refresh token
+func ValidateRefreshToken(tokenString string) (*models.Claims, error) {
+	return validateTokenType(tokenString, RefreshTokenType)
+}
 
+func validateTokenType(tokenString, tokenType string) (*models.Claims, error) {
+	claims, err := ValidateToken(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*models.Claims); ok && token.Valid {
-		return claims, nil
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("expected %s token, got %q", tokenType, claims.TokenType)
 	}
 
-	return nil, fmt.Errorf("invalid token")
+	return claims, nil
 }
 
 // FormatDate formats time to date string
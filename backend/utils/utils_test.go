@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"rice-monitor-api/models"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestValidateAccessToken(t *testing.T) {
+	provider, err := NewHMACKeyProvider("test-secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyProvider(provider)
+
+	expiresAt := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	tests := []struct {
+		name    string
+		claims  *models.Claims
+		wantErr bool
+	}{
+		{
+			name: "issued by the API",
+			claims: &models.Claims{UserID: "u1", TokenType: AccessTokenType, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer: TokenIssuer, Audience: jwt.ClaimStrings{TokenAudience}, ExpiresAt: expiresAt,
+			}},
+		},
+		{
+			name: "among other audiences",
+			claims: &models.Claims{UserID: "u1", TokenType: AccessTokenType, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer: TokenIssuer, Audience: jwt.ClaimStrings{"reports", TokenAudience}, ExpiresAt: expiresAt,
+			}},
+		},
+		{
+			name: "other issuer",
+			claims: &models.Claims{UserID: "u1", TokenType: AccessTokenType, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer: "reports", Audience: jwt.ClaimStrings{TokenAudience}, ExpiresAt: expiresAt,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "no issuer",
+			claims: &models.Claims{UserID: "u1", TokenType: AccessTokenType, RegisteredClaims: jwt.RegisteredClaims{
+				Audience: jwt.ClaimStrings{TokenAudience}, ExpiresAt: expiresAt,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "other audience",
+			claims: &models.Claims{UserID: "u1", TokenType: AccessTokenType, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer: TokenIssuer, Audience: jwt.ClaimStrings{"reports"}, ExpiresAt: expiresAt,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "no audience",
+			claims: &models.Claims{UserID: "u1", TokenType: AccessTokenType, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer: TokenIssuer, ExpiresAt: expiresAt,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "refresh token",
+			claims: &models.Claims{UserID: "u1", TokenType: RefreshTokenType, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer: TokenIssuer, Audience: jwt.ClaimStrings{TokenAudience}, ExpiresAt: expiresAt,
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := signToken(tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ValidateAccessToken(token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGeneratedTokensValidate(t *testing.T) {
+	provider, err := NewHMACKeyProvider("test-secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyProvider(provider)
+
+	access, err := GenerateAccessToken(&models.User{ID: "u1", Role: "observer"}, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := ValidateAccessToken(access); err != nil || claims.Issuer != TokenIssuer {
+		t.Errorf("access token: got %+v, %v", claims, err)
+	}
+
+	refresh, err := GenerateRefreshToken("u1", "r1", "s1", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims, err := ValidateRefreshToken(refresh); err != nil || claims.Issuer != TokenIssuer {
+		t.Errorf("refresh token: got %+v, %v", claims, err)
+	}
+}
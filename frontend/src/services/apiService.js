@@ -14,11 +14,19 @@ class ApiService {
   // Helper method to handle responses
   async handleResponse(response) {
     if (!response.ok) {
-      const error = await response.json().catch(() => ({
-        error: 'network_error',
-        message: `HTTP ${response.status}: ${response.statusText}`
+      // Errors are RFC 7807 problem details: a stable code, a human-readable
+      // detail and, for validation errors, the invalid fields
+      const problem = await response.json().catch(() => ({
+        code: 'network_error',
+        detail: `HTTP ${response.status}: ${response.statusText}`
       }));
-      throw new Error(error.message || 'An error occurred');
+      const fields = (problem.errors || []).map((e) => `${e.field} ${e.message}`);
+      const message = [problem.detail || problem.title || 'An error occurred', ...fields].join('; ');
+      const error = new Error(message);
+      error.code = problem.code;
+      error.status = response.status;
+      error.requestId = problem.request_id;
+      throw error;
     }
     return response.json();
   }
@@ -194,6 +202,22 @@ class ApiService {
     return this.handleResponse(response);
   }
 
+  async getTrendSeries(params = {}) {
+    const queryParams = new URLSearchParams(params);
+    const response = await fetch(`${API_BASE_URL}/analytics/trends/series?${queryParams}`, {
+      headers: this.getAuthHeaders()
+    });
+    return this.handleResponse(response);
+  }
+
+  async getStageProgress(params = {}) {
+    const queryParams = new URLSearchParams(params);
+    const response = await fetch(`${API_BASE_URL}/analytics/stages?${queryParams}`, {
+      headers: this.getAuthHeaders()
+    });
+    return this.handleResponse(response);
+  }
+
   async getReports(params = {}) {
     const queryParams = new URLSearchParams(params);
     const response = await fetch(`${API_BASE_URL}/analytics/reports?${queryParams}`, {